@@ -10,6 +10,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
 // GenerateVouchersInput holds all data needed when creating vouchers
@@ -28,40 +29,63 @@ type CreditRequestInput struct {
 // ListUsersHandler lists all users
 func (h *Handler) ListUsersHandler(c *gin.Context) {
 
-	users, err := h.db.ListAllUsers()
+	users, err := h.db.WithContext(c).ListAllUsers()
 	if err != nil {
-		log.Error().Err(err).Msg("failed to list all users")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("failed to list all users")
+		abortInternal(c)
 		return
 	}
 
 	c.JSON(http.StatusOK, users)
 }
 
-// DeleteUsersHandler deletes user from system
+// DeleteUsersHandler deletes user from system along with organizations it is the only member of
 func (h *Handler) DeleteUsersHandler(c *gin.Context) {
 	userID := c.Param("user_id")
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "User ID is required")
 		return
 	}
 
-	authUserID := c.GetString("user_id")
-	if userID == authUserID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admins cannot delete their own account"})
+	ID, err := strconv.Atoi(userID)
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	if ID == c.GetInt("user_id") {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Admins cannot delete their own account")
+		return
+	}
+
+	_, err = h.db.WithContext(c).GetUserByID(ID)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User is not found")
 		return
 	}
 
-	ID, err := strconv.Atoi(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		log.Ctx(c).Error().Err(err).Str("user_id", userID).Msg("failed to get user")
+		abortInternal(c)
+		return
+	}
+
+	orphaned, ok := h.soleOwnedOrganizations(c, ID)
+	if !ok {
 		return
 	}
 
-	err = h.db.DeleteUserByID(ID)
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		for _, organizationID := range orphaned {
+			if err := tx.DeleteOrganization(organizationID); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteUserByID(ID)
+	})
 	if err != nil {
-		log.Error().Err(err).Str("user_id", userID).Msg("Failed to delete user")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Str("user_id", userID).Msg("Failed to delete user")
+		abortInternal(c)
 		return
 	}
 
@@ -69,20 +93,63 @@ func (h *Handler) DeleteUsersHandler(c *gin.Context) {
 
 }
 
+// soleOwnedOrganizations lists organizations the user is the only member of, to be deleted with it.
+// It responds with a conflict if the user is the only owner of an organization with other members.
+func (h *Handler) soleOwnedOrganizations(c *gin.Context, userID int) ([]int, bool) {
+	organizations, err := h.db.WithContext(c).ListUserOrganizations(userID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", userID).Msg("failed to list user organizations")
+		abortInternal(c)
+		return nil, false
+	}
+
+	var orphaned []int
+	for _, organization := range organizations {
+		if organization.Role != models.OrganizationRoleOwner {
+			continue
+		}
+
+		owners, err := h.db.WithContext(c).CountOrganizationOwners(organization.ID)
+		if err != nil {
+			log.Ctx(c).Error().Err(err).Msg("failed to count organization owners")
+			abortInternal(c)
+			return nil, false
+		}
+		if owners > 1 {
+			continue
+		}
+
+		members, err := h.db.WithContext(c).ListOrganizationMembers(organization.ID)
+		if err != nil {
+			log.Ctx(c).Error().Err(err).Msg("failed to list organization members")
+			abortInternal(c)
+			return nil, false
+		}
+		if len(members) > 1 {
+			abort(c, http.StatusConflict, internal.ErrCodeConflict, fmt.Sprintf("User is the only owner of organization %d, transfer its ownership first", organization.ID))
+			return nil, false
+		}
+
+		orphaned = append(orphaned, organization.ID)
+	}
+
+	return orphaned, true
+}
+
 // GenerateVouchersHandler generates bulk of vouchers
 func (h *Handler) GenerateVouchersHandler(c *gin.Context) {
 	var request GenerateVouchersInput
 
 	// check on request format
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
 	var vouchers []models.Voucher
 	for i := 0; i < request.Count; i++ {
-		voucherCode := internal.GenerateRandomVoucher(h.config.Voucher.NameLength)
+		voucherCode := internal.GenerateRandomVoucher(h.config.Current().Voucher.NameLength)
 		timestampPart := fmt.Sprintf("%02d%02d", time.Now().Minute(), time.Now().Second())
 		fullCode := fmt.Sprintf("%s-%s", voucherCode, timestampPart)
 
@@ -93,9 +160,9 @@ func (h *Handler) GenerateVouchersHandler(c *gin.Context) {
 			ExpiresAt: time.Now().Add(time.Duration(request.ExpireAfter) * 24 * time.Hour),
 		}
 
-		if err := h.db.CreateVoucher(&voucher); err != nil {
-			log.Error().Err(err).Msg("failed to create voucher")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		if err := h.db.WithContext(c).CreateVoucher(&voucher); err != nil {
+			log.Ctx(c).Error().Err(err).Msg("failed to create voucher")
+			abortInternal(c)
 			return
 		}
 
@@ -112,10 +179,10 @@ func (h *Handler) GenerateVouchersHandler(c *gin.Context) {
 // ListVouchersHandler returns all vouchers in system
 func (h *Handler) ListVouchersHandler(c *gin.Context) {
 
-	vouchers, err := h.db.ListAllVouchers()
+	vouchers, err := h.db.WithContext(c).ListAllVouchers()
 	if err != nil {
-		log.Error().Err(err).Msg("failed to list all vouchers")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("failed to list all vouchers")
+		abortInternal(c)
 		return
 	}
 
@@ -125,34 +192,34 @@ func (h *Handler) ListVouchersHandler(c *gin.Context) {
 func (h *Handler) CreditUserHandler(c *gin.Context) {
 	userID := c.Param("user_id")
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "User ID is required")
 		return
 	}
 
 	var request CreditRequestInput
 	// check on request format
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		abortBinding(c, err)
 		return
 	}
 
 	ID, err := strconv.Atoi(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
 		return
 	}
 
-	user, err := h.db.GetUserByID(ID)
+	user, err := h.db.WithContext(c).GetUserByID(ID)
 	if err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
 		return
 	}
 
 	// get admin ID from middleware context
 	adminID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Admin ID not found in context"})
+		abort(c, http.StatusUnauthorized, internal.ErrCodeUnauthorized, "Admin ID not found in context")
 		return
 	}
 
@@ -164,18 +231,39 @@ func (h *Handler) CreditUserHandler(c *gin.Context) {
 		CreatedAt: time.Now(),
 	}
 
-	if err := h.db.CreateTransaction(&transaction); err != nil {
-		log.Error().Err(err).Msg("Failed to create credit transaction")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	if err := h.db.WithContext(c).CreateTransaction(&transaction); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("Failed to create credit transaction")
+		abortInternal(c)
 		return
 	}
 
-	if err := h.db.CreditUserBalance(user.ID, request.Amount); err != nil {
-		log.Error().Err(err).Msg("Failed to credit user")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	if err := h.db.WithContext(c).CreditUserBalance(user.ID, request.Amount); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("Failed to credit user")
+		abortInternal(c)
 		return
 	}
 
+	credit := gin.H{
+		"user_id": user.ID,
+		"amount":  request.Amount,
+		"memo":    request.Memo,
+	}
+
+	err = h.notifier.Notify(user.ID, internal.Notification{
+		Event:   models.EventBalanceCredited,
+		Title:   "Your balance is credited",
+		Message: fmt.Sprintf("%.2f has been added to your balance: %s", request.Amount, request.Memo),
+		Data:    credit,
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", user.ID).Msg("failed to notify user")
+	}
+
+	// webhooks of user are reached through its notification preferences
+	if err := h.webhooks.PublishGlobal(user.ID, models.EventBalanceCredited, credit); err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", user.ID).Msg("failed to publish webhook event")
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User credited successfully",
 		"user":    user.Email,
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// APITokenInput holds data needed to create a personal access token
+type APITokenInput struct {
+	Name          string   `json:"name" binding:"required,min=3,max=64"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=read write admin"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,gt=0,max=365"` // never expires if not set
+}
+
+// CreateAPITokenHandler creates a personal access token for the logged in user
+func (h *Handler) CreateAPITokenHandler(c *gin.Context) {
+	// tokens must not be able to mint new tokens
+	if !loggedIn(c) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Tokens can only be created after logging in")
+		return
+	}
+
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request APITokenInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if scope == models.ScopeAdmin && !c.GetBool("admin") {
+			abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Admin access required for admin scope")
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		expiry := time.Now().Add(time.Duration(request.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &expiry
+	}
+
+	token, apiToken, err := h.apiTokens.Create(ID, request.Name, request.Scopes, expiresAt)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to create api token")
+		abortInternal(c)
+		return
+	}
+
+	// token is only shown once
+	c.JSON(http.StatusCreated, gin.H{
+		"token":     token,
+		"api_token": apiToken,
+	})
+}
+
+// ListAPITokensHandler lists personal access tokens of the logged in user
+func (h *Handler) ListAPITokensHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	tokens, err := h.db.WithContext(c).ListUserAPITokens(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list api tokens")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeAPITokenHandler revokes a personal access token of the logged in user
+func (h *Handler) RevokeAPITokenHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("token_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid token ID")
+		return
+	}
+
+	err = h.db.WithContext(c).RevokeAPIToken(ID, tokenID)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "token not found")
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("token_id", tokenID).Msg("failed to revoke api token")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token is revoked successfully"})
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestAPIToken creates a personal access token of user with scopes
+func newTestAPIToken(t *testing.T, app *App, user models.User, expiresAt *time.Time, scopes ...string) (string, models.APIToken) {
+	t.Helper()
+
+	token, apiToken, err := app.handlers.apiTokens.Create(user.ID, "ci-bot", scopes, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token, apiToken
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin, _ := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	user, jwt := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	read, _ := newTestAPIToken(t, app, user, nil, models.ScopeRead)
+	write, _ := newTestAPIToken(t, app, user, nil, models.ScopeRead, models.ScopeWrite)
+	userAdmin, _ := newTestAPIToken(t, app, user, nil, models.ScopeRead, models.ScopeAdmin)
+	adminRead, _ := newTestAPIToken(t, app, admin, nil, models.ScopeRead)
+	adminAdmin, _ := newTestAPIToken(t, app, admin, nil, models.ScopeRead, models.ScopeAdmin)
+
+	cases := []struct {
+		name, method, path, token string
+		code                      int
+	}{
+		{"read scope reads", http.MethodGet, "/api/v1/user/notifications", read, http.StatusOK},
+		{"read scope can't change", http.MethodPost, "/api/v1/user/notifications/read", read, http.StatusForbidden},
+		{"write scope changes", http.MethodPost, "/api/v1/user/notifications/read", write, http.StatusOK},
+		{"tokens can't mint tokens", http.MethodPost, "/api/v1/user/tokens", write, http.StatusForbidden},
+		{"admin scope needs an admin", http.MethodGet, "/api/v1/user", userAdmin, http.StatusForbidden},
+		{"admins need the admin scope", http.MethodGet, "/api/v1/user", adminRead, http.StatusForbidden},
+		{"admin scope of an admin", http.MethodGet, "/api/v1/user", adminAdmin, http.StatusOK},
+		{"unknown token", http.MethodGet, "/api/v1/user/notifications", internal.APITokenPrefix + "unknown", http.StatusUnauthorized},
+		{"jwt is not scoped", http.MethodPost, "/api/v1/user/notifications/read", jwt, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		body := ""
+		if c.method == http.MethodPost {
+			body = `{"name":"nested","scopes":["read"]}`
+		}
+		if w := serve(app, c.method, c.path, c.token, body); w.Code != c.code {
+			t.Errorf("%s: expected %d, got %d %s", c.name, c.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRevokedAndExpiredAPITokens(t *testing.T) {
+	app := newTestApp(t, nil)
+	user, jwt := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	expiredAt := time.Now().Add(-time.Minute)
+	expired, _ := newTestAPIToken(t, app, user, &expiredAt, models.ScopeRead)
+	if w := serve(app, http.MethodGet, "/api/v1/user/notifications", expired, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an expired token to fail, got %d %s", w.Code, w.Body.String())
+	}
+
+	token, apiToken := newTestAPIToken(t, app, user, nil, models.ScopeRead)
+	if w := serve(app, http.MethodGet, "/api/v1/user/notifications", token, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the token to be valid, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(app, http.MethodDelete, "/api/v1/user/tokens/"+strconv.Itoa(apiToken.ID), jwt, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the token to be revoked, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodGet, "/api/v1/user/notifications", token, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked token to fail, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := serve(app, http.MethodGet, "/api/v1/user/tokens", jwt, "")
+	var tokens []models.APIToken
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range tokens {
+		if listed.ID == apiToken.ID && listed.RevokedAt == nil {
+			t.Fatal("expected the token to be listed as revoked")
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app := newTestApp(t, nil)
+	password, err := internal.HashAndSaltPassword([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, token := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io", Password: password})
+	other, _ := newTestUser(t, app, models.User{Username: "other", Email: "other@kubecloud.io", Password: password})
+
+	// an email in the body is ignored, the password of the logged in user is changed
+	body := `{"email":"other@kubecloud.io","password":"new password","confirm_password":"new password"}`
+	write, _ := newTestAPIToken(t, app, user, nil, models.ScopeRead, models.ScopeWrite)
+	if w := serve(app, http.MethodPost, "/api/v1/user/change_password", write, body); w.Code != http.StatusForbidden {
+		t.Fatalf("expected tokens not to change the password, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodPost, "/api/v1/user/change_password", token, body); w.Code != http.StatusOK {
+		t.Fatalf("expected the password to be changed, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, u := range []models.User{user, other} {
+		stored, err := app.handlers.db.GetUserByID(u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := internal.VerifyPassword(stored.Password, "new password")
+		if changed != (u.ID == user.ID) {
+			t.Fatalf("expected only the password of the logged in user to change, %s changed %v", u.Email, changed)
+		}
+	}
+}
@@ -5,25 +5,56 @@ import (
 	"fmt"
 	"kubecloud/internal"
 	"kubecloud/middlewares"
+	"kubecloud/models"
 	"kubecloud/models/sqlite"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
 // App holds all configurations for the app
 type App struct {
-	router     *gin.Engine
-	httpServer *http.Server
-	config     internal.Configuration
-	handlers   Handler
+	router      *gin.Engine
+	httpServer  *http.Server
+	config      internal.Configuration
+	handlers    Handler
+	auditor     *internal.Auditor
+	limiter     *internal.RateLimiter
+	openAPI     internal.OpenAPIDocument
+	db          *sqlite.Sqlite
+	watchConfig bool        // reload configuration every reload.interval_seconds
+	draining    atomic.Bool // set on shutdown so the readiness probe fails
+	jobsCtx     context.Context
+	stopJobs    context.CancelFunc
+	jobs        sync.WaitGroup
+	stopTracing func(context.Context) error
+
+	metrics       *prometheus.Registry
+	metricsServer *http.Server
+
+	tls            *internal.ServerTLS // nil if TLS is disabled
+	redirectServer *http.Server
 }
 
 // NewApp create new instance of the app with all configs
 func NewApp(config internal.Configuration) (*App, error) {
-	router := gin.Default()
+	router := gin.New()
+	// handlers pass the gin context down, it falls back to the request context carrying the logger and span
+	router.ContextWithFallback = true
+	if err := router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	stopTracing, err := internal.SetupTracing(context.Background(), config.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup tracing: %w", err)
+	}
 
 	tokenHandler := internal.NewTokenHandler(
 		config.JWT.Secret,
@@ -37,17 +68,113 @@ func NewApp(config internal.Configuration) (*App, error) {
 		return nil, fmt.Errorf("failed to create user storage: %w", err)
 	}
 
-	mailService := internal.NewMailService(config.MailSender.SendGridKey)
+	mailer, err := internal.NewMailer(config.MailSender)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create mailer")
+		return nil, fmt.Errorf("failed to create mailer: %w", err)
+	}
+
+	mailTemplates, err := internal.LoadMailTemplates(config.MailSender.TemplatesDir)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load mail templates")
+		return nil, fmt.Errorf("failed to load mail templates: %w", err)
+	}
+
+	mailService := internal.NewMailService(mailer, mailTemplates)
+
+	outbox := internal.NewOutboxSender(
+		db,
+		mailService,
+		config.MailSender.MaxAttempts,
+		time.Duration(config.MailSender.PollSeconds)*time.Second,
+	)
+
+	webhooks, err := internal.NewWebhookDispatcher(db, config.Webhooks)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create webhook dispatcher")
+		return nil, fmt.Errorf("failed to create webhook dispatcher: %w", err)
+	}
+
+	notifier := internal.NewNotifier(
+		db,
+		internal.NewInAppChannel(db),
+		internal.NewEmailChannel(db, mailService, outbox, config.MailSender.Email, config.Server.Host),
+		internal.NewWebhookChannel(webhooks),
+	)
+
+	apiTokens := internal.NewAPITokens(db)
+
+	var oidc *internal.OIDCProvider
+	if config.OIDC.Enabled {
+		oidc = internal.NewOIDCProvider(config.OIDC, config.JWT.Secret)
+	}
+
+	challenges, err := internal.NewChallengeVerifier(config.Challenge, config.JWT.Secret)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create challenge verifier")
+		return nil, fmt.Errorf("failed to create challenge verifier: %w", err)
+	}
 
-	handler := NewHandler(tokenHandler, db, config, mailService)
+	auditor := internal.NewAuditor(db, config.Audit.HashChain, config.JWT.Secret)
+
+	handler := NewHandler(tokenHandler, db, internal.NewConfigStore(config), mailService, outbox, notifier, webhooks, apiTokens, oidc, challenges, internal.NewSessions(config.Session, config.JWT), auditor)
+
+	limiter, err := internal.NewRateLimiter(config.RateLimit, db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create rate limiter")
+		return nil, fmt.Errorf("failed to create rate limiter: %w", err)
+	}
 
 	app := &App{
 		router:   router,
 		config:   config,
 		handlers: *handler,
+		auditor:  auditor,
+		limiter:  limiter,
+		db:       db,
+
+		stopTracing: stopTracing,
+		metrics:     newMetricsRegistry(db),
 	}
+	app.jobsCtx, app.stopJobs = context.WithCancel(context.Background())
 
 	app.registerHandlers()
+	app.openAPI = buildOpenAPI(app.router.Routes())
+
+	app.tls, err = internal.NewServerTLS(config.TLS)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to setup TLS")
+		return nil, fmt.Errorf("failed to setup tls: %w", err)
+	}
+
+	app.httpServer = &http.Server{
+		Addr:    net.JoinHostPort(config.Server.Host, config.Server.Port),
+		Handler: app.router,
+	}
+	if app.tls != nil {
+		app.httpServer.TLSConfig = app.tls.Config
+
+		if config.TLS.RedirectPort != "" {
+			app.redirectServer = &http.Server{
+				Addr:    net.JoinHostPort(config.Server.Host, config.TLS.RedirectPort),
+				Handler: app.tls.HTTPHandler(redirectToHTTPS(config.Server.Port)),
+			}
+		}
+	}
+	if config.Server.H2C {
+		app.httpServer.Protocols = new(http.Protocols)
+		app.httpServer.Protocols.SetHTTP1(true)
+		app.httpServer.Protocols.SetHTTP2(true)
+		app.httpServer.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if config.Metrics.Enabled && config.Metrics.ListenAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.metricsHandler())
+		app.metricsServer = &http.Server{
+			Addr:    config.Metrics.ListenAddress,
+			Handler: mux,
+		}
+	}
 
 	return app, nil
 
@@ -55,34 +182,110 @@ func NewApp(config internal.Configuration) (*App, error) {
 
 // registerHandlers registers all routes
 func (app *App) registerHandlers() {
+	audit := func(action string) gin.HandlerFunc {
+		return middlewares.AuditMiddleware(app.auditor, action)
+	}
+	limit := func(policy string) gin.HandlerFunc {
+		return middlewares.RateLimitMiddleware(app.limiter, policy)
+	}
+	limitTarget := middlewares.TargetEmailRateLimitMiddleware(app.limiter, internal.RateLimitPolicyMailTarget)
+	challenge := middlewares.ChallengeMiddleware(app.handlers.challenges)
+
+	app.router.Use(
+		middlewares.RequestIDMiddleware(),
+		middlewares.SecurityHeadersMiddleware(app.config.SecurityHeaders),
+		middlewares.TracingMiddleware(),
+		middlewares.AccessLogMiddleware("/healthz", "/readyz"),
+		middlewares.MetricsMiddleware(),
+		middlewares.ErrorMiddleware(),
+		middlewares.CORSMiddleware(app.config.CORS),
+		gin.CustomRecovery(func(c *gin.Context, err any) {
+			log.Ctx(c).Error().Interface("panic", err).Msg("Recovered from panic")
+			abortInternal(c)
+		}),
+	)
+	app.router.NoRoute(func(c *gin.Context) {
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "route not found")
+	})
+
+	app.router.GET("/healthz", app.HealthzHandler)
+	app.router.GET("/readyz", app.ReadyzHandler)
+	app.router.GET("/version", app.VersionHandler)
+
+	// metrics on the API port are for admins only, scrapers use a personal access token with the admin scope
+	if app.config.Metrics.Enabled && app.config.Metrics.ListenAddress == "" {
+		app.router.GET("/metrics",
+			middlewares.AdminMiddleware(app.handlers.db, app.handlers.tokenManager, app.handlers.apiTokens, app.handlers.sessions),
+			limit(internal.RateLimitPolicyUser),
+			gin.WrapH(app.metricsHandler()),
+		)
+	}
+
 	v1 := app.router.Group("/api/v1")
 	{
+		v1.GET("/openapi.json", app.OpenAPIHandler)
+		if app.config.Server.Dev {
+			v1.GET("/docs", app.SwaggerUIHandler)
+		}
+
 		usersGroup := v1.Group("/user")
 		{
-			usersGroup.POST("/register", app.handlers.RegisterHandler)
-			usersGroup.POST("/register/verify", app.handlers.VerifyRegisterCode)
-			usersGroup.POST("/login", app.handlers.LoginUserHandler)
-			usersGroup.POST("/refresh", app.handlers.RefreshTokenHandler)
-			usersGroup.POST("/forgot_password", app.handlers.ForgotPasswordHandler)
-			usersGroup.POST("/forgot_password/verify", app.handlers.VerifyForgetPasswordCodeHandler)
+			usersGroup.GET("/challenge", limit(internal.RateLimitPolicyAuth), app.handlers.GetChallengeHandler)
+			usersGroup.POST("/register", limit(internal.RateLimitPolicyMail), challenge, limitTarget, app.handlers.RegisterHandler)
+			usersGroup.POST("/register/verify", limit(internal.RateLimitPolicyAuth), audit("user.register.verify"), app.handlers.VerifyRegisterCode)
+			usersGroup.POST("/login", limit(internal.RateLimitPolicyAuth), audit("user.login"), app.handlers.LoginUserHandler)
+			usersGroup.POST("/refresh", limit(internal.RateLimitPolicyAuth), app.handlers.RefreshTokenHandler)
+			usersGroup.POST("/logout", app.handlers.LogoutHandler)
+			usersGroup.POST("/forgot_password", limit(internal.RateLimitPolicyMail), challenge, limitTarget, audit("user.forgot_password"), app.handlers.ForgotPasswordHandler)
+			usersGroup.POST("/forgot_password/verify", limit(internal.RateLimitPolicyAuth), audit("user.forgot_password.verify"), app.handlers.VerifyForgetPasswordCodeHandler)
+
+			if app.handlers.oidc != nil {
+				usersGroup.GET("/oidc/login", limit(internal.RateLimitPolicyAuth), app.handlers.OIDCLoginHandler)
+				usersGroup.GET("/oidc/callback", limit(internal.RateLimitPolicyAuth), audit("user.login.oidc"), app.handlers.OIDCCallbackHandler)
+			}
 
 			authGroup := usersGroup.Group("")
-			authGroup.Use(middlewares.UserMiddleware(app.handlers.tokenManager))
+			authGroup.Use(
+				middlewares.UserMiddleware(app.handlers.db, app.handlers.tokenManager, app.handlers.apiTokens, app.handlers.sessions),
+				limit(internal.RateLimitPolicyUser),
+				middlewares.OrganizationMiddleware(app.handlers.db),
+			)
+			// transactions and webhooks act on the active organization if any,
+			// quota, notifications and API tokens always belong to the logged in user
 			{
-				authGroup.POST("/change_password", app.handlers.ChangePasswordHandler)
+				authGroup.POST("/change_password", audit("user.change_password"), app.handlers.ChangePasswordHandler)
+				authGroup.PUT("/language", app.handlers.ChangeLanguageHandler)
+				authGroup.GET("/quota", app.handlers.GetQuotaHandler)
+				authGroup.GET("/transactions", app.handlers.ListTransactionsHandler)
+				authGroup.GET("/notifications", app.handlers.ListNotificationsHandler)
+				authGroup.POST("/notifications/read", app.handlers.MarkNotificationsReadHandler)
+				authGroup.GET("/notifications/preferences", app.handlers.GetNotificationPreferencesHandler)
+				authGroup.PUT("/notifications/preferences", app.handlers.SetNotificationPreferencesHandler)
+				authGroup.GET("/tokens", app.handlers.ListAPITokensHandler)
+				authGroup.POST("/tokens", audit("user.token.create"), app.handlers.CreateAPITokenHandler)
+				authGroup.DELETE("/tokens/:token_id", audit("user.token.revoke"), app.handlers.RevokeAPITokenHandler)
+				authGroup.GET("/webhooks", app.handlers.ListWebhooksHandler)
+				authGroup.POST("/webhooks", audit("user.webhook.create"), app.handlers.CreateWebhookHandler)
+				authGroup.DELETE("/webhooks/:webhook_id", audit("user.webhook.delete"), app.handlers.DeleteWebhookHandler)
+				authGroup.GET("/webhooks/:webhook_id/deliveries", app.handlers.ListWebhookDeliveriesHandler)
+				authGroup.POST("/webhooks/:webhook_id/ping", app.handlers.PingWebhookHandler)
+				authGroup.GET("/me/export", audit("user.export"), app.handlers.ExportUserDataHandler)
 			}
 
 			adminGroup := usersGroup.Group("")
-			adminGroup.Use(middlewares.AdminMiddleware(app.handlers.tokenManager))
+			adminGroup.Use(middlewares.AdminMiddleware(app.handlers.db, app.handlers.tokenManager, app.handlers.apiTokens, app.handlers.sessions), limit(internal.RateLimitPolicyUser))
 			{
 
 				adminGroup.GET("", app.handlers.ListUsersHandler)
-				adminGroup.DELETE("/:user_id", app.handlers.DeleteUsersHandler)
-				adminGroup.POST("/:user_id/credit", app.handlers.CreditUserHandler)
+				adminGroup.DELETE("/:user_id", audit("admin.user.delete"), app.handlers.DeleteUsersHandler)
+				adminGroup.POST("/:user_id/credit", audit("admin.user.credit"), app.handlers.CreditUserHandler)
+				adminGroup.GET("/:user_id/quota", app.handlers.GetUserQuotaHandler)
+				adminGroup.PUT("/:user_id/quota", audit("admin.quota.set"), app.handlers.SetUserQuotaHandler)
+				adminGroup.DELETE("/:user_id/quota", audit("admin.quota.delete"), app.handlers.DeleteUserQuotaHandler)
 
 				vouchersGroup := adminGroup.Group("/vouchers")
 				{
-					vouchersGroup.POST("/generate", app.handlers.GenerateVouchersHandler)
+					vouchersGroup.POST("/generate", audit("admin.vouchers.generate"), app.handlers.GenerateVouchersHandler)
 					vouchersGroup.GET("", app.handlers.ListVouchersHandler)
 
 				}
@@ -91,31 +294,193 @@ func (app *App) registerHandlers() {
 
 		}
 
+		organizationsGroup := v1.Group("/organizations")
+		organizationsGroup.Use(
+			middlewares.UserMiddleware(app.handlers.db, app.handlers.tokenManager, app.handlers.apiTokens, app.handlers.sessions),
+			limit(internal.RateLimitPolicyUser),
+			middlewares.OrganizationMiddleware(app.handlers.db),
+		)
+		{
+			organizationsGroup.GET("", app.handlers.ListOrganizationsHandler)
+			organizationsGroup.POST("", audit("organization.create"), app.handlers.CreateOrganizationHandler)
+			organizationsGroup.POST("/switch", app.handlers.SwitchOrganizationHandler)
+			organizationsGroup.POST("/invitations/accept", audit("organization.invitation.accept"), app.handlers.AcceptOrganizationInvitationHandler)
+
+			// routes acting on the active organization
+			anyRole := middlewares.OrganizationRoleMiddleware(models.OrganizationRoles...)
+			managers := middlewares.OrganizationRoleMiddleware(models.OrganizationManagerRoles...)
+			billing := middlewares.OrganizationRoleMiddleware(models.OrganizationBillingRoles...)
+			owner := middlewares.OrganizationRoleMiddleware(models.OrganizationRoleOwner)
+
+			currentGroup := organizationsGroup.Group("/current")
+			{
+				currentGroup.GET("", anyRole, app.handlers.GetOrganizationHandler)
+				currentGroup.PUT("", managers, audit("organization.update"), app.handlers.UpdateOrganizationHandler)
+				currentGroup.DELETE("", owner, audit("organization.delete"), app.handlers.DeleteOrganizationHandler)
+				currentGroup.GET("/members", anyRole, app.handlers.ListOrganizationMembersHandler)
+				currentGroup.PUT("/members/:user_id", managers, audit("organization.member.update"), app.handlers.UpdateOrganizationMemberHandler)
+				currentGroup.DELETE("/members/:user_id", anyRole, audit("organization.member.remove"), app.handlers.RemoveOrganizationMemberHandler)
+				currentGroup.GET("/invitations", managers, app.handlers.ListOrganizationInvitationsHandler)
+				currentGroup.POST("/invitations", managers, audit("organization.invitation.create"), app.handlers.CreateOrganizationInvitationHandler)
+				currentGroup.DELETE("/invitations/:invitation_id", managers, audit("organization.invitation.delete"), app.handlers.DeleteOrganizationInvitationHandler)
+				currentGroup.GET("/transactions", billing, app.handlers.ListOrganizationTransactionsHandler)
+			}
+		}
+
+		adminGroup := v1.Group("/admin")
+		adminGroup.Use(middlewares.AdminMiddleware(app.handlers.db, app.handlers.tokenManager, app.handlers.apiTokens, app.handlers.sessions), limit(internal.RateLimitPolicyUser))
+		{
+			adminGroup.GET("/audit", app.handlers.ListAuditLogsHandler)
+			adminGroup.GET("/config", app.handlers.GetConfigVersionHandler)
+			adminGroup.POST("/config/reload", audit("admin.config.reload"), app.handlers.ReloadConfigHandler)
+			adminGroup.GET("/audit/verify", app.handlers.VerifyAuditLogsHandler)
+			adminGroup.GET("/mails", app.handlers.ListOutboxMailsHandler)
+			adminGroup.POST("/mails/:mail_id/retry", audit("admin.mail.retry"), app.handlers.RetryOutboxMailHandler)
+			adminGroup.GET("/organizations", app.handlers.ListAllOrganizationsHandler)
+			adminGroup.POST("/organizations/:organization_id/credit", audit("admin.organization.credit"), app.handlers.CreditOrganizationHandler)
+		}
+
 	}
 
 }
 
-// Run starts the server
+// Handler returns the handler serving the API, to serve it without Run like in tests
+func (app *App) Handler() http.Handler {
+	return app.router
+}
+
+// Run starts background jobs and serves requests until the server is shut down
 func (app *App) Run() error {
-	addr := fmt.Sprintf("%s:%s", app.config.Server.Host, app.config.Server.Port)
-	app.httpServer = &http.Server{
-		Addr:    addr,
-		Handler: app.router,
+	// listen first so a busy port fails startup before jobs are started
+	listener, err := net.Listen("tcp", app.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", app.httpServer.Addr, err)
 	}
 
-	log.Info().Msgf("Starting server at http://%s", addr)
+	app.startJob(app.runPurgeJob)
+	if interval := time.Duration(app.config.Reload.IntervalSeconds) * time.Second; app.watchConfig && interval > 0 {
+		app.startJob(func(ctx context.Context) {
+			app.handlers.config.Watch(ctx, interval)
+		})
+	}
+	app.startJob(app.handlers.outbox.Run)
+	app.startJob(app.handlers.webhooks.Run)
 
-	if err := app.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Error().Err(err).Msg("Failed to start server")
+	if app.metricsServer != nil {
+		go func() {
+			log.Info().Msgf("Serving metrics at http://%s/metrics", app.metricsServer.Addr)
+			if err := app.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Failed to start metrics server")
+			}
+		}()
+	}
+
+	if app.redirectServer != nil {
+		go func() {
+			log.Info().Msgf("Redirecting http://%s to HTTPS", app.redirectServer.Addr)
+			if err := app.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Failed to start redirect server")
+			}
+		}()
 	}
 
-	return app.httpServer.ListenAndServe()
+	if app.tls != nil {
+		log.Info().Msgf("Starting server at https://%s", app.httpServer.Addr)
+		err = app.httpServer.ServeTLS(listener, "", "")
+	} else {
+		log.Info().Msgf("Starting server at http://%s", app.httpServer.Addr)
+		err = app.httpServer.Serve(listener)
+	}
+
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// WatchConfig reloads reloadable configuration keys from the file at path, or only from the environment if path
+// is empty, every reload.interval_seconds once running and on ReloadConfig
+func (app *App) WatchConfig(path string) {
+	app.handlers.config.SetSource(path)
+	app.watchConfig = true
 }
 
-// Shutdown gracefully shuts down the server
+// ReloadConfig reloads configuration if it is watched, an invalid configuration is rejected
+func (app *App) ReloadConfig() error {
+	_, err := app.handlers.config.Reload()
+	return err
+}
+
+// startJob runs a background job until jobs are stopped
+func (app *App) startJob(job func(ctx context.Context)) {
+	app.jobs.Add(1)
+	go func() {
+		defer app.jobs.Done()
+		job(app.jobsCtx)
+	}()
+}
+
+// Shutdown gracefully shuts down the server. The readiness probe fails for server.shutdown_delay_seconds first,
+// then in-flight requests are drained and background jobs are stopped and waited for before the database is
+// closed. The database is left open if jobs did not stop in time, they may still be using it.
 func (app *App) Shutdown(ctx context.Context) error {
-	if app.httpServer != nil {
-		return app.httpServer.Shutdown(ctx)
+	app.draining.Store(true)
+
+	select {
+	case <-time.After(time.Duration(app.config.Server.ShutdownDelaySeconds) * time.Second):
+	case <-ctx.Done():
 	}
-	return nil
+
+	err := app.httpServer.Shutdown(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to drain in-flight requests")
+	}
+
+	if app.metricsServer != nil {
+		if metricsErr := app.metricsServer.Shutdown(ctx); metricsErr != nil {
+			log.Error().Err(metricsErr).Msg("Failed to shutdown metrics server")
+		}
+	}
+
+	if app.redirectServer != nil {
+		if redirectErr := app.redirectServer.Shutdown(ctx); redirectErr != nil {
+			log.Error().Err(redirectErr).Msg("Failed to shutdown redirect server")
+		}
+	}
+
+	app.stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		app.jobs.Wait()
+		close(jobsDone)
+	}()
+	jobsStopped := true
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		log.Error().Err(ctx.Err()).Msg("Background jobs did not stop in time")
+		jobsStopped = false
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	if app.stopTracing != nil {
+		if tracingErr := app.stopTracing(ctx); tracingErr != nil {
+			log.Error().Err(tracingErr).Msg("Failed to flush traces")
+		}
+	}
+
+	if !jobsStopped {
+		return err
+	}
+
+	if closeErr := app.db.Close(); closeErr != nil {
+		log.Error().Err(closeErr).Msg("Failed to close database")
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
 }
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestApp creates an app with a temporary database and file mailer, configure may change the config first
+func newTestApp(t *testing.T, configure func(config *internal.Configuration)) *App {
+	config := internal.Configuration{}
+	config.Server.Host = "localhost"
+	config.Database.File = filepath.Join(t.TempDir(), "db.sqlite")
+	config.JWT.Secret = "secret"
+	config.JWT.AccessTokenExpiryMinutes = 5
+	config.JWT.RefreshTokenExpiryHours = 1
+	config.MailSender.Driver = "file"
+	config.MailSender.Directory = t.TempDir()
+	config.MailSender.Email = "noreply@kubecloud.io"
+	config.MailSender.Timeout = 60
+
+	if configure != nil {
+		configure(&config)
+	}
+
+	app, err := NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// newTestUser registers a verified user and returns it with an access token
+func newTestUser(t *testing.T, app *App, user models.User) (models.User, string) {
+	t.Helper()
+
+	user.Verified = true
+	if err := app.handlers.db.RegisterUser(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := app.handlers.tokenManager.CreateTokenPair(user.ID, user.Username, user.Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, tokens.AccessToken
+}
+
+// serve sends a request with a JSON body to the app, token is sent as a bearer token unless empty
+func serve(app *App, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	return w
+}
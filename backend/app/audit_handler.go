@@ -0,0 +1,83 @@
+package app
+
+import (
+	"fmt"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// auditVerifyBatchSize is how many audit log entries are read at a time when verifying the chain
+const auditVerifyBatchSize = 500
+
+// AuditFilterInput holds query filters when listing audit logs
+type AuditFilterInput struct {
+	ActorID int       `form:"actor_id" binding:"omitempty,gt=0"`
+	Action  string    `form:"action"`
+	Target  string    `form:"target"`
+	Outcome string    `form:"outcome" binding:"omitempty,oneof=success failure"`
+	From    time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To      time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit   int       `form:"limit" binding:"omitempty,gt=0,max=1000"`
+	Offset  int       `form:"offset" binding:"omitempty,gte=0"`
+}
+
+// ListAuditLogsHandler lists audit log entries matching query filters
+func (h *Handler) ListAuditLogsHandler(c *gin.Context) {
+	var request AuditFilterInput
+	if err := c.ShouldBindQuery(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	if request.Limit == 0 {
+		request.Limit = 100
+	}
+
+	entries, err := h.db.WithContext(c).ListAuditLogs(models.AuditFilter{
+		ActorID: request.ActorID,
+		Action:  request.Action,
+		Target:  request.Target,
+		Outcome: request.Outcome,
+		From:    request.From.UTC(),
+		To:      request.To.UTC(),
+		Limit:   request.Limit,
+		Offset:  request.Offset,
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list audit logs")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// VerifyAuditLogsHandler checks the hash chain of the audit log
+func (h *Handler) VerifyAuditLogsHandler(c *gin.Context) {
+	verification, err := h.auditor.VerifyChain(c, auditVerifyBatchSize)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to verify audit logs")
+		abortInternal(c)
+		return
+	}
+
+	if verification.BrokenID != 0 {
+		log.Ctx(c).Warn().Int("entry_id", verification.BrokenID).Msg("audit log hash chain is broken")
+		abort(c, http.StatusConflict, internal.ErrCodeConflict, fmt.Sprintf("audit log hash chain is broken at entry %d", verification.BrokenID))
+		return
+	}
+
+	if verification.Truncated {
+		log.Ctx(c).Warn().Msg("audit log hash chain does not end at its head")
+		abort(c, http.StatusConflict, internal.ErrCodeConflict, "audit log hash chain does not end at its head, entries were removed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"valid": true, "entries": verification.Entries})
+}
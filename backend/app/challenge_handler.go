@@ -0,0 +1,29 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetChallengeHandler returns what the frontend needs to solve the challenge of register and forgot password
+func (h *Handler) GetChallengeHandler(c *gin.Context) {
+	response := gin.H{
+		"provider": h.challenges.Provider(),
+		"site_key": h.config.Current().Challenge.SiteKey,
+	}
+
+	if issuer, ok := h.challenges.(internal.ChallengeIssuer); ok {
+		challenge, err := issuer.Issue()
+		if err != nil {
+			log.Ctx(c).Error().Err(err).Msg("failed to issue challenge")
+			abortInternal(c)
+			return
+		}
+		response["challenge"] = challenge
+	}
+
+	c.JSON(http.StatusOK, response)
+}
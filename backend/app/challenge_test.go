@@ -0,0 +1,77 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/middlewares"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveChallenged sends a request like serve with a solved challenge
+func serveChallenged(app *App, path, challenge, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(middlewares.ChallengeHeader, challenge)
+
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	return w
+}
+
+// solveProofOfWork finds a nonce giving the challenge enough leading zero bits
+func solveProofOfWork(challenge internal.IssuedChallenge) string {
+	for nonce := 0; ; nonce++ {
+		response := challenge.Challenge + ":" + strconv.Itoa(nonce)
+		sum := sha256.Sum256([]byte(response))
+
+		zeros := 0
+		for _, b := range sum {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= challenge.Difficulty {
+			return response
+		}
+	}
+}
+
+func TestProofOfWorkChallenge(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Challenge = internal.Challenge{Provider: internal.ChallengeProviderPoW, SecretKey: "secret", Difficulty: 8}
+		config.RateLimit.Disabled = true
+	})
+	register := `{"name":"user","email":"user@kubecloud.io","password":"password","confirm_password":"password"}`
+
+	w := serveChallenged(app, "/api/v1/user/register", "", register)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), string(internal.ErrCodeChallengeFailed)) {
+		t.Fatalf("expected a request without a challenge to be refused, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(app, http.MethodGet, "/api/v1/user/challenge", "", "")
+	var issued struct {
+		Provider  string                   `json:"provider"`
+		Challenge internal.IssuedChallenge `json:"challenge"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil || issued.Provider != internal.ChallengeProviderPoW || issued.Challenge.Difficulty != 8 {
+		t.Fatalf("expected a proof of work challenge, got %d %s", w.Code, w.Body.String())
+	}
+
+	solved := solveProofOfWork(issued.Challenge)
+	if w := serveChallenged(app, "/api/v1/user/register", solved, `{"name":"user"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid body to be refused, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveChallenged(app, "/api/v1/user/register", solved, register); w.Code != http.StatusOK {
+		t.Fatalf("expected the challenge of the refused request to let the request through, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveChallenged(app, "/api/v1/user/forgot_password", solved, `{"email":"user@kubecloud.io"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a used challenge to be refused, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"errors"
+	"kubecloud/internal"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetConfigVersionHandler returns the version of the active configuration
+func (h *Handler) GetConfigVersionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, h.config.Version())
+}
+
+// ReloadConfigHandler reloads the configuration now instead of waiting for the next check
+func (h *Handler) ReloadConfigHandler(c *gin.Context) {
+	_, err := h.config.Reload()
+	if errors.Is(err, internal.ErrConfigNotWatched) {
+		abort(c, http.StatusConflict, internal.ErrCodeConflict, "configuration is not reloadable, it is not read from a watched source")
+		return
+	}
+	if err != nil {
+		log.Ctx(c).Warn().Err(err).Msg("rejected configuration reload")
+		abort(c, http.StatusConflict, internal.ErrCodeConflict, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, h.config.Version())
+}
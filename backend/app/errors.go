@@ -0,0 +1,28 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abort stops the request with an error rendered by middlewares.ErrorMiddleware
+func abort(c *gin.Context, status int, code internal.ErrorCode, message string) {
+	middlewares.AbortWithError(c, status, code, message)
+}
+
+// abortAPIError stops the request with err
+func abortAPIError(c *gin.Context, err *internal.APIError) {
+	middlewares.AbortWithAPIError(c, err)
+}
+
+// abortInternal stops the request with an internal error, the cause is logged by the caller
+func abortInternal(c *gin.Context) {
+	middlewares.AbortWithAPIError(c, internal.ErrInternal)
+}
+
+// abortBinding stops the request with the error of binding its body or query
+func abortBinding(c *gin.Context, err error) {
+	middlewares.AbortWithBindingError(c, err)
+}
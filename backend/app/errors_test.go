@@ -0,0 +1,64 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		status  int
+		code    internal.ErrorCode
+		details []string
+	}{
+		{"validation", http.MethodPost, "/api/v1/user/login", `{"email":"not an email"}`, http.StatusBadRequest, internal.ErrCodeValidationFailed, []string{"email", "password"}},
+		{"malformed body", http.MethodPost, "/api/v1/user/login", `{`, http.StatusBadRequest, internal.ErrCodeInvalidRequest, nil},
+		{"oversized body", http.MethodPost, "/api/v1/user/login", `{"email":"` + strings.Repeat("a", 1<<20) + `"}`, http.StatusRequestEntityTooLarge, internal.ErrCodeInvalidRequest, nil},
+		{"unknown email on login", http.MethodPost, "/api/v1/user/login", `{"email":"nobody@kubecloud.io","password":"password"}`, http.StatusUnauthorized, internal.ErrCodeInvalidCredentials, nil},
+		{"unknown email on verify", http.MethodPost, "/api/v1/user/register/verify", `{"email":"nobody@kubecloud.io","code":1234}`, http.StatusNotFound, internal.ErrCodeUserNotFound, nil},
+		{"unknown email on forgot password", http.MethodPost, "/api/v1/user/forgot_password", `{"email":"nobody@kubecloud.io"}`, http.StatusNotFound, internal.ErrCodeUserNotFound, nil},
+		{"missing token", http.MethodGet, "/api/v1/user/quota", "", http.StatusUnauthorized, internal.ErrCodeUnauthorized, nil},
+		{"unknown route", http.MethodGet, "/api/v1/nothing", "", http.StatusNotFound, internal.ErrCodeNotFound, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(middlewares.RequestIDHeader, "test-"+strings.ReplaceAll(test.name, " ", "-"))
+			w := httptest.NewRecorder()
+			app.router.ServeHTTP(w, req)
+
+			var body ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != test.status || body.Error.Code != test.code {
+				t.Fatalf("expected %d %s, got %d %s", test.status, test.code, w.Code, w.Body.String())
+			}
+			if body.Error.RequestID != req.Header.Get(middlewares.RequestIDHeader) {
+				t.Fatalf("expected request ID %s, got %s", req.Header.Get(middlewares.RequestIDHeader), body.Error.RequestID)
+			}
+
+			var fields []string
+			for _, detail := range body.Error.Details {
+				fields = append(fields, detail.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.details, ",") {
+				t.Fatalf("expected details of %v, got %v", test.details, fields)
+			}
+		})
+	}
+}
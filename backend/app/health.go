@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"kubecloud/internal"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	healthOK     = "ok"
+	healthFailed = "failed"
+
+	readinessTimeout = 3 * time.Second
+)
+
+// HealthResponse is returned by liveness and readiness probes, checks maps each readiness check to its status
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HealthzHandler is the liveness probe, the process is alive as long as it serves requests
+func (app *App) HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: healthOK})
+}
+
+// ReadyzHandler is the readiness probe, it fails while shutting down or if the database or
+// mail provider, if checked, are unavailable. Failure causes are logged, not returned.
+func (app *App) ReadyzHandler(c *gin.Context) {
+	if app.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, HealthResponse{Status: "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"database": func(ctx context.Context) error {
+			return app.handlers.db.WithContext(ctx).Ping()
+		},
+		"migrations": func(ctx context.Context) error {
+			return app.handlers.db.WithContext(ctx).CheckMigrations()
+		},
+	}
+	if app.config.Health.CheckMailer {
+		checks["mailer"] = app.handlers.mailService.Ping
+	}
+
+	response := HealthResponse{Status: healthOK, Checks: map[string]string{}}
+	status := http.StatusOK
+	for name, check := range checks {
+		if err := check(ctx); err != nil {
+			log.Ctx(c).Error().Err(err).Str("check", name).Msg("readiness check failed")
+			response.Checks[name] = healthFailed
+			response.Status = healthFailed
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = healthOK
+	}
+
+	c.JSON(status, response)
+}
+
+// VersionHandler returns the version of the running binary
+func (app *App) VersionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, internal.GetBuildInfo())
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"kubecloud/internal"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	probe := func(path string, status int) HealthResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Fatalf("expected %s to respond %d, got %d %s", path, status, w.Code, w.Body.String())
+		}
+
+		var response HealthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	probe("/healthz", http.StatusOK)
+
+	ready := probe("/readyz", http.StatusOK)
+	if ready.Checks["database"] != healthOK || ready.Checks["migrations"] != healthOK {
+		t.Fatalf("expected database and migrations checks to pass, got %+v", ready)
+	}
+
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if draining := probe("/readyz", http.StatusServiceUnavailable); draining.Status != "draining" {
+		t.Fatalf("expected readiness to fail while draining, got %+v", draining)
+	}
+	probe("/healthz", http.StatusOK)
+}
+
+func TestShutdownDelay(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Server.ShutdownDelaySeconds = 1
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- app.Shutdown(context.Background()) }()
+
+	// load balancers see the failed readiness probe while requests are still served
+	time.Sleep(100 * time.Millisecond)
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readiness to fail during the shutdown delay, got %d", w.Code)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expected shutdown to wait for the delay, got %v", err)
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownStuckJobs(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	release := make(chan struct{})
+	defer close(release)
+	app.startJob(func(ctx context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := app.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected shutdown to time out, got %v", err)
+	}
+
+	// a job that did not stop may still use the database
+	if _, err := app.db.ListAllUsers(); err != nil {
+		t.Fatalf("expected the database to be left open, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"kubecloud/models/sqlite"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newMetricsRegistry registers runtime, request, database and business metrics
+func newMetricsRegistry(db models.DB) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		internal.HTTPRequests,
+		internal.HTTPRequestDuration,
+		internal.AuthFailures,
+		internal.MailsSent,
+		internal.Signups,
+		internal.RateLimitStoreErrors,
+		sqlite.QueryDuration,
+		internal.NewBusinessCollector(db),
+	)
+	return registry
+}
+
+// metricsHandler serves metrics in the Prometheus exposition format
+func (app *App) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(app.metrics, promhttp.HandlerOpts{})
+}
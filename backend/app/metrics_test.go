@@ -0,0 +1,63 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Metrics.Enabled = true
+	})
+
+	_, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	db := app.handlers.db
+	if err := db.RegisterUser(&models.User{Username: "user", Email: "user@kubecloud.io", Verified: true, CreditedBalance: 12.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateVoucher(&models.Voucher{Voucher: "outstanding", Value: 30, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateVoucher(&models.Voucher{Voucher: "expired", Value: 40, ExpiresAt: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", strings.NewReader(`{"email":"nobody@kubecloud.io","password":"password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	app.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if w := serve(app, http.MethodGet, "/metrics", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected metrics on the API port to need admin access, got %d", w.Code)
+	}
+
+	w := serve(app, http.MethodGet, "/metrics", adminToken, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected metrics, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, line := range []string{
+		`kubecloud_http_requests_total{method="POST",route="/api/v1/user/login",status="401"}`,
+		`kubecloud_auth_failures_total{code="invalid_credentials",route="/api/v1/user/login"}`,
+		`kubecloud_db_query_duration_seconds_count{operation="query",table="users"}`,
+		`kubecloud_verified_users 2`,
+		`kubecloud_outstanding_voucher_value 30`,
+		`kubecloud_credited_balance 12.5`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("expected metrics to contain %s", line)
+		}
+	}
+
+	// business gauges are reused between scrapes instead of querying the database every time
+	if err := db.RegisterUser(&models.User{Username: "other", Email: "other@kubecloud.io", Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(app, http.MethodGet, "/metrics", adminToken, ""); !strings.Contains(w.Body.String(), `kubecloud_verified_users 2`) {
+		t.Fatalf("expected cached business gauges, got %d %s", w.Code, w.Body.String())
+	}
+}
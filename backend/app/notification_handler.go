@@ -0,0 +1,118 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// MarkReadInput holds notifications to mark as read, all unread ones if empty
+type MarkReadInput struct {
+	IDs []int `json:"ids" binding:"omitempty,dive,gt=0"`
+}
+
+// ListNotificationsHandler lists notifications in the inbox of user
+func (h *Handler) ListNotificationsHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	unreadOnly := c.Query("unread") == "true"
+	notifications, err := h.db.WithContext(c).ListUserNotifications(ID, unreadOnly)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list notifications")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// MarkNotificationsReadHandler marks notifications of user as read
+func (h *Handler) MarkNotificationsReadHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request MarkReadInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	marked, err := h.db.WithContext(c).MarkNotificationsRead(ID, request.IDs)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to mark notifications as read")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications are marked as read", "count": marked})
+}
+
+// GetNotificationPreferencesHandler returns channels user is notified through per event
+func (h *Handler) GetNotificationPreferencesHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	preferences, err := h.notifier.Preferences(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// SetNotificationPreferencesHandler sets channels user is notified through per event
+func (h *Handler) SetNotificationPreferencesHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request []models.NotificationPreference
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	for _, preference := range request {
+		if !internal.Contains(models.NotificationEvents, preference.Event) {
+			abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "unknown notification event "+preference.Event)
+			return
+		}
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		for _, preference := range request {
+			preference.UserID = ID
+			if err := tx.UpsertNotificationPreference(&preference); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to set notification preferences")
+		abortInternal(c)
+		return
+	}
+
+	h.GetNotificationPreferencesHandler(c)
+}
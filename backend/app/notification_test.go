@@ -0,0 +1,56 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCreditNotifiesUser(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	user, token := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	credit := `{"amount":10,"memo":"welcome credit"}`
+	if w := serve(app, http.MethodPost, "/api/v1/user/"+strconv.Itoa(user.ID)+"/credit", adminToken, credit); w.Code != http.StatusOK {
+		t.Fatalf("expected the user to be credited, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := serve(app, http.MethodGet, "/api/v1/user/notifications?unread=true", token, "")
+	var notifications []models.Notification
+	if err := json.Unmarshal(w.Body.Bytes(), &notifications); err != nil || len(notifications) != 1 || notifications[0].Event != models.EventBalanceCredited {
+		t.Fatalf("expected a credited notification in the inbox, got %d %s", w.Code, w.Body.String())
+	}
+
+	mails, err := app.handlers.db.ListOutboxMails(models.OutboxPending, 0, 0)
+	if err != nil || len(mails) != 1 || mails[0].Receiver != user.Email {
+		t.Fatalf("expected a notification mail to be queued, got %+v %v", mails, err)
+	}
+
+	if w := serve(app, http.MethodPost, "/api/v1/user/notifications/read", token, `{}`); w.Code != http.StatusOK {
+		t.Fatalf("expected notifications to be marked read, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodGet, "/api/v1/user/notifications?unread=true", token, ""); w.Body.String() != "[]" {
+		t.Fatalf("expected no unread notifications, got %s", w.Body.String())
+	}
+}
+
+func TestSetNotificationPreferences(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, token := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	if w := serve(app, http.MethodPut, "/api/v1/user/notifications/preferences", token, `[{"event":"cluster.ready","email":false}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an event nothing raises to be refused, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := serve(app, http.MethodPut, "/api/v1/user/notifications/preferences", token, `[{"event":"balance.credited","in_app":true}]`)
+	var preferences []models.NotificationPreference
+	if err := json.Unmarshal(w.Body.Bytes(), &preferences); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected preferences to be set, got %d %s", w.Code, w.Body.String())
+	}
+	if len(preferences) != 1 || preferences[0].Email || !preferences[0].InApp || preferences[0].Webhook {
+		t.Fatalf("expected only the in-app channel to be enabled, got %+v", preferences)
+	}
+}
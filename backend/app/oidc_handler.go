@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// OIDCLoginHandler redirects user to the single sign-on provider
+func (h *Handler) OIDCLoginHandler(c *gin.Context) {
+	authURL, stateCookie, err := h.oidc.AuthURL(c.Request.Context())
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to start oidc login")
+		abort(c, http.StatusBadGateway, internal.ErrCodeUpstream, "single sign-on provider is unavailable")
+		return
+	}
+
+	h.oidc.SetStateCookie(c.Writer, stateCookie)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallbackHandler logs user in after the single sign-on provider redirects back
+func (h *Handler) OIDCCallbackHandler(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		log.Ctx(c).Error().Str("error", providerErr).Str("description", c.Query("error_description")).Msg("oidc login was rejected")
+		abort(c, http.StatusUnauthorized, internal.ErrCodeUnauthorized, "single sign-on login was rejected")
+		return
+	}
+
+	code := c.Query("code")
+	state := c.Query("state")
+	if code == "" || state == "" {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "code and state are required")
+		return
+	}
+
+	// a callback of a login started by another browser could log this one in to an account of an attacker
+	if !h.oidc.VerifyStateCookie(c.Request, state) {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "login was started in another browser, please try again")
+		return
+	}
+	h.oidc.ClearStateCookie(c.Writer)
+
+	identity, err := h.oidc.Exchange(c.Request.Context(), c.Request, state, code)
+	if errors.Is(err, internal.ErrOIDCState) {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "login has expired, please try again")
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to complete oidc login")
+		abort(c, http.StatusUnauthorized, internal.ErrCodeUnauthorized, "single sign-on login failed")
+		return
+	}
+
+	user, err := h.oidcUser(c, identity)
+	var apiErr *internal.APIError
+	if errors.As(err, &apiErr) {
+		abortAPIError(c, apiErr)
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Str("subject", identity.Subject).Msg("failed to get oidc user")
+		abortInternal(c)
+		return
+	}
+
+	// create token pairs
+	tokenPair, err := h.tokenManager.CreateTokenPair(user.ID, user.Username, user.Admin)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("Failed to generate token pair")
+		abortInternal(c)
+		return
+	}
+
+	// cookie sessions keep tokens out of the URL, the frontend reads the CSRF token from its cookie
+	if h.sessions != nil && h.config.Current().OIDC.FrontendURL != "" {
+		h.sessions.Start(c.Writer, tokenPair)
+		c.Redirect(http.StatusFound, h.config.Current().OIDC.FrontendURL)
+		return
+	}
+
+	if h.config.Current().OIDC.FrontendURL != "" {
+		fragment := url.Values{
+			"access_token":  {tokenPair.AccessToken},
+			"refresh_token": {tokenPair.RefreshToken},
+		}
+		c.Redirect(http.StatusFound, h.config.Current().OIDC.FrontendURL+"#"+fragment.Encode())
+		return
+	}
+
+	h.respondTokens(c, tokenPair)
+}
+
+// oidcUser returns the user of a single sign-on identity, it is linked by verified email or
+// created on first login. Linking an unverified account clears its password. Errors shown to the user are returned as *internal.APIError.
+func (h *Handler) oidcUser(ctx context.Context, identity internal.OIDCIdentity) (models.User, error) {
+	db := h.db.WithContext(ctx)
+
+	user, err := db.GetUserByOIDCSubject(identity.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.User{}, err
+	}
+
+	found := err == nil
+	if !found {
+		if identity.Email == "" || !identity.EmailVerified {
+			return models.User{}, internal.NewAPIError(http.StatusForbidden, internal.ErrCodeForbidden, "a verified email is required from the single sign-on provider")
+		}
+
+		user, err = db.GetUserByEmail(identity.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, err
+		}
+
+		found = err == nil
+		if found && user.OIDCSubject != "" {
+			return models.User{}, internal.NewAPIError(http.StatusConflict, internal.ErrCodeConflict, "account is linked to another single sign-on identity")
+		}
+	}
+
+	admin := internal.Contains(h.config.Current().Admins, identity.Email)
+	if h.oidc.MapsAdmins() {
+		admin = h.oidc.IsAdmin(identity) || internal.Contains(h.config.Current().Admins, identity.Email)
+	}
+
+	if found {
+		err := db.Transaction(func(tx models.DB) error {
+			// whoever registered an unverified account did not prove owning the email,
+			// the password they chose must not keep access to the account of the owner
+			if !user.Verified {
+				if err := tx.UpdatePassword(user.ID, nil); err != nil {
+					return err
+				}
+			}
+			return tx.UpdateUserOIDC(user.ID, identity.Subject, admin)
+		})
+		if err != nil {
+			return models.User{}, err
+		}
+		user.OIDCSubject = identity.Subject
+		user.Admin = admin
+		user.Verified = true
+		return user, nil
+	}
+
+	user = models.User{
+		Username:    identity.Username,
+		Email:       identity.Email,
+		Verified:    true,
+		Admin:       admin,
+		OIDCSubject: identity.Subject,
+	}
+	if err := db.RegisterUser(&user); err != nil {
+		return models.User{}, err
+	}
+	internal.Signups.WithLabelValues(internal.SignupMethodOIDC).Inc()
+
+	err = h.webhooks.Publish(user.ID, models.EventUserRegistered, gin.H{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"email":    user.Email,
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Int("user_id", user.ID).Msg("failed to publish webhook event")
+	}
+
+	return user, nil
+}
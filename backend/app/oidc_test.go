@@ -0,0 +1,282 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockIdP is a minimal OpenID Connect provider issuing RS256 ID tokens for a fixed set of claims
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	codes  map[string]url.Values // authorize request by code
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{t: t, key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := "code-" + query.Get("state")
+		idp.codes[code] = query
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+query.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		authorize, ok := idp.codes[r.FormValue("code")]
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorize.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   authorize.Get("client_id"),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": authorize.Get("nonce"),
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func newOIDCTestApp(t *testing.T, issuer string) *App {
+	return newTestApp(t, func(config *internal.Configuration) {
+		config.OIDC = internal.OIDC{
+			Enabled:     true,
+			Issuer:      issuer,
+			ClientID:    "kubecloud",
+			RedirectURL: "http://localhost/api/v1/user/oidc/callback",
+			AdminGroups: []string{"ops"},
+		}
+		// every login goes through the auth policy
+		config.RateLimit.Disabled = true
+	})
+}
+
+// oidcLogin runs the whole browser flow and returns the callback response
+func oidcLogin(t *testing.T, app *App) *httptest.ResponseRecorder {
+	return oidcLoginFrom(t, app, true)
+}
+
+// oidcLoginFrom runs the browser flow, the callback is sent without the state cookie unless sameBrowser
+func oidcLoginFrom(t *testing.T, app *App, sameBrowser bool) *httptest.ResponseRecorder {
+	req, cookies := startOIDCLogin(t, app)
+	if sameBrowser {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	return w
+}
+
+// startOIDCLogin runs the browser flow until the provider redirects back, it returns the callback request
+// and the cookies set when the login started
+func startOIDCLogin(t *testing.T, app *App) (*http.Request, []*http.Cookie) {
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/user/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body.String())
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil), w.Result().Cookies()
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	app := newOIDCTestApp(t, idp.server.URL)
+
+	claimsOf := func(w *httptest.ResponseRecorder) *internal.TokenClaims {
+		if w.Code != http.StatusCreated {
+			t.Fatalf("callback returned %d: %s", w.Code, w.Body.String())
+		}
+		var tokens internal.TokenPair
+		if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+			t.Fatal(err)
+		}
+		claims, err := app.handlers.tokenManager.VerifyToken(tokens.AccessToken, internal.TokenTypeAccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims
+	}
+
+	t.Run("provisions user with admin group", func(t *testing.T) {
+		idp.claims = jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice", "groups": []string{"ops"}}
+		claims := claimsOf(oidcLogin(t, app))
+		if !claims.Admin || claims.Username != "alice" {
+			t.Fatalf("unexpected claims %+v", claims)
+		}
+
+		// groups are applied again on every login
+		idp.claims["groups"] = []string{"dev"}
+		if claims := claimsOf(oidcLogin(t, app)); claims.Admin {
+			t.Fatal("admin role was not removed")
+		}
+	})
+
+	t.Run("links existing user by verified email", func(t *testing.T) {
+		user := models.User{Username: "bob", Email: "bob@example.com"}
+		if err := app.handlers.db.RegisterUser(&user); err != nil {
+			t.Fatal(err)
+		}
+
+		idp.claims = jwt.MapClaims{"sub": "bob", "email": "bob@example.com", "email_verified": true}
+		if claims := claimsOf(oidcLogin(t, app)); claims.UserID != user.ID {
+			t.Fatalf("expected user %d, got %d", user.ID, claims.UserID)
+		}
+
+		linked, err := app.handlers.db.GetUserByOIDCSubject("bob")
+		if err != nil || !linked.Verified {
+			t.Fatalf("user was not linked: %v", err)
+		}
+	})
+
+	t.Run("clears password of linked unverified user", func(t *testing.T) {
+		password, err := internal.HashAndSaltPassword([]byte("attacker password"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// registered by someone not owning the email, who never verified it
+		user := models.User{Username: "carol", Email: "carol@example.com", Password: password}
+		if err := app.handlers.db.RegisterUser(&user); err != nil {
+			t.Fatal(err)
+		}
+
+		idp.claims = jwt.MapClaims{"sub": "carol", "email": "carol@example.com", "email_verified": true}
+		if claims := claimsOf(oidcLogin(t, app)); claims.UserID != user.ID {
+			t.Fatalf("expected user %d, got %d", user.ID, claims.UserID)
+		}
+
+		login := `{"email":"carol@example.com","password":"attacker password"}`
+		if w := serve(app, http.MethodPost, "/api/v1/user/login", "", login); w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the password of the unverified account to be cleared, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("rejects callback from another browser", func(t *testing.T) {
+		idp.claims = jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+		if w := oidcLoginFrom(t, app, false); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("completes login started on another replica", func(t *testing.T) {
+		idp.claims = jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+		replica := newOIDCTestApp(t, idp.server.URL)
+
+		req, cookies := startOIDCLogin(t, app)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		replica.router.ServeHTTP(w, req)
+		// the replica has its own database, so alice signs up there
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("rejects tampered state cookie", func(t *testing.T) {
+		idp.claims = jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+		req, cookies := startOIDCLogin(t, app)
+		for _, cookie := range cookies {
+			if cookie.Name == internal.OIDCStateCookie {
+				// the payload is changed, the signature is kept
+				cookie.Value = "e30" + cookie.Value[strings.Index(cookie.Value, "."):]
+			}
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		app.router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("rejects unverified email", func(t *testing.T) {
+		idp.claims = jwt.MapClaims{"sub": "eve", "email": "bob@example.com", "email_verified": false}
+		if w := oidcLogin(t, app); w.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("rejects replayed state", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/user/oidc/callback?code=x&state=unknown", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,326 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// apiVersion is the version of the OpenAPI document
+const apiVersion = "1.0.0"
+
+// apiOperation documents a route, the document is generated from registered routes and fails
+// the contract test if a route is missing here
+type apiOperation struct {
+	summary  string
+	tag      string
+	public   bool        // no bearer token needed
+	query    interface{} // struct bound with form tags
+	request  interface{} // JSON body
+	response interface{} // JSON body of the success status, nil for no content
+	status   int         // success status, defaults to 200
+	download string      // content type of an alternative binary success response
+	redirect bool        // may redirect instead of responding
+}
+
+// ErrorResponse is returned with every failed request
+type ErrorResponse struct {
+	Error internal.APIError `json:"error"`
+}
+
+// MessageResponse is returned by requests without data to return
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// CodeSentResponse is returned when a verification code is mailed
+type CodeSentResponse struct {
+	Message string `json:"message"`
+	Timeout int    `json:"timeout"` // in seconds
+}
+
+// AccessTokenResponse is returned when an access token is refreshed
+type AccessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// SessionResponse is returned instead of tokens to cookie sessions
+type SessionResponse struct {
+	CSRFToken string `json:"csrf_token"` // sent back in the X-CSRF-Token header on requests changing state
+}
+
+// LanguageResponse is returned when the language of a user is changed
+type LanguageResponse struct {
+	Message    string `json:"message"`
+	MailLocale string `json:"mail_locale"`
+}
+
+// ChallengeResponse holds what a client needs to solve the challenge of register and forgot password
+type ChallengeResponse struct {
+	Provider  string                    `json:"provider"`
+	SiteKey   string                    `json:"site_key"`
+	Challenge *internal.IssuedChallenge `json:"challenge,omitempty"`
+}
+
+// MarkedReadResponse is returned when notifications are marked as read
+type MarkedReadResponse struct {
+	Message string `json:"message"`
+	Count   int64  `json:"count"`
+}
+
+// CreatedAPITokenResponse holds a new personal access token, shown only once
+type CreatedAPITokenResponse struct {
+	Token    string          `json:"token"`
+	APIToken models.APIToken `json:"api_token"`
+}
+
+// CreatedWebhookResponse holds a new webhook and its signing secret, shown only once
+type CreatedWebhookResponse struct {
+	Webhook models.Webhook `json:"webhook"`
+	Secret  string         `json:"secret"`
+}
+
+// GeneratedVouchersResponse holds generated vouchers
+type GeneratedVouchersResponse struct {
+	Message  string           `json:"message"`
+	Vouchers []models.Voucher `json:"vouchers"`
+}
+
+// CreditResponse is returned when a user is credited
+type CreditResponse struct {
+	Message string  `json:"message"`
+	User    string  `json:"user"`
+	Amount  float64 `json:"amount"`
+	Memo    string  `json:"memo"`
+}
+
+// OrganizationCreditResponse is returned when an organization is credited
+type OrganizationCreditResponse struct {
+	Message      string  `json:"message"`
+	Organization string  `json:"organization"`
+	Amount       float64 `json:"amount"`
+	Memo         string  `json:"memo"`
+}
+
+// AuditVerifyResponse is returned when the audit log chain is intact
+type AuditVerifyResponse struct {
+	Valid   bool `json:"valid"`
+	Entries int  `json:"entries"`
+}
+
+// ExportQuery selects the format of a data export
+type ExportQuery struct {
+	Format string `form:"format" binding:"omitempty,oneof=json zip"`
+}
+
+var apiOperations = map[string]apiOperation{
+	"GET /api/v1/openapi.json": {summary: "OpenAPI document of this API", tag: "meta", public: true, response: internal.OpenAPIDocument{}},
+
+	"GET /api/v1/user/challenge":               {summary: "Get the challenge required by register and forgot password", tag: "auth", public: true, response: ChallengeResponse{}},
+	"POST /api/v1/user/register":               {summary: "Register a new user and mail a verification code", tag: "auth", public: true, request: RegisterInput{}, response: CodeSentResponse{}},
+	"POST /api/v1/user/register/verify":        {summary: "Verify a registration code", tag: "auth", public: true, query: SessionQuery{}, request: VerifyCodeInput{}, response: internal.TokenPair{}, status: http.StatusCreated},
+	"POST /api/v1/user/login":                  {summary: "Log in with email and password", tag: "auth", public: true, query: SessionQuery{}, request: LoginInput{}, response: internal.TokenPair{}, status: http.StatusCreated},
+	"POST /api/v1/user/refresh":                {summary: "Get a new access token from a refresh token", tag: "auth", public: true, request: RefreshTokenInput{}, response: AccessTokenResponse{}},
+	"POST /api/v1/user/logout":                 {summary: "End the session and revoke its refresh token", tag: "auth", public: true, request: LogoutInput{}, status: http.StatusNoContent},
+	"POST /api/v1/user/forgot_password":        {summary: "Mail a password reset code", tag: "auth", public: true, request: EmailInput{}, response: CodeSentResponse{}},
+	"POST /api/v1/user/forgot_password/verify": {summary: "Verify a password reset code", tag: "auth", public: true, query: SessionQuery{}, request: VerifyCodeInput{}, response: internal.TokenPair{}, status: http.StatusCreated},
+	"GET /api/v1/user/oidc/login":              {summary: "Redirect to the single sign-on provider", tag: "auth", public: true, status: http.StatusFound},
+	"GET /api/v1/user/oidc/callback":           {summary: "Log in after the single sign-on provider redirects back", tag: "auth", public: true, response: internal.TokenPair{}, status: http.StatusCreated, redirect: true},
+
+	"POST /api/v1/user/change_password":                {summary: "Change password", tag: "user", request: ChangePasswordInput{}, response: MessageResponse{}},
+	"PUT /api/v1/user/language":                        {summary: "Change preferred language", tag: "user", request: LanguageInput{}, response: LanguageResponse{}},
+	"GET /api/v1/user/quota":                           {summary: "Get resource limits", tag: "user", response: QuotaResponse{}},
+	"GET /api/v1/user/transactions":                    {summary: "List transactions of the user or its active organization", tag: "user", response: []models.Transaction{}},
+	"GET /api/v1/user/me/export":                       {summary: "Export all data stored about the user", tag: "user", query: ExportQuery{}, response: UserExport{}, download: "application/zip"},
+	"GET /api/v1/user/notifications":                   {summary: "List notifications", tag: "notifications", response: []models.Notification{}},
+	"POST /api/v1/user/notifications/read":             {summary: "Mark notifications as read", tag: "notifications", request: MarkReadInput{}, response: MarkedReadResponse{}},
+	"GET /api/v1/user/notifications/preferences":       {summary: "Get notification preferences", tag: "notifications", response: []models.NotificationPreference{}},
+	"PUT /api/v1/user/notifications/preferences":       {summary: "Set notification preferences", tag: "notifications", request: []models.NotificationPreference{}, response: []models.NotificationPreference{}},
+	"GET /api/v1/user/tokens":                          {summary: "List personal access tokens", tag: "tokens", response: []models.APIToken{}},
+	"POST /api/v1/user/tokens":                         {summary: "Create a personal access token", tag: "tokens", request: APITokenInput{}, response: CreatedAPITokenResponse{}, status: http.StatusCreated},
+	"DELETE /api/v1/user/tokens/:token_id":             {summary: "Revoke a personal access token", tag: "tokens", response: MessageResponse{}},
+	"GET /api/v1/user/webhooks":                        {summary: "List webhooks of the user or its active organization", tag: "webhooks", response: []models.Webhook{}},
+	"POST /api/v1/user/webhooks":                       {summary: "Register a webhook", tag: "webhooks", request: WebhookInput{}, response: CreatedWebhookResponse{}, status: http.StatusCreated},
+	"DELETE /api/v1/user/webhooks/:webhook_id":         {summary: "Delete a webhook", tag: "webhooks", response: MessageResponse{}},
+	"GET /api/v1/user/webhooks/:webhook_id/deliveries": {summary: "List recent deliveries of a webhook", tag: "webhooks", response: []models.WebhookDelivery{}},
+	"POST /api/v1/user/webhooks/:webhook_id/ping":      {summary: "Send a ping event to a webhook", tag: "webhooks", response: models.WebhookDelivery{}},
+
+	"GET /api/v1/user":                                         {summary: "List users", tag: "admin", response: []models.User{}},
+	"DELETE /api/v1/user/:user_id":                             {summary: "Delete a user", tag: "admin", response: MessageResponse{}},
+	"POST /api/v1/user/:user_id/credit":                        {summary: "Credit balance of a user", tag: "admin", request: CreditRequestInput{}, response: CreditResponse{}},
+	"GET /api/v1/user/:user_id/quota":                          {summary: "Get resource limits of a user", tag: "admin", response: QuotaResponse{}},
+	"PUT /api/v1/user/:user_id/quota":                          {summary: "Override resource limits of a user", tag: "admin", request: models.Resources{}, response: QuotaResponse{}},
+	"DELETE /api/v1/user/:user_id/quota":                       {summary: "Reset resource limits of a user to defaults", tag: "admin", response: MessageResponse{}},
+	"POST /api/v1/user/vouchers/generate":                      {summary: "Generate vouchers", tag: "admin", request: GenerateVouchersInput{}, response: GeneratedVouchersResponse{}, status: http.StatusCreated},
+	"GET /api/v1/user/vouchers":                                {summary: "List vouchers", tag: "admin", response: []models.Voucher{}},
+	"GET /api/v1/admin/audit":                                  {summary: "List audit logs", tag: "admin", query: AuditFilterInput{}, response: []models.AuditLog{}},
+	"GET /api/v1/admin/config":                                 {summary: "Get the version of the active configuration", tag: "admin", response: internal.ConfigVersion{}},
+	"POST /api/v1/admin/config/reload":                         {summary: "Reload the configuration, invalid configurations are rejected", tag: "admin", response: internal.ConfigVersion{}},
+	"GET /api/v1/admin/audit/verify":                           {summary: "Verify the audit log hash chain", tag: "admin", response: AuditVerifyResponse{}},
+	"GET /api/v1/admin/mails":                                  {summary: "List queued mails", tag: "admin", query: OutboxFilterInput{}, response: []models.OutboxMail{}},
+	"POST /api/v1/admin/mails/:mail_id/retry":                  {summary: "Retry a queued mail", tag: "admin", response: MessageResponse{}},
+	"GET /api/v1/admin/organizations":                          {summary: "List organizations", tag: "admin", response: []models.Organization{}},
+	"POST /api/v1/admin/organizations/:organization_id/credit": {summary: "Credit balance of an organization", tag: "admin", request: CreditRequestInput{}, response: OrganizationCreditResponse{}},
+
+	"GET /api/v1/organizations":                                       {summary: "List organizations of the user", tag: "organizations", response: []models.Organization{}},
+	"POST /api/v1/organizations":                                      {summary: "Create an organization", tag: "organizations", request: OrganizationInput{}, response: models.Organization{}, status: http.StatusCreated},
+	"POST /api/v1/organizations/switch":                               {summary: "Get tokens acting on an organization", tag: "organizations", query: SessionQuery{}, request: SwitchOrganizationInput{}, response: internal.TokenPair{}, status: http.StatusCreated},
+	"POST /api/v1/organizations/invitations/accept":                   {summary: "Accept an invitation", tag: "organizations", request: AcceptInvitationInput{}, response: models.Organization{}},
+	"GET /api/v1/organizations/current":                               {summary: "Get the active organization", tag: "organizations", response: models.Organization{}},
+	"PUT /api/v1/organizations/current":                               {summary: "Rename the active organization", tag: "organizations", request: OrganizationInput{}, response: MessageResponse{}},
+	"DELETE /api/v1/organizations/current":                            {summary: "Delete the active organization", tag: "organizations", response: MessageResponse{}},
+	"GET /api/v1/organizations/current/members":                       {summary: "List members", tag: "organizations", response: []models.OrganizationMember{}},
+	"PUT /api/v1/organizations/current/members/:user_id":              {summary: "Change role of a member", tag: "organizations", request: OrganizationRoleInput{}, response: MessageResponse{}},
+	"DELETE /api/v1/organizations/current/members/:user_id":           {summary: "Remove a member", tag: "organizations", response: MessageResponse{}},
+	"GET /api/v1/organizations/current/invitations":                   {summary: "List pending invitations", tag: "organizations", response: []models.OrganizationInvitation{}},
+	"POST /api/v1/organizations/current/invitations":                  {summary: "Invite an email", tag: "organizations", request: InvitationInput{}, response: models.OrganizationInvitation{}, status: http.StatusCreated},
+	"DELETE /api/v1/organizations/current/invitations/:invitation_id": {summary: "Cancel an invitation", tag: "organizations", response: MessageResponse{}},
+	"GET /api/v1/organizations/current/transactions":                  {summary: "List the ledger", tag: "organizations", response: []models.Transaction{}},
+}
+
+// dev only and operational routes are not part of the document
+var undocumentedRoutes = map[string]bool{
+	"GET /api/v1/docs": true,
+	"GET /metrics":     true,
+	"GET /healthz":     true,
+	"GET /readyz":      true,
+	"GET /version":     true,
+}
+
+var pathParam = regexp.MustCompile(`:([a-z_]+)`)
+
+// buildOpenAPI generates the document of registered routes, routes missing from apiOperations are skipped
+func buildOpenAPI(routes gin.RoutesInfo) internal.OpenAPIDocument {
+	schemas := internal.NewOpenAPISchemas()
+	errorSchema := schemas.Of(ErrorResponse{})
+
+	// clients switch on error codes so all of them are listed
+	codes := make([]string, len(internal.ErrorCodes))
+	for i, code := range internal.ErrorCodes {
+		codes[i] = string(code)
+	}
+	schemas.Resolve(schemas.Of(internal.APIError{})).Properties["code"].Enum = codes
+
+	document := internal.OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    internal.OpenAPIInfo{Title: "KubeCloud API", Version: apiVersion},
+		Paths:   map[string]map[string]*internal.OpenAPIOperation{},
+		Components: internal.OpenAPIComponents{
+			Schemas: schemas.Components,
+			SecuritySchemes: map[string]internal.OpenAPISecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", Description: "JWT access token or personal access token"},
+				"cookieAuth": {Type: "apiKey", In: "cookie", Name: internal.SessionAccessCookie, Description: "Cookie session, requests changing state also need the X-CSRF-Token header"},
+			},
+		},
+	}
+
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		if undocumentedRoutes[key] {
+			continue
+		}
+
+		api, ok := apiOperations[key]
+		if !ok {
+			log.Warn().Str("route", key).Msg("route is missing from the OpenAPI document")
+			continue
+		}
+
+		operation := &internal.OpenAPIOperation{
+			OperationID: route.Method + strings.ReplaceAll(pathParam.ReplaceAllString(route.Path, "by_$1"), "/", "_"),
+			Summary:     api.summary,
+			Tags:        []string{api.tag},
+			Responses: map[string]internal.OpenAPIResponse{
+				"default": {
+					Description: "Error",
+					Content:     map[string]internal.OpenAPIMediaType{"application/json": {Schema: errorSchema}},
+				},
+			},
+		}
+
+		if !api.public {
+			operation.Security = []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}}
+		}
+
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			operation.Parameters = append(operation.Parameters, internal.OpenAPIParameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &internal.OpenAPISchema{Type: "integer"},
+			})
+		}
+
+		if api.query != nil {
+			operation.Parameters = append(operation.Parameters, schemas.QueryParameters(api.query)...)
+		}
+
+		if api.request != nil {
+			operation.RequestBody = &internal.OpenAPIRequestBody{
+				Required: true,
+				Content:  map[string]internal.OpenAPIMediaType{"application/json": {Schema: schemas.Of(api.request)}},
+			}
+		}
+
+		status := api.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		success := internal.OpenAPIResponse{Description: http.StatusText(status)}
+		if api.response != nil {
+			success.Content = map[string]internal.OpenAPIMediaType{"application/json": {Schema: schemas.Of(api.response)}}
+		}
+		if api.download != "" {
+			success.Content[api.download] = internal.OpenAPIMediaType{Schema: &internal.OpenAPISchema{Type: "string", Format: "binary"}}
+		}
+		operation.Responses[strconv.Itoa(status)] = success
+
+		if api.redirect {
+			operation.Responses[strconv.Itoa(http.StatusFound)] = internal.OpenAPIResponse{Description: http.StatusText(http.StatusFound)}
+		}
+
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if document.Paths[path] == nil {
+			document.Paths[path] = map[string]*internal.OpenAPIOperation{}
+		}
+		document.Paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	return document
+}
+
+// OpenAPIHandler serves the OpenAPI document
+func (app *App) OpenAPIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.openAPI)
+}
+
+// SwaggerUIHandler serves Swagger UI for the OpenAPI document, only registered in dev mode
+func (app *App) SwaggerUIHandler(c *gin.Context) {
+	// the page loads Swagger UI from unpkg, which the API content security policy forbids
+	c.Header("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' https://unpkg.com; style-src 'self' 'unsafe-inline' https://unpkg.com; img-src 'self' data: https://unpkg.com")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8" />
+    <title>KubeCloud API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+  </head>
+  <body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+      window.ui = SwaggerUIBundle({ url: "/api/v1/openapi.json", dom_id: "#swagger-ui" });
+    </script>
+  </body>
+</html>
+`
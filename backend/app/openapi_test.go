@@ -0,0 +1,322 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"kubecloud/internal"
+	"kubecloud/middlewares"
+	"kubecloud/models"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIRoutesDocumented(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Server.Dev = true
+	})
+
+	registered := map[string]bool{}
+	for _, route := range app.router.Routes() {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		if _, ok := apiOperations[key]; !ok && !undocumentedRoutes[key] {
+			t.Errorf("route %s is missing from apiOperations", key)
+		}
+	}
+
+	for key := range apiOperations {
+		// single sign-on routes are only registered when it is enabled
+		if !registered[key] && !strings.HasPrefix(key, "GET /api/v1/user/oidc/") {
+			t.Errorf("apiOperations documents %s which is not registered", key)
+		}
+	}
+}
+
+// contractClient sends requests to the app and checks responses against the served document
+type contractClient struct {
+	t        *testing.T
+	app      *App
+	document internal.OpenAPIDocument
+	schemas  *internal.OpenAPISchemas
+	header   http.Header // sent with every request
+}
+
+func (c *contractClient) do(method, path, route, token string, body interface{}) []byte {
+	c.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &reader)
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	c.app.router.ServeHTTP(w, req)
+
+	operation := c.document.Paths[route][strings.ToLower(method)]
+	if operation == nil {
+		c.t.Fatalf("%s %s is not documented", method, route)
+	}
+
+	response, ok := operation.Responses[strconv.Itoa(w.Code)]
+	if !ok {
+		if w.Code < http.StatusBadRequest {
+			c.t.Fatalf("%s %s returned undocumented status %d: %s", method, path, w.Code, w.Body.String())
+		}
+		response = operation.Responses["default"]
+	}
+
+	media, ok := response.Content["application/json"]
+	if !ok {
+		return w.Body.Bytes()
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+		c.t.Fatalf("%s %s returned invalid JSON: %v", method, path, err)
+	}
+	if err := c.validate(media.Schema, value, "body"); err != nil {
+		c.t.Errorf("%s %s returned %d not matching the document: %v\n%s", method, path, w.Code, err, w.Body.String())
+	}
+
+	return w.Body.Bytes()
+}
+
+func (c *contractClient) validate(schema *internal.OpenAPISchema, value interface{}, at string) error {
+	schema = c.schemas.Resolve(schema)
+	if schema == nil {
+		return fmt.Errorf("%s: unresolved schema", at)
+	}
+
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not nullable", at)
+	}
+
+	switch schema.Type {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", at, value)
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a number", at, value)
+		}
+		if schema.Type == "integer" && number != math.Trunc(number) {
+			return fmt.Errorf("%s: %v is not an integer", at, value)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", at, value)
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %v", at, str, schema.Enum)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", at, value)
+		}
+		for i, item := range items {
+			if err := c.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", at, value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required %s", at, name)
+			}
+		}
+
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				property = schema.AdditionalProperties
+			}
+			if property == nil {
+				return fmt.Errorf("%s: undocumented property %s", at, name)
+			}
+			if err := c.validate(property, object[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRequestSchemas sends every documented request body with its required fields empty and expects exactly
+// those fields to fail validation, so the document can't drift from the binding tags
+func (c *contractClient) checkRequestSchemas(token string) {
+	c.t.Helper()
+
+	routes := make([]string, 0, len(c.document.Paths))
+	for route := range c.document.Paths {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		for method, operation := range c.document.Paths[route] {
+			if operation.RequestBody == nil {
+				continue
+			}
+			schema := c.schemas.Resolve(operation.RequestBody.Content["application/json"].Schema)
+			if schema == nil || len(schema.Required) == 0 {
+				continue
+			}
+
+			body := map[string]interface{}{}
+			for _, name := range schema.Required {
+				switch c.schemas.Resolve(schema.Properties[name]).Type {
+				case "string":
+					body[name] = ""
+				case "integer", "number":
+					body[name] = 0
+				case "boolean":
+					body[name] = false
+				default:
+					body[name] = nil
+				}
+			}
+
+			path := route
+			for strings.Contains(path, "{") {
+				path = path[:strings.Index(path, "{")] + "1" + path[strings.Index(path, "}")+1:]
+			}
+
+			var response ErrorResponse
+			if err := json.Unmarshal(c.do(strings.ToUpper(method), path, route, token, body), &response); err != nil {
+				c.t.Fatal(err)
+			}
+
+			var fields []string
+			for _, detail := range response.Error.Details {
+				fields = append(fields, detail.Field)
+			}
+			sort.Strings(fields)
+			required := append([]string{}, schema.Required...)
+			sort.Strings(required)
+
+			if response.Error.Code != internal.ErrCodeValidationFailed || strings.Join(fields, ",") != strings.Join(required, ",") {
+				c.t.Errorf("%s %s: expected required fields %v to fail validation, got %s %v", strings.ToUpper(method), route, required, response.Error.Code, fields)
+			}
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Admins = []string{"admin@kubecloud.io"}
+		config.RateLimit.Disabled = true
+	})
+
+	// the served document is checked, not the one in memory
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("openapi.json returned %d", w.Code)
+	}
+
+	var document internal.OpenAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	schemas := &internal.OpenAPISchemas{Components: document.Components.Schemas}
+	c := &contractClient{t: t, app: app, document: document, schemas: schemas}
+
+	password, err := internal.HashAndSaltPassword([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.handlers.db.RegisterUser(&models.User{Username: "admin", Email: "admin@kubecloud.io", Password: password, Verified: true, Admin: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.do(http.MethodGet, "/api/v1/openapi.json", "/api/v1/openapi.json", "", nil)
+	c.do(http.MethodGet, "/api/v1/user/challenge", "/api/v1/user/challenge", "", nil)
+
+	c.do(http.MethodPost, "/api/v1/user/register", "/api/v1/user/register", "", RegisterInput{
+		Name: "user", Email: "user@kubecloud.io", Password: "password", ConfirmPassword: "password",
+	})
+	c.do(http.MethodPost, "/api/v1/user/register", "/api/v1/user/register", "", map[string]string{"email": "not an email"})
+
+	c.do(http.MethodPost, "/api/v1/user/login", "/api/v1/user/login", "", LoginInput{Email: "admin@kubecloud.io", Password: "wrong password"})
+	var tokens internal.TokenPair
+	body := c.do(http.MethodPost, "/api/v1/user/login", "/api/v1/user/login", "", LoginInput{Email: "admin@kubecloud.io", Password: "password"})
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("login failed: %s", body)
+	}
+
+	c.do(http.MethodPost, "/api/v1/user/refresh", "/api/v1/user/refresh", "", RefreshTokenInput{RefreshToken: tokens.RefreshToken})
+	c.do(http.MethodPost, "/api/v1/user/logout", "/api/v1/user/logout", "", nil)
+	c.do(http.MethodGet, "/api/v1/user/quota", "/api/v1/user/quota", "", nil)
+	c.do(http.MethodGet, "/api/v1/user/quota", "/api/v1/user/quota", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/user/me/export", "/api/v1/user/me/export", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/user/notifications", "/api/v1/user/notifications", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/user/notifications/preferences", "/api/v1/user/notifications/preferences", tokens.AccessToken, nil)
+
+	c.do(http.MethodPost, "/api/v1/user/tokens", "/api/v1/user/tokens", tokens.AccessToken, APITokenInput{Name: "ci-bot", Scopes: []string{"read"}})
+	c.do(http.MethodGet, "/api/v1/user/tokens", "/api/v1/user/tokens", tokens.AccessToken, nil)
+	c.do(http.MethodDelete, "/api/v1/user/tokens/1", "/api/v1/user/tokens/{token_id}", tokens.AccessToken, nil)
+	c.do(http.MethodDelete, "/api/v1/user/tokens/1", "/api/v1/user/tokens/{token_id}", tokens.AccessToken, nil)
+
+	var organization models.Organization
+	body = c.do(http.MethodPost, "/api/v1/organizations", "/api/v1/organizations", tokens.AccessToken, OrganizationInput{Name: "acme"})
+	if err := json.Unmarshal(body, &organization); err != nil {
+		t.Fatal(err)
+	}
+	c.do(http.MethodGet, "/api/v1/organizations", "/api/v1/organizations", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/organizations/current", "/api/v1/organizations/current", tokens.AccessToken, nil)
+
+	c.do(http.MethodGet, "/api/v1/user", "/api/v1/user", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/user/1/quota", "/api/v1/user/{user_id}/quota", tokens.AccessToken, nil)
+	c.do(http.MethodPost, "/api/v1/user/vouchers/generate", "/api/v1/user/vouchers/generate", tokens.AccessToken, GenerateVouchersInput{Count: 1, Value: 10, ExpireAfter: 30})
+	c.do(http.MethodGet, "/api/v1/user/vouchers", "/api/v1/user/vouchers", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/admin/audit", "/api/v1/admin/audit", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/admin/audit/verify", "/api/v1/admin/audit/verify", tokens.AccessToken, nil)
+	c.do(http.MethodGet, "/api/v1/admin/organizations", "/api/v1/admin/organizations", tokens.AccessToken, nil)
+
+	c.header = http.Header{}
+	c.header.Set(middlewares.OrganizationHeader, strconv.Itoa(organization.ID))
+	c.checkRequestSchemas(tokens.AccessToken)
+}
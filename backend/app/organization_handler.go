@@ -0,0 +1,582 @@
+package app
+
+import (
+	"errors"
+	"kubecloud/internal"
+	"kubecloud/middlewares"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// invitationExpiryDays is how long an invitation can be accepted
+const invitationExpiryDays = 7
+
+// OrganizationInput holds data needed to create or rename an organization
+type OrganizationInput struct {
+	Name string `json:"name" binding:"required,min=3,max=64"`
+}
+
+// SwitchOrganizationInput selects the active organization, zero switches back to the personal account
+type SwitchOrganizationInput struct {
+	OrganizationID int `json:"organization_id" binding:"gte=0"`
+}
+
+// OrganizationRoleInput holds the new role of a member
+type OrganizationRoleInput struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member billing"`
+}
+
+// InvitationInput holds data needed to invite an email to the organization
+type InvitationInput struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=owner admin member billing"`
+}
+
+// AcceptInvitationInput holds the token of an invitation mail
+type AcceptInvitationInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// CreateOrganizationHandler creates an organization owned by the logged in user
+func (h *Handler) CreateOrganizationHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request OrganizationInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	organization := models.Organization{Name: request.Name}
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		if err := tx.CreateOrganization(&organization); err != nil {
+			return err
+		}
+
+		return tx.AddOrganizationMember(&models.OrganizationMember{
+			OrganizationID: organization.ID,
+			UserID:         ID,
+			Role:           models.OrganizationRoleOwner,
+		})
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to create organization")
+		abortInternal(c)
+		return
+	}
+
+	organization.Role = models.OrganizationRoleOwner
+	c.JSON(http.StatusCreated, organization)
+}
+
+// ListOrganizationsHandler lists organizations of the logged in user with its role in each
+func (h *Handler) ListOrganizationsHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	organizations, err := h.db.WithContext(c).ListUserOrganizations(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list organizations")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, organizations)
+}
+
+// SwitchOrganizationHandler returns tokens acting on the selected organization
+func (h *Handler) SwitchOrganizationHandler(c *gin.Context) {
+	if !loggedIn(c) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Use the "+middlewares.OrganizationHeader+" header with tokens")
+		return
+	}
+
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request SwitchOrganizationInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	if request.OrganizationID != 0 {
+		_, err := h.db.WithContext(c).GetOrganizationMember(request.OrganizationID, ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "You are not a member of this organization")
+			return
+		}
+
+		if err != nil {
+			log.Ctx(c).Error().Err(err).Msg("failed to get organization member")
+			abortInternal(c)
+			return
+		}
+	}
+
+	user, err := h.db.WithContext(c).GetUserByID(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	if !h.reevaluateAdmin(c, &user) {
+		return
+	}
+
+	tokenPair, err := h.tokenManager.CreateOrganizationTokenPair(user.ID, user.Username, user.Admin, request.OrganizationID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("Failed to generate token pair")
+		abortInternal(c)
+		return
+	}
+	h.respondTokens(c, tokenPair)
+}
+
+// GetOrganizationHandler returns the active organization
+func (h *Handler) GetOrganizationHandler(c *gin.Context) {
+	organization, err := h.db.WithContext(c).GetOrganization(c.GetInt("organization_id"))
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "Organization not found")
+		return
+	}
+
+	organization.Role = c.GetString("organization_role")
+	c.JSON(http.StatusOK, organization)
+}
+
+// UpdateOrganizationHandler renames the active organization
+func (h *Handler) UpdateOrganizationHandler(c *gin.Context) {
+	var request OrganizationInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	if err := h.db.WithContext(c).UpdateOrganizationName(c.GetInt("organization_id"), request.Name); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to rename organization")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization is updated successfully"})
+}
+
+// DeleteOrganizationHandler deletes the active organization
+func (h *Handler) DeleteOrganizationHandler(c *gin.Context) {
+	if err := h.db.WithContext(c).DeleteOrganization(c.GetInt("organization_id")); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to delete organization")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization is deleted successfully"})
+}
+
+// ListOrganizationMembersHandler lists members of the active organization
+func (h *Handler) ListOrganizationMembersHandler(c *gin.Context) {
+	members, err := h.db.WithContext(c).ListOrganizationMembers(c.GetInt("organization_id"))
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list organization members")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// UpdateOrganizationMemberHandler changes role of a member of the active organization
+func (h *Handler) UpdateOrganizationMemberHandler(c *gin.Context) {
+	organizationID := c.GetInt("organization_id")
+
+	var request OrganizationRoleInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	member, ok := h.organizationMember(c, organizationID)
+	if !ok {
+		return
+	}
+
+	actorRole := c.GetString("organization_role")
+	if !internal.CanAssignOrganizationRole(actorRole, member.Role) || !internal.CanAssignOrganizationRole(actorRole, request.Role) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Only owners can manage owners")
+		return
+	}
+
+	if member.Role == models.OrganizationRoleOwner && request.Role != models.OrganizationRoleOwner && !h.hasOtherOwner(c, organizationID) {
+		return
+	}
+
+	if err := h.db.WithContext(c).UpdateOrganizationMemberRole(organizationID, member.UserID, request.Role); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to update organization member")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member role is updated successfully"})
+}
+
+// RemoveOrganizationMemberHandler removes a member from the active organization, members can remove themselves
+func (h *Handler) RemoveOrganizationMemberHandler(c *gin.Context) {
+	organizationID := c.GetInt("organization_id")
+
+	member, ok := h.organizationMember(c, organizationID)
+	if !ok {
+		return
+	}
+
+	leaving := strconv.Itoa(member.UserID) == c.GetString("user_id")
+	if !leaving && !internal.CanAssignOrganizationRole(c.GetString("organization_role"), member.Role) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Your organization role does not allow this request")
+		return
+	}
+
+	if member.Role == models.OrganizationRoleOwner && !h.hasOtherOwner(c, organizationID) {
+		return
+	}
+
+	if err := h.db.WithContext(c).RemoveOrganizationMember(organizationID, member.UserID); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to remove organization member")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member is removed successfully"})
+}
+
+// CreateOrganizationInvitationHandler invites an email to the active organization
+func (h *Handler) CreateOrganizationInvitationHandler(c *gin.Context) {
+	organizationID := c.GetInt("organization_id")
+
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request InvitationInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+	request.Email = strings.ToLower(request.Email)
+
+	if !internal.CanAssignOrganizationRole(c.GetString("organization_role"), request.Role) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Only owners can invite owners")
+		return
+	}
+
+	organization, err := h.db.WithContext(c).GetOrganization(organizationID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "Organization not found")
+		return
+	}
+
+	inviter, err := h.db.WithContext(c).GetUserByID(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	// invitee may not have an account yet
+	locale := h.mailService.MatchLocale(inviter.Language)
+	if invitee, err := h.db.WithContext(c).GetUserByEmail(request.Email); err == nil {
+		if _, err := h.db.WithContext(c).GetOrganizationMember(organizationID, invitee.ID); err == nil {
+			abort(c, http.StatusConflict, internal.ErrCodeConflict, "User is already a member")
+			return
+		}
+		locale = h.mailService.MatchLocale(invitee.Language)
+	}
+
+	token, hash, err := internal.GenerateInvitationToken()
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to generate invitation token")
+		abortInternal(c)
+		return
+	}
+
+	content, err := h.mailService.InvitationMailContent(token, invitationExpiryDays, inviter.Username, organization.Name, request.Role, h.config.Current().Server.Host, locale)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to render invitation mail")
+		abortInternal(c)
+		return
+	}
+
+	invitation := models.OrganizationInvitation{
+		OrganizationID: organizationID,
+		Email:          request.Email,
+		Role:           request.Role,
+		Hash:           hash,
+		InvitedBy:      ID,
+		ExpiresAt:      time.Now().Add(invitationExpiryDays * 24 * time.Hour),
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		if err := tx.CreateOrganizationInvitation(&invitation); err != nil {
+			return err
+		}
+
+		return h.enqueueMail(tx, request.Email, content)
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to create invitation")
+		abortInternal(c)
+		return
+	}
+	h.outbox.Wake()
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListOrganizationInvitationsHandler lists pending invitations of the active organization
+func (h *Handler) ListOrganizationInvitationsHandler(c *gin.Context) {
+	invitations, err := h.db.WithContext(c).ListOrganizationInvitations(c.GetInt("organization_id"))
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list invitations")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// DeleteOrganizationInvitationHandler cancels a pending invitation of the active organization
+func (h *Handler) DeleteOrganizationInvitationHandler(c *gin.Context) {
+	invitationID, err := strconv.Atoi(c.Param("invitation_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid invitation ID")
+		return
+	}
+
+	err = h.db.WithContext(c).DeleteOrganizationInvitation(c.GetInt("organization_id"), invitationID)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "Invitation not found")
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to delete invitation")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation is deleted successfully"})
+}
+
+// AcceptOrganizationInvitationHandler adds the logged in user to the organization it is invited to
+func (h *Handler) AcceptOrganizationInvitationHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request AcceptInvitationInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	invitation, err := h.db.WithContext(c).GetOrganizationInvitationByHash(internal.HashInvitationToken(request.Token))
+	if err != nil || invitation.AcceptedAt != nil || invitation.ExpiresAt.Before(time.Now()) {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invitation is invalid or has expired")
+		return
+	}
+
+	user, err := h.db.WithContext(c).GetUserByID(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Invitation is sent to another email")
+		return
+	}
+
+	if _, err := h.db.WithContext(c).GetOrganizationMember(invitation.OrganizationID, user.ID); err == nil {
+		abort(c, http.StatusConflict, internal.ErrCodeConflict, "You are already a member")
+		return
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		if err := tx.AddOrganizationMember(&models.OrganizationMember{
+			OrganizationID: invitation.OrganizationID,
+			UserID:         user.ID,
+			Role:           invitation.Role,
+		}); err != nil {
+			return err
+		}
+
+		return tx.AcceptOrganizationInvitation(invitation.ID, time.Now())
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to accept invitation")
+		abortInternal(c)
+		return
+	}
+
+	organization, err := h.db.WithContext(c).GetOrganization(invitation.OrganizationID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "Organization not found")
+		return
+	}
+
+	organization.Role = invitation.Role
+	c.JSON(http.StatusOK, organization)
+}
+
+// ListOrganizationTransactionsHandler lists the ledger of the active organization
+func (h *Handler) ListOrganizationTransactionsHandler(c *gin.Context) {
+	transactions, err := h.db.WithContext(c).ListOrganizationTransactions(c.GetInt("organization_id"))
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list organization transactions")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// ListAllOrganizationsHandler lists all organizations
+func (h *Handler) ListAllOrganizationsHandler(c *gin.Context) {
+	organizations, err := h.db.WithContext(c).ListAllOrganizations()
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list organizations")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, organizations)
+}
+
+// CreditOrganizationHandler credits the balance of an organization
+func (h *Handler) CreditOrganizationHandler(c *gin.Context) {
+	organizationID, err := strconv.Atoi(c.Param("organization_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid organization ID")
+		return
+	}
+
+	var request CreditRequestInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortBinding(c, err)
+		return
+	}
+
+	organization, err := h.db.WithContext(c).GetOrganization(organizationID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "Organization not found")
+		return
+	}
+
+	transaction := models.Transaction{
+		OrganizationID: organization.ID,
+		AdminID:        c.GetInt("user_id"),
+		Amount:         request.Amount,
+		Memo:           request.Memo,
+		CreatedAt:      time.Now(),
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		if err := tx.CreateTransaction(&transaction); err != nil {
+			return err
+		}
+
+		return tx.CreditOrganizationBalance(organization.ID, request.Amount)
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("Failed to credit organization")
+		abortInternal(c)
+		return
+	}
+
+	err = h.webhooks.PublishToOrganization(organization.ID, models.EventBalanceCredited, gin.H{
+		"organization_id": organization.ID,
+		"amount":          request.Amount,
+		"memo":            request.Memo,
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("organization_id", organization.ID).Msg("failed to publish webhook event")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Organization credited successfully",
+		"organization": organization.Name,
+		"amount":       request.Amount,
+		"memo":         request.Memo,
+	})
+}
+
+// organizationMember returns the member of the user_id param in organization, responding if it is not found
+func (h *Handler) organizationMember(c *gin.Context, organizationID int) (models.OrganizationMember, bool) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return models.OrganizationMember{}, false
+	}
+
+	member, err := h.db.WithContext(c).GetOrganizationMember(organizationID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "Member not found")
+		return models.OrganizationMember{}, false
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to get organization member")
+		abortInternal(c)
+		return models.OrganizationMember{}, false
+	}
+
+	return member, true
+}
+
+// hasOtherOwner reports whether an owner can step down, responding if it is the last one
+func (h *Handler) hasOtherOwner(c *gin.Context, organizationID int) bool {
+	owners, err := h.db.WithContext(c).CountOrganizationOwners(organizationID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to count organization owners")
+		abortInternal(c)
+		return false
+	}
+
+	if owners < 2 {
+		abort(c, http.StatusConflict, internal.ErrCodeConflict, "Organization must keep at least one owner")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/middlewares"
+	"kubecloud/models"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// invitationToken matches tokens of invitation mails
+var invitationToken = regexp.MustCompile(`[A-Za-z0-9_-]{43}`)
+
+// serveOrganization sends a request like serve acting on organizationID
+func serveOrganization(app *App, method, path, token string, organizationID int, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(middlewares.OrganizationHeader, strconv.Itoa(organizationID))
+
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	return w
+}
+
+// newTestOrganization creates an organization owned by the user of token and adds members with their roles
+func newTestOrganization(t *testing.T, app *App, token string, members map[int]string) models.Organization {
+	t.Helper()
+
+	w := serve(app, http.MethodPost, "/api/v1/organizations", token, `{"name":"team"}`)
+	var organization models.Organization
+	if err := json.Unmarshal(w.Body.Bytes(), &organization); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("expected the organization to be created, got %d %s", w.Code, w.Body.String())
+	}
+
+	for userID, role := range members {
+		if err := app.handlers.db.AddOrganizationMember(&models.OrganizationMember{OrganizationID: organization.ID, UserID: userID, Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return organization
+}
+
+func TestOrganizationRoles(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, ownerToken := newTestUser(t, app, models.User{Username: "owner", Email: "owner@kubecloud.io"})
+	admin, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io"})
+	member, memberToken := newTestUser(t, app, models.User{Username: "member", Email: "member@kubecloud.io"})
+	billing, billingToken := newTestUser(t, app, models.User{Username: "billing", Email: "billing@kubecloud.io"})
+	_, outsiderToken := newTestUser(t, app, models.User{Username: "outsider", Email: "outsider@kubecloud.io"})
+
+	organization := newTestOrganization(t, app, ownerToken, map[int]string{
+		admin.ID:   models.OrganizationRoleAdmin,
+		member.ID:  models.OrganizationRoleMember,
+		billing.ID: models.OrganizationRoleBilling,
+	})
+
+	if w := serve(app, http.MethodGet, "/api/v1/organizations/current", memberToken, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a request without an active organization to be refused, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveOrganization(app, http.MethodGet, "/api/v1/organizations/current", outsiderToken, organization.ID, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a user outside the organization to be refused, got %d %s", w.Code, w.Body.String())
+	}
+
+	cases := []struct {
+		name, token, method, path, body string
+		code                            int
+	}{
+		{"member", memberToken, http.MethodGet, "/api/v1/organizations/current", "", http.StatusOK},
+		{"member", memberToken, http.MethodGet, "/api/v1/organizations/current/members", "", http.StatusOK},
+		{"member", memberToken, http.MethodPut, "/api/v1/organizations/current", `{"name":"renamed"}`, http.StatusForbidden},
+		{"member", memberToken, http.MethodGet, "/api/v1/organizations/current/invitations", "", http.StatusForbidden},
+		{"member", memberToken, http.MethodGet, "/api/v1/organizations/current/transactions", "", http.StatusForbidden},
+		{"billing", billingToken, http.MethodGet, "/api/v1/organizations/current/transactions", "", http.StatusOK},
+		{"billing", billingToken, http.MethodPut, "/api/v1/organizations/current", `{"name":"renamed"}`, http.StatusForbidden},
+		{"admin", adminToken, http.MethodPut, "/api/v1/organizations/current", `{"name":"renamed"}`, http.StatusOK},
+		{"admin", adminToken, http.MethodGet, "/api/v1/organizations/current/invitations", "", http.StatusOK},
+		{"admin", adminToken, http.MethodDelete, "/api/v1/organizations/current", "", http.StatusForbidden},
+		{"owner", ownerToken, http.MethodDelete, "/api/v1/organizations/current", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := serveOrganization(app, c.method, c.path, c.token, organization.ID, c.body); w.Code != c.code {
+			t.Errorf("expected %s %s by %s to return %d, got %d %s", c.method, c.path, c.name, c.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestOrganizationTokenRoles(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, ownerToken := newTestUser(t, app, models.User{Username: "owner", Email: "owner@kubecloud.io"})
+	member, _ := newTestUser(t, app, models.User{Username: "member", Email: "member@kubecloud.io"})
+	organization := newTestOrganization(t, app, ownerToken, map[int]string{member.ID: models.OrganizationRoleMember})
+
+	// the organization of a switched token applies without the header
+	tokens, err := app.handlers.tokenManager.CreateOrganizationTokenPair(member.ID, member.Username, false, organization.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(app, http.MethodGet, "/api/v1/organizations/current", tokens.AccessToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the organization of the token to be active, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodDelete, "/api/v1/organizations/current", tokens.AccessToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected the role in the organization of the token to apply, got %d %s", w.Code, w.Body.String())
+	}
+
+	// and stops applying once the user is removed
+	if err := app.handlers.db.RemoveOrganizationMember(organization.ID, member.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(app, http.MethodGet, "/api/v1/organizations/current", tokens.AccessToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a removed member to be refused, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAcceptOrganizationInvitation(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, ownerToken := newTestUser(t, app, models.User{Username: "owner", Email: "owner@kubecloud.io"})
+	invitee, inviteeToken := newTestUser(t, app, models.User{Username: "invitee", Email: "invitee@kubecloud.io"})
+	_, otherToken := newTestUser(t, app, models.User{Username: "other", Email: "other@kubecloud.io"})
+	organization := newTestOrganization(t, app, ownerToken, nil)
+
+	invite := `{"email":"Invitee@kubecloud.io","role":"billing"}`
+	if w := serveOrganization(app, http.MethodPost, "/api/v1/organizations/current/invitations", ownerToken, organization.ID, invite); w.Code != http.StatusCreated {
+		t.Fatalf("expected the invitation to be created, got %d %s", w.Code, w.Body.String())
+	}
+
+	mails, err := app.handlers.db.ListOutboxMails(models.OutboxPending, 0, 0)
+	if err != nil || len(mails) != 1 || mails[0].Receiver != invitee.Email {
+		t.Fatalf("expected the invitation mail to be queued, got %+v %v", mails, err)
+	}
+	token := invitationToken.FindString(mails[0].TextBody)
+	if token == "" {
+		t.Fatalf("expected the invitation mail to hold a token, got %q", mails[0].TextBody)
+	}
+	accept := `{"token":"` + token + `"}`
+
+	if w := serve(app, http.MethodPost, "/api/v1/organizations/invitations/accept", inviteeToken, `{"token":"unknown"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown token to be refused, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodPost, "/api/v1/organizations/invitations/accept", otherToken, accept); w.Code != http.StatusForbidden {
+		t.Fatalf("expected an invitation for another email to be refused, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := serve(app, http.MethodPost, "/api/v1/organizations/invitations/accept", inviteeToken, accept)
+	var joined models.Organization
+	if err := json.Unmarshal(w.Body.Bytes(), &joined); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected the invitation to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+	if joined.ID != organization.ID || joined.Role != models.OrganizationRoleBilling {
+		t.Fatalf("expected to join with the invited role, got %+v", joined)
+	}
+	if member, err := app.handlers.db.GetOrganizationMember(organization.ID, invitee.ID); err != nil || member.Role != models.OrganizationRoleBilling {
+		t.Fatalf("expected the invitee to be a billing member, got %+v %v", member, err)
+	}
+
+	if w := serve(app, http.MethodPost, "/api/v1/organizations/invitations/accept", inviteeToken, accept); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an accepted invitation not to be accepted again, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveOrganization(app, http.MethodPost, "/api/v1/organizations/current/invitations", ownerToken, organization.ID, invite); w.Code != http.StatusConflict {
+		t.Fatalf("expected members not to be invited again, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOrganizationTransactions(t *testing.T) {
+	app := newTestApp(t, nil)
+	owner, ownerToken := newTestUser(t, app, models.User{Username: "owner", Email: "owner@kubecloud.io"})
+	member, memberToken := newTestUser(t, app, models.User{Username: "member", Email: "member@kubecloud.io"})
+	organization := newTestOrganization(t, app, ownerToken, map[int]string{member.ID: models.OrganizationRoleMember})
+
+	for _, transaction := range []models.Transaction{
+		{UserID: owner.ID, Amount: 10, Memo: "personal"},
+		{UserID: owner.ID, OrganizationID: organization.ID, Amount: 20, Memo: "organization"},
+	} {
+		if err := app.handlers.db.CreateTransaction(&transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed := func(w *httptest.ResponseRecorder) string {
+		var transactions []models.Transaction
+		if err := json.Unmarshal(w.Body.Bytes(), &transactions); err != nil || w.Code != http.StatusOK || len(transactions) != 1 {
+			t.Fatalf("expected a transaction, got %d %s", w.Code, w.Body.String())
+		}
+		return transactions[0].Memo
+	}
+
+	if memo := listed(serve(app, http.MethodGet, "/api/v1/user/transactions", ownerToken, "")); memo != "personal" {
+		t.Fatalf("expected the personal ledger without an active organization, got %s", memo)
+	}
+	if memo := listed(serveOrganization(app, http.MethodGet, "/api/v1/user/transactions", ownerToken, organization.ID, "")); memo != "organization" {
+		t.Fatalf("expected the ledger of the active organization, got %s", memo)
+	}
+	if w := serveOrganization(app, http.MethodGet, "/api/v1/user/transactions", memberToken, organization.ID, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected members not to see the organization ledger, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOrganizationWebhooks(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	_, ownerToken := newTestUser(t, app, models.User{Username: "owner", Email: "owner@kubecloud.io"})
+	member, memberToken := newTestUser(t, app, models.User{Username: "member", Email: "member@kubecloud.io"})
+	organization := newTestOrganization(t, app, ownerToken, map[int]string{member.ID: models.OrganizationRoleMember})
+
+	create := `{"url":"https://hooks.kubecloud.io","events":["*"]}`
+	created := func(w *httptest.ResponseRecorder) models.Webhook {
+		var body struct {
+			Webhook models.Webhook `json:"webhook"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("expected the webhook to be created, got %d %s", w.Code, w.Body.String())
+		}
+		return body.Webhook
+	}
+	personal := created(serve(app, http.MethodPost, "/api/v1/user/webhooks", ownerToken, create))
+	shared := created(serveOrganization(app, http.MethodPost, "/api/v1/user/webhooks", ownerToken, organization.ID, create))
+	if personal.OrganizationID != 0 || shared.OrganizationID != organization.ID {
+		t.Fatalf("expected the webhook to belong to the active organization, got %+v and %+v", personal, shared)
+	}
+
+	if w := serveOrganization(app, http.MethodPost, "/api/v1/user/webhooks", memberToken, organization.ID, create); w.Code != http.StatusForbidden {
+		t.Fatalf("expected members not to register organization webhooks, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serveOrganization(app, http.MethodPost, "/api/v1/user/webhooks/"+strconv.Itoa(personal.ID)+"/ping", ownerToken, organization.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected personal webhooks to be hidden in the organization, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodDelete, "/api/v1/user/webhooks/"+strconv.Itoa(shared.ID), ownerToken, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected organization webhooks to be hidden in the personal account, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := serveOrganization(app, http.MethodGet, "/api/v1/user/webhooks", ownerToken, organization.ID, "")
+	var webhooks []models.Webhook
+	if err := json.Unmarshal(w.Body.Bytes(), &webhooks); err != nil || len(webhooks) != 1 || webhooks[0].ID != shared.ID {
+		t.Fatalf("expected the webhooks of the organization, got %d %s", w.Code, w.Body.String())
+	}
+
+	// credits of the organization go to its webhooks only
+	if w := serve(app, http.MethodPost, "/api/v1/admin/organizations/"+strconv.Itoa(organization.ID)+"/credit", adminToken, `{"amount":10,"memo":"credit"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the organization to be credited, got %d %s", w.Code, w.Body.String())
+	}
+	for webhook, count := range map[int]int{shared.ID: 1, personal.ID: 0} {
+		deliveries, err := app.handlers.db.ListWebhookDeliveries(webhook, -1)
+		if err != nil || len(deliveries) != count {
+			t.Fatalf("expected %d deliveries to webhook %d, got %+v %v", count, webhook, deliveries, err)
+		}
+	}
+
+	if w := serveOrganization(app, http.MethodDelete, "/api/v1/user/webhooks/"+strconv.Itoa(shared.ID), ownerToken, organization.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the organization webhook to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// OutboxFilterInput holds query filters when listing outbox mails
+type OutboxFilterInput struct {
+	Status string `form:"status" binding:"omitempty,oneof=pending sent dead"`
+	Limit  int    `form:"limit" binding:"omitempty,gt=0,max=1000"`
+	Offset int    `form:"offset" binding:"omitempty,gte=0"`
+}
+
+// ListOutboxMailsHandler lists queued mails newest first, optionally filtered by status
+func (h *Handler) ListOutboxMailsHandler(c *gin.Context) {
+	var request OutboxFilterInput
+	if err := c.ShouldBindQuery(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	if request.Limit == 0 {
+		request.Limit = 100
+	}
+
+	mails, err := h.db.WithContext(c).ListOutboxMails(request.Status, request.Limit, request.Offset)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list outbox mails")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, mails)
+}
+
+// RetryOutboxMailHandler requeues a dead mail for delivery
+func (h *Handler) RetryOutboxMailHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.Param("mail_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid mail ID")
+		return
+	}
+
+	mail, err := h.db.WithContext(c).GetOutboxMail(ID)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "mail not found")
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
+		return
+	}
+
+	if mail.Status != models.OutboxDead {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "only dead mails can be retried")
+		return
+	}
+
+	mail.Status = models.OutboxPending
+	mail.Attempts = 0
+	mail.NextAttemptAt = time.Now()
+
+	if err := h.db.WithContext(c).UpdateOutboxMail(&mail); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
+		return
+	}
+	h.outbox.Wake()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Mail is queued for delivery"})
+}
+
+// enqueueMail queues a mail in the outbox within the transaction of the change triggering it
+func (h *Handler) enqueueMail(tx models.DB, receiver string, content internal.MailContent) error {
+	mail, err := internal.NewOutboxMail(h.config.Current().MailSender.Email, receiver, content)
+	if err != nil {
+		return err
+	}
+
+	return tx.EnqueueMail(&mail)
+}
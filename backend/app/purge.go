@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultDeletedUsersRetentionDays = 30
+	defaultSentMailsRetentionDays    = 7
+	defaultPurgeInterval             = time.Hour
+	// rateLimitBucketRetention drops buckets idle long enough to be full again, missing buckets are full
+	rateLimitBucketRetention = 24 * time.Hour
+)
+
+// runPurgeJob periodically anonymizes users deleted longer than the retention period, deletes mails sent
+// longer than theirs and drops rate limit buckets and revoked sessions that are no longer used
+func (app *App) runPurgeJob(ctx context.Context) {
+	retentionDays := app.config.Retention.DeletedUsersDays
+	if retentionDays == 0 {
+		retentionDays = defaultDeletedUsersRetentionDays
+	}
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+
+	mailRetentionDays := app.config.Retention.SentMailsDays
+	if mailRetentionDays == 0 {
+		mailRetentionDays = defaultSentMailsRetentionDays
+	}
+	mailRetention := time.Duration(mailRetentionDays) * 24 * time.Hour
+
+	interval := time.Duration(app.config.Retention.PurgeIntervalMinutes) * time.Minute
+	if interval == 0 {
+		interval = defaultPurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.handlers.db.AnonymizeDeletedUsers(time.Now().Add(-retention))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to anonymize deleted users")
+		} else if purged > 0 {
+			log.Info().Int64("users", purged).Msg("anonymized deleted users after retention period")
+		}
+
+		if deleted, err := app.handlers.db.DeleteSentMailsBefore(time.Now().Add(-mailRetention)); err != nil {
+			log.Error().Err(err).Msg("failed to delete sent mails")
+		} else if deleted > 0 {
+			log.Info().Int64("mails", deleted).Msg("deleted sent mails after retention period")
+		}
+
+		if _, err := app.handlers.db.DeleteRateLimitBucketsBefore(time.Now().Add(-rateLimitBucketRetention)); err != nil {
+			log.Error().Err(err).Msg("failed to delete rate limit buckets")
+		}
+
+		if _, err := app.handlers.db.DeleteExpiredRevokedSessions(time.Now()); err != nil {
+			log.Error().Err(err).Msg("failed to delete expired revoked sessions")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// QuotaResponse holds effective limits of a user
+type QuotaResponse struct {
+	UserID     int              `json:"user_id"`
+	Limits     models.Resources `json:"limits"`
+	Overridden bool             `json:"overridden"`
+}
+
+// GetQuotaHandler returns quota limits of the logged in user
+func (h *Handler) GetQuotaHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	h.respondWithQuota(c, ID)
+}
+
+// GetUserQuotaHandler returns quota limits of a user
+func (h *Handler) GetUserQuotaHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	h.respondWithQuota(c, ID)
+}
+
+// SetUserQuotaHandler overrides quota limits of a user
+func (h *Handler) SetUserQuotaHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request models.Resources
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	if _, err := h.db.WithContext(c).GetUserByID(ID); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	quota := models.Quota{
+		UserID:    ID,
+		Resources: request,
+		UpdatedAt: time.Now(),
+	}
+
+	if err := h.db.WithContext(c).UpsertUserQuota(&quota); err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", ID).Msg("failed to set user quota")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, QuotaResponse{UserID: ID, Limits: quota.Resources, Overridden: true})
+}
+
+// DeleteUserQuotaHandler resets quota limits of a user to the defaults of its role
+func (h *Handler) DeleteUserQuotaHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	if _, err := h.db.WithContext(c).GetUserByID(ID); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	if err := h.db.WithContext(c).DeleteUserQuota(ID); err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", ID).Msg("failed to delete user quota")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User quota is reset to defaults"})
+}
+
+// respondWithQuota writes effective quota limits of a user
+func (h *Handler) respondWithQuota(c *gin.Context, userID int) {
+	user, err := h.db.WithContext(c).GetUserByID(userID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "User not found")
+		return
+	}
+
+	limits, overridden, err := h.userQuota(c, user)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", userID).Msg("failed to get user quota")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, QuotaResponse{UserID: user.ID, Limits: limits, Overridden: overridden})
+}
+
+// userQuota returns limits overridden for user, or the defaults of its role
+func (h *Handler) userQuota(ctx context.Context, user models.User) (models.Resources, bool, error) {
+	quota, err := h.db.WithContext(ctx).GetUserQuota(user.ID)
+	if err == nil {
+		return quota.Resources, true, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Resources{}, false, err
+	}
+
+	if user.Admin {
+		return h.config.Current().Quotas.Admin, false, nil
+	}
+	return h.config.Current().Quotas.User, false, nil
+}
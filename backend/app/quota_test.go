@@ -0,0 +1,56 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestUserQuota(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Quotas.User = models.Resources{Clusters: 1}
+	})
+	_, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	user, userToken := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	quota := func(token string) QuotaResponse {
+		t.Helper()
+		w := serve(app, http.MethodGet, "/api/v1/user/quota", token, "")
+		var response QuotaResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("expected quota, got %d %s", w.Code, w.Body.String())
+		}
+		return response
+	}
+
+	if response := quota(userToken); response.Overridden || response.Limits.Clusters != 1 {
+		t.Fatalf("expected role defaults, got %+v", response)
+	}
+
+	path := "/api/v1/user/" + strconv.Itoa(user.ID) + "/quota"
+	if w := serve(app, http.MethodPut, path, userToken, `{"clusters":5}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected users not to set quotas, got %d", w.Code)
+	}
+	if w := serve(app, http.MethodPut, path, adminToken, `{"clusters":5}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the override to be set, got %d %s", w.Code, w.Body.String())
+	}
+	if response := quota(userToken); !response.Overridden || response.Limits.Clusters != 5 {
+		t.Fatalf("expected the override, got %+v", response)
+	}
+
+	if w := serve(app, http.MethodDelete, path, adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the override to be reset, got %d %s", w.Code, w.Body.String())
+	}
+	if response := quota(userToken); response.Overridden {
+		t.Fatalf("expected role defaults after reset, got %+v", response)
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if w := serve(app, method, "/api/v1/user/999/quota", adminToken, `{"clusters":5}`); w.Code != http.StatusNotFound {
+			t.Fatalf("expected %s of an unknown user to fail with 404, got %d %s", method, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// serveFrom sends a login request from a client at remoteAddr claiming to forward for forwardedFor
+func serveFrom(app *App, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", strings.NewReader(`{"email":"user@kubecloud.io","password":"wrong"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	req.RemoteAddr = remoteAddr
+
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.RateLimit.Policies = map[string]internal.RateLimitPolicy{
+			internal.RateLimitPolicyAuth: {Requests: 2, PeriodSeconds: 60},
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		w := serveFrom(app, "203.0.113.1:1234", "")
+		if w.Code == http.StatusTooManyRequests || w.Header().Get("RateLimit-Limit") != "2" {
+			t.Fatalf("expected request %d to be allowed with rate limit headers, got %d %v", i, w.Code, w.Header())
+		}
+	}
+
+	w := serveFrom(app, "203.0.113.1:1234", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected the third request to be limited, got %d %v", w.Code, w.Header())
+	}
+	if w := serveFrom(app, "203.0.113.2:1234", ""); w.Code == http.StatusTooManyRequests {
+		t.Fatal("expected other clients to have their own limit")
+	}
+}
+
+func TestSpoofedForwardedFor(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.RateLimit.Policies = map[string]internal.RateLimitPolicy{
+			internal.RateLimitPolicyAuth: {Requests: 1, PeriodSeconds: 60},
+		}
+	})
+
+	// a client picking another forwarded address per request still has one limit
+	if w := serveFrom(app, "203.0.113.1:1234", "198.51.100.1"); w.Code == http.StatusTooManyRequests {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if w := serveFrom(app, "203.0.113.1:1234", "198.51.100.2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the forwarded address of an untrusted client to be ignored, got %d", w.Code)
+	}
+
+	logs, err := app.handlers.db.ListAuditLogs(models.AuditFilter{})
+	if err != nil || len(logs) != 1 || logs[0].SourceIP != "203.0.113.1" {
+		t.Fatalf("expected the audit log to hold the address of the client, got %+v %v", logs, err)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Server.TrustedProxies = []string{"10.0.0.0/8"}
+		config.RateLimit.Policies = map[string]internal.RateLimitPolicy{
+			internal.RateLimitPolicyAuth: {Requests: 1, PeriodSeconds: 60},
+		}
+	})
+
+	// clients behind a trusted proxy are told apart by the address it forwards
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		if w := serveFrom(app, "10.0.0.1:1234", client); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("expected %s forwarded by a trusted proxy to have its own limit", client)
+		}
+	}
+	if w := serveFrom(app, "10.0.0.1:1234", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the forwarded client to be limited, got %d", w.Code)
+	}
+
+	if _, err := NewApp(internal.Configuration{Server: internal.Server{TrustedProxies: []string{"not a proxy"}}}); err == nil || !strings.Contains(err.Error(), "trusted proxies") {
+		t.Fatal("expected an invalid trusted proxy to be refused")
+	}
+}
+
+func TestTargetEmailRateLimit(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.RateLimit.Policies = map[string]internal.RateLimitPolicy{
+			internal.RateLimitPolicyMail:       {Requests: 100, PeriodSeconds: 60},
+			internal.RateLimitPolicyMailTarget: {Requests: 2, PeriodSeconds: 3600},
+		}
+	})
+
+	forgotPassword := func(remoteAddr, email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/user/forgot_password", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// every request comes from another client, as a flood spread over many addresses would
+	for i := 0; i < 2; i++ {
+		if w := forgotPassword(fmt.Sprintf("203.0.113.%d:1234", i), "user@kubecloud.io"); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("expected request %d to be allowed, got %d", i, w.Code)
+		}
+	}
+	if w := forgotPassword("203.0.113.10:1234", "USER@kubecloud.io"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected mails to the same address to be limited, got %d %s", w.Code, w.Body.String())
+	}
+	if w := forgotPassword("203.0.113.10:1234", "other@kubecloud.io"); w.Code == http.StatusTooManyRequests {
+		t.Fatal("expected other addresses to have their own limit")
+	}
+}
+
+func TestRateLimitStoreErrors(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.RateLimit.Store = "database"
+	})
+	failures := testutil.ToFloat64(internal.RateLimitStoreErrors.WithLabelValues(internal.RateLimitPolicyAuth))
+
+	if err := app.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveFrom(app, "203.0.113.1:1234", ""); w.Code == http.StatusTooManyRequests {
+		t.Fatalf("expected the request to be let through, got %d", w.Code)
+	}
+	if counted := testutil.ToFloat64(internal.RateLimitStoreErrors.WithLabelValues(internal.RateLimitPolicyAuth)); counted != failures+1 {
+		t.Fatalf("expected the store failure to be counted, got %v after %v", counted, failures)
+	}
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/middlewares"
+	"kubecloud/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// SessionQuery selects how tokens are returned
+type SessionQuery struct {
+	Session string `form:"session"` // cookie keeps tokens in HttpOnly cookies if the cookie session mode is enabled
+}
+
+// loggedIn reports whether the request is made by a user who logged in rather than with a personal access token
+func loggedIn(c *gin.Context) bool {
+	method := c.GetString("auth_method")
+	return method == middlewares.AuthMethodJWT || method == middlewares.AuthMethodSession
+}
+
+// cookieSession reports whether tokens are kept in cookies, asked with ?session=cookie or implied by a request
+// made with a cookie session
+func (h *Handler) cookieSession(c *gin.Context) bool {
+	if h.sessions == nil {
+		return false
+	}
+	return c.Query("session") == "cookie" || c.GetString("auth_method") == middlewares.AuthMethodSession
+}
+
+// respondTokens returns tokenPair, cookie sessions get it in cookies and only the CSRF token in the body
+func (h *Handler) respondTokens(c *gin.Context, tokenPair *internal.TokenPair) {
+	if !h.cookieSession(c) {
+		c.JSON(http.StatusCreated, tokenPair)
+		return
+	}
+
+	c.JSON(http.StatusCreated, SessionResponse{CSRFToken: h.sessions.Start(c.Writer, tokenPair)})
+}
+
+// LogoutInput holds the refresh token to revoke on logout, cookie sessions and bearer access tokens don't need it
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutHandler ends the session of the user: its refresh token is revoked and its cookies are cleared.
+// Access tokens of the session stay valid until they expire.
+func (h *Handler) LogoutHandler(c *gin.Context) {
+	var request LogoutInput
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			abortBinding(c, err)
+			return
+		}
+	}
+
+	var claims *internal.TokenClaims
+	if request.RefreshToken != "" {
+		claims, _ = h.tokenManager.VerifyToken(request.RefreshToken, internal.TokenTypeRefresh)
+	} else if accessToken := h.logoutAccessToken(c); accessToken != "" {
+		claims, _ = h.tokenManager.VerifyToken(accessToken, internal.TokenTypeAccess)
+	}
+
+	// refresh tokens of the session are revoked until the longest they could live
+	if claims != nil && claims.SessionID != "" {
+		revoked := models.RevokedSession{
+			SessionID: claims.SessionID,
+			ExpiresAt: time.Now().Add(time.Duration(h.config.Current().JWT.RefreshTokenExpiryHours) * time.Hour),
+		}
+		if err := h.db.WithContext(c).RevokeSession(&revoked); err != nil {
+			log.Ctx(c).Error().Err(err).Msg("failed to revoke session")
+			abortInternal(c)
+			return
+		}
+	}
+
+	if h.sessions != nil {
+		h.sessions.End(c.Writer)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// logoutAccessToken returns the bearer access token of the request, or else the access token of its cookie session
+func (h *Handler) logoutAccessToken(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if h.sessions != nil {
+		return h.sessions.AccessToken(c.Request)
+	}
+	return ""
+}
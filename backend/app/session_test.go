@@ -0,0 +1,157 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCookieSession(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Session = internal.Session{Enabled: true, SameSite: "lax"}
+	})
+
+	password, err := internal.HashAndSaltPassword([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.handlers.db.RegisterUser(&models.User{Username: "user", Email: "user@kubecloud.io", Password: password, Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := map[string]*http.Cookie{}
+	do := func(method, path, body, csrfToken string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if csrfToken != "" {
+			req.Header.Set(internal.CSRFHeader, csrfToken)
+		}
+		for _, cookie := range cookies {
+			if strings.HasPrefix(req.URL.Path, cookie.Path) {
+				req.AddCookie(cookie)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		app.router.ServeHTTP(w, req)
+		for _, cookie := range w.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/v1/user/login?session=cookie", `{"email":"user@kubecloud.io","password":"password"}`, "")
+	var session SessionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil || w.Code != http.StatusCreated || session.CSRFToken == "" {
+		t.Fatalf("expected a cookie session, got %d %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "access_token") || !cookies[internal.SessionAccessCookie].HttpOnly || cookies[internal.CSRFCookie].Value != session.CSRFToken {
+		t.Fatalf("expected tokens only in HttpOnly cookies, got %s %v", w.Body.String(), cookies)
+	}
+
+	if w := do(http.MethodGet, "/api/v1/user/quota", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the session to authenticate, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/api/v1/user/language", `{"language":"en"}`, ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), string(internal.ErrCodeCSRFFailed)) {
+		t.Fatalf("expected changes without the CSRF token to be refused, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/api/v1/user/language", `{"language":"en"}`, session.CSRFToken); w.Code != http.StatusOK {
+		t.Fatalf("expected changes with the CSRF token to pass, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do(http.MethodPost, "/api/v1/user/refresh", "", session.CSRFToken); w.Code != http.StatusOK {
+		t.Fatalf("expected the refresh cookie to refresh the session, got %d %s", w.Code, w.Body.String())
+	}
+
+	// a CSRF cookie planted by another site doesn't match the session
+	cookies[internal.CSRFCookie] = &http.Cookie{Name: internal.CSRFCookie, Value: "planted", Path: "/"}
+	if w := do(http.MethodPut, "/api/v1/user/language", `{"language":"en"}`, "planted"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a planted CSRF token to be refused, got %d %s", w.Code, w.Body.String())
+	}
+
+	refresh := cookies[internal.SessionRefreshCookie]
+	do(http.MethodPost, "/api/v1/user/logout", "", "")
+	if w := do(http.MethodGet, "/api/v1/user/quota", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected logout to end the session, got %d %s", w.Code, w.Body.String())
+	}
+
+	// a refresh token kept from before logout is revoked
+	cookies[internal.SessionRefreshCookie] = refresh
+	if w := do(http.MethodPost, "/api/v1/user/refresh", "", session.CSRFToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh token to be revoked on logout, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	app := newTestApp(t, nil)
+	user, _ := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	tokens, err := app.handlers.tokenManager.CreateTokenPair(user.ID, user.Username, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := `{"refresh_token":"` + tokens.RefreshToken + `"}`
+
+	// tokens of one type are not accepted as the other
+	if w := serve(app, http.MethodGet, "/api/v1/user/quota", tokens.RefreshToken, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a refresh token not to authenticate requests, got %d", w.Code)
+	}
+	if w := serve(app, http.MethodPost, "/api/v1/user/refresh", "", `{"refresh_token":"`+tokens.AccessToken+`"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an access token not to be refreshed, got %d", w.Code)
+	}
+
+	if w := serve(app, http.MethodPost, "/api/v1/user/logout", "", refresh); w.Code != http.StatusNoContent {
+		t.Fatalf("expected logout to succeed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodPost, "/api/v1/user/refresh", "", refresh); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh token to be revoked on logout, got %d %s", w.Code, w.Body.String())
+	}
+
+	// logging out with the access token revokes the refresh token of its login too
+	tokens, _ = app.handlers.tokenManager.CreateTokenPair(user.ID, user.Username, false)
+	if w := serve(app, http.MethodPost, "/api/v1/user/logout", tokens.AccessToken, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected logout to succeed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodPost, "/api/v1/user/refresh", "", `{"refresh_token":"`+tokens.RefreshToken+`"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh token to be revoked on logout, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.CORS = internal.DefaultConfiguration().CORS
+		config.CORS.AllowedOrigins = []string{"https://app.kubecloud.io"}
+		config.CORS.AllowCredentials = true
+	})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/user/login", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		w := httptest.NewRecorder()
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("https://app.kubecloud.io")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.kubecloud.io" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("expected the preflight to be allowed, got %d %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), internal.CSRFHeader) {
+		t.Fatalf("expected the CSRF header to be allowed, got %s", w.Header().Get("Access-Control-Allow-Headers"))
+	}
+
+	if w := preflight("https://evil.example"); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected other origins to be refused, got %d %v", w.Code, w.Header())
+	}
+	w = httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("expected responses without an origin to vary by origin, got %v", w.Header())
+	}
+}
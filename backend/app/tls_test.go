@@ -0,0 +1,39 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.SecurityHeaders = internal.DefaultConfiguration().SecurityHeaders
+	})
+
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("X-Frame-Options") != "DENY" || w.Header().Get("Content-Security-Policy") == "" {
+		t.Fatalf("expected security headers, got %v", w.Header())
+	}
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Fatalf("expected no HSTS over plain HTTP, got %s", hsts)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w = httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000" {
+		t.Fatalf("expected HSTS behind a TLS proxy, got %q", hsts)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	w := httptest.NewRecorder()
+	redirectToHTTPS("8443").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://kubecloud.io:8080/api/v1/user?x=1", nil))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://kubecloud.io:8443/api/v1/user?x=1" {
+		t.Fatalf("expected a redirect to HTTPS, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"kubecloud/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+// recordSpans installs a tracer provider recording every span until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return recorder
+}
+
+// captureLogs sends the lines of the global logger to the returned buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	previous := log.Logger
+	log.Logger = zerolog.New(&buffer)
+	t.Cleanup(func() { log.Logger = previous })
+	return &buffer
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApp(t, nil)
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		name      string
+		requestID string
+		kept      bool
+	}{
+		{"valid", "client-id.1_a", true},
+		{"missing", "", false},
+		{"invalid characters", "id with spaces", false},
+		{"header injection", "id\r\nX-Injected: 1", false},
+		{"too long", strings.Repeat("a", 65), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/nothing", nil)
+			if test.requestID != "" {
+				req.Header[http.CanonicalHeaderKey(middlewares.RequestIDHeader)] = []string{test.requestID}
+			}
+			w := httptest.NewRecorder()
+			app.router.ServeHTTP(w, req)
+
+			requestID := w.Header().Get(middlewares.RequestIDHeader)
+			if test.kept && requestID != test.requestID {
+				t.Fatalf("expected request ID %q to be kept, got %q", test.requestID, requestID)
+			}
+			if !test.kept && !generated.MatchString(requestID) {
+				t.Fatalf("expected a generated request ID, got %q", requestID)
+			}
+
+			var body ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.RequestID != requestID {
+				t.Fatalf("expected request ID %q in the error body, got %q", requestID, body.Error.RequestID)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	app := newTestApp(t, nil)
+	logs := captureLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/user/quota", nil)
+	req.Header.Set(middlewares.RequestIDHeader, "access-log")
+	app.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line["message"] == "request" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) != 1 {
+		t.Fatalf("expected a single access log line, got %d: %s", len(lines), logs.String())
+	}
+	line := lines[0]
+	if line["request_id"] != "access-log" || line["status"] != float64(http.StatusUnauthorized) || line["level"] != "warn" {
+		t.Fatalf("expected a warning with the request ID and status, got %v", line)
+	}
+	if line["route"] != "/api/v1/user/quota" || line["method"] != http.MethodGet {
+		t.Fatalf("expected the route and method, got %v", line)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := recordSpans(t)
+	app := newTestApp(t, nil)
+	logs := captureLogs(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login", strings.NewReader(`{"email":"nobody@kubecloud.io","password":"password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(middlewares.RequestIDHeader, "traced")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d %s", w.Code, w.Body.String())
+	}
+
+	var server sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "POST /api/v1/user/login" {
+			server = span
+		}
+	}
+	if server == nil {
+		t.Fatal("expected a span around the handler")
+	}
+	if server.SpanContext().TraceID().String() != traceID || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected the span to continue the propagated trace, got trace %s parent %s", server.SpanContext().TraceID(), server.Parent().SpanID())
+	}
+
+	attributes := map[string]any{}
+	for _, attribute := range server.Attributes() {
+		attributes[string(attribute.Key)] = attribute.Value.AsInterface()
+	}
+	if attributes["request.id"] != "traced" || attributes["http.response.status_code"] != int64(http.StatusUnauthorized) || attributes["http.route"] != "/api/v1/user/login" {
+		t.Fatalf("expected the request ID, route and status on the span, got %v", attributes)
+	}
+
+	queries := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() != "db.query" || span.Parent().SpanID() != server.SpanContext().SpanID() {
+			continue
+		}
+		queries++
+		if span.Status().Code != codes.Unset {
+			t.Fatalf("expected a missing record not to fail the query span, got %v", span.Status())
+		}
+	}
+	if queries == 0 {
+		t.Fatal("expected spans of the database queries of the handler")
+	}
+
+	if !strings.Contains(logs.String(), `"trace_id":"`+traceID+`"`) {
+		t.Fatalf("expected the trace ID in the request logs, got %s", logs.String())
+	}
+}
@@ -1,9 +1,15 @@
 package app
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"kubecloud/internal"
 	"kubecloud/models"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,17 +21,33 @@ import (
 type Handler struct {
 	tokenManager internal.TokenManager
 	db           models.DB
-	config       internal.Configuration
+	config       *internal.ConfigStore // reloadable keys may change between calls of Current
 	mailService  internal.MailService
+	outbox       *internal.OutboxSender
+	notifier     *internal.Notifier
+	webhooks     *internal.WebhookDispatcher
+	apiTokens    *internal.APITokens
+	oidc         *internal.OIDCProvider
+	challenges   internal.ChallengeVerifier
+	sessions     *internal.Sessions // nil if the cookie session mode is disabled
+	auditor      *internal.Auditor
 }
 
 // NewHandler create new handler
-func NewHandler(tokenManager internal.TokenManager, db models.DB, config internal.Configuration, mailService internal.MailService) *Handler {
+func NewHandler(tokenManager internal.TokenManager, db models.DB, config *internal.ConfigStore, mailService internal.MailService, outbox *internal.OutboxSender, notifier *internal.Notifier, webhooks *internal.WebhookDispatcher, apiTokens *internal.APITokens, oidc *internal.OIDCProvider, challenges internal.ChallengeVerifier, sessions *internal.Sessions, auditor *internal.Auditor) *Handler {
 	return &Handler{
 		tokenManager: tokenManager,
 		db:           db,
 		config:       config,
 		mailService:  mailService,
+		outbox:       outbox,
+		notifier:     notifier,
+		webhooks:     webhooks,
+		apiTokens:    apiTokens,
+		oidc:         oidc,
+		challenges:   challenges,
+		sessions:     sessions,
+		auditor:      auditor,
 	}
 }
 
@@ -35,6 +57,7 @@ type RegisterInput struct {
 	Email           string `json:"email" binding:"required,email"`
 	Password        string `json:"password" binding:"required,min=8,max=64"`
 	ConfirmPassword string `json:"confirm_password" binding:"required,eqfield=Password"`
+	Language        string `json:"language" binding:"omitempty,bcp47_language_tag"`
 }
 
 // LoginInput struct for login handler
@@ -61,58 +84,62 @@ type VerifyCodeInput struct {
 
 // ChangePasswordInput struct for user to change password
 type ChangePasswordInput struct {
-	Email           string `json:"email" binding:"required,email"`
 	Password        string `json:"password" binding:"required,min=8,max=64"`
 	ConfirmPassword string `json:"confirm_password" binding:"required,eqfield=Password"`
 }
 
+// LanguageInput struct for user to change preferred language
+type LanguageInput struct {
+	Language string `json:"language" binding:"required,bcp47_language_tag"`
+}
+
 // RegisterHandler registers user to the system
 func (h *Handler) RegisterHandler(c *gin.Context) {
 	var request RegisterInput
 
 	// check on request format
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
 	// password and confirm password should match
 	if request.Password != request.ConfirmPassword {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "password and confirm password don't match"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "password and confirm password don't match")
 		return
 	}
 
 	// check if user previously exists
-	existingUser, getErr := h.db.GetUserByEmail(request.Email)
+	existingUser, getErr := h.db.WithContext(c).GetUserByEmail(request.Email)
 	if getErr != gorm.ErrRecordNotFound {
 		if existingUser.Verified {
-			c.JSON(http.StatusConflict, gin.H{"error": "user already registered"})
+			abort(c, http.StatusConflict, internal.ErrCodeUserExists, "user already registered")
 			return
 		}
 
 	}
 
 	code := internal.GenerateRandomCode()
-	log.Debug().Int("generated_code", code).Send()
-	subject, body := h.mailService.SignUpMailContent(code, h.config.MailSender.Timeout, request.Name, h.config.Server.Host)
+	log.Ctx(c).Debug().Int("generated_code", code).Send()
+	locale := h.mailService.MatchLocale(request.Language, c.GetHeader("Accept-Language"))
 
-	err := h.mailService.SendMail(h.config.MailSender.Email, request.Email, subject, body)
+	content, err := h.mailService.SignUpMailContent(code, h.config.Current().MailSender.Timeout, request.Name, h.config.Current().Server.Host, locale)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to send verification code")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("failed to render verification mail")
+		abortInternal(c)
 		return
 	}
 
 	// hash password
 	hashedPassword, err := internal.HashAndSaltPassword([]byte(request.Password))
 	if err != nil {
-		log.Error().Err(err).Msg("error hashing password")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("error hashing password")
+		abortInternal(c)
 		return
 	}
 
-	isAdmin := internal.Contains(h.config.Admins, request.Email)
+	isAdmin := internal.Contains(h.config.Current().Admins, request.Email)
 
 	user := models.User{
 		Username: request.Name,
@@ -120,35 +147,40 @@ func (h *Handler) RegisterHandler(c *gin.Context) {
 		Password: hashedPassword,
 		Admin:    isAdmin,
 		Code:     code,
-	}
-
-	// If user exists but not verified
-	if getErr != gorm.ErrRecordNotFound {
-		if !existingUser.Verified {
-			user.ID = existingUser.ID
-			user.UpdatedAt = time.Now()
-			err = h.db.UpdateUserByID(&user)
-			if err != nil {
-				log.Error().Err(err).Send()
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-				return
+		Language: locale,
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		// If user exists but not verified
+		if getErr != gorm.ErrRecordNotFound {
+			if !existingUser.Verified {
+				user.ID = existingUser.ID
+				user.UpdatedAt = time.Now()
+				if err := tx.UpdateUserByID(&user); err != nil {
+					return err
+				}
 			}
 		}
-	}
 
-	// create user model in db
-	if getErr != nil {
-		err = h.db.RegisterUser(&user)
-		if err != nil {
-			log.Error().Err(err).Send()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
+		// create user model in db
+		if getErr != nil {
+			if err := tx.RegisterUser(&user); err != nil {
+				return err
+			}
 		}
+
+		return h.enqueueMail(tx, request.Email, content)
+	})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to register user")
+		abortInternal(c)
+		return
 	}
+	h.outbox.Wake()
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Verification code has been sent to " + request.Email,
-		"timeout": h.config.MailSender.Timeout,
+		"timeout": h.config.Current().MailSender.Timeout,
 	})
 
 }
@@ -157,59 +189,83 @@ func (h *Handler) VerifyRegisterCode(c *gin.Context) {
 	var request VerifyCodeInput
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
 	// get user by email
-	user, err := h.db.GetUserByEmail(request.Email)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to get user by email")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email or password is incorrect"})
+	user, err := h.db.WithContext(c).GetUserByEmail(request.Email)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "user not found")
 		return
+	}
 
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to get user by email")
+		abortInternal(c)
+		return
 	}
 
 	if user.Verified {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user already registered"})
+		abort(c, http.StatusConflict, internal.ErrCodeUserExists, "user already registered")
 		return
 	}
 
 	if user.Code != request.Code {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong code"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidCode, "wrong code")
 		return
 	}
 
-	if user.UpdatedAt.Add(time.Duration(h.config.MailSender.Timeout) * time.Second).Before(time.Now()) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "code has expired"})
+	if user.UpdatedAt.Add(time.Duration(h.config.Current().MailSender.Timeout) * time.Second).Before(time.Now()) {
+		abort(c, http.StatusBadRequest, internal.ErrCodeCodeExpired, "code has expired")
 		return
 	}
 
-	err = h.db.UpdateUserVerification(user.ID, true)
+	content, err := h.mailService.WelcomeMailContent(user.Username, h.config.Current().Server.Host, h.mailService.MatchLocale(user.Language))
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to render welcome mail")
+		abortInternal(c)
+		return
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		if err := tx.UpdateUserVerification(user.ID, true); err != nil {
+			return err
+		}
+
+		return h.enqueueMail(tx, request.Email, content)
+	})
 	if err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
 		return
 
 	}
+	h.outbox.Wake()
+	internal.Signups.WithLabelValues(internal.SignupMethodPassword).Inc()
 
-	subject, body := h.mailService.WelcomeMailContent(user.Username, h.config.Server.Host)
-	err = h.mailService.SendMail(h.config.MailSender.Email, request.Email, subject, body)
+	err = h.webhooks.Publish(user.ID, models.EventUserRegistered, gin.H{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"email":    user.Email,
+	})
 	if err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Int("user_id", user.ID).Msg("failed to publish webhook event")
+	}
+
+	if !h.reevaluateAdmin(c, &user) {
 		return
 	}
 
 	// create token pairs
 	tokenPair, err := h.tokenManager.CreateTokenPair(user.ID, user.Username, user.Admin)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate token pair")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("Failed to generate token pair")
+		abortInternal(c)
 		return
 	}
-	c.JSON(http.StatusCreated, tokenPair)
+	h.respondTokens(c, tokenPair)
 }
 
 // LoginUserHandler logs user into the system
@@ -218,103 +274,219 @@ func (h *Handler) LoginUserHandler(c *gin.Context) {
 
 	// check on request format
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		abortBinding(c, err)
 		return
 	}
 
 	// get user by email
-	user, err := h.db.GetUserByEmail(request.Email)
+	user, err := h.db.WithContext(c).GetUserByEmail(request.Email)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidCredentials, "email or password is incorrect")
+		return
+	}
+
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get user by email")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email or password is incorrect"})
+		log.Ctx(c).Error().Err(err).Msg("failed to get user by email")
+		abortInternal(c)
 		return
+	}
 
+	// users created by single sign-on have no password
+	if len(user.Password) == 0 {
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidCredentials, "email or password is incorrect")
+		return
 	}
 
 	// verify password
 	match := internal.VerifyPassword(user.Password, request.Password)
 	if !match {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "email or password is incorrect"})
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidCredentials, "email or password is incorrect")
+		return
+	}
+
+	if !h.reevaluateAdmin(c, &user) {
 		return
 	}
 
 	// create token pairs
 	tokenPair, err := h.tokenManager.CreateTokenPair(user.ID, user.Username, user.Admin)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate token pair")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("Failed to generate token pair")
+		abortInternal(c)
 		return
 	}
-	c.JSON(http.StatusCreated, tokenPair)
-
+	h.respondTokens(c, tokenPair)
 }
 
 // RefreshTokenHandler handles token refresh requests
 func (h *Handler) RefreshTokenHandler(c *gin.Context) {
+	// cookie sessions send their refresh token as a cookie
+	if h.sessions != nil && h.sessions.RefreshToken(c.Request) != "" {
+		h.refreshSession(c)
+		return
+	}
+
 	var request RefreshTokenInput
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
-	accessToken, err := h.tokenManager.AccessTokenFromRefresh(request.RefreshToken)
-	if err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+	accessToken, ok := h.refreshAccessToken(c, request.RefreshToken)
+	if !ok {
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"access_token": accessToken})
 }
 
+// refreshAccessToken creates an access token from refreshToken unless it is invalid, logged out or its user is
+// deleted, it aborts the request and returns false otherwise
+func (h *Handler) refreshAccessToken(c *gin.Context, refreshToken string) (string, bool) {
+	claims, err := h.tokenManager.VerifyToken(refreshToken, internal.TokenTypeRefresh)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired refresh token")
+		return "", false
+	}
+
+	revoked, err := h.db.WithContext(c).IsSessionRevoked(claims.SessionID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to check session revocation")
+		abortInternal(c)
+		return "", false
+	}
+	if revoked {
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired refresh token")
+		return "", false
+	}
+
+	user, err := h.db.WithContext(c).GetUserByID(claims.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired refresh token")
+			return "", false
+		}
+		log.Ctx(c).Error().Err(err).Int("user_id", claims.UserID).Msg("failed to get user of refresh token")
+		abortInternal(c)
+		return "", false
+	}
+
+	if !h.reevaluateAdmin(c, &user) {
+		return "", false
+	}
+
+	accessToken, err := h.tokenManager.AccessTokenFromRefresh(refreshToken, user.Admin)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired refresh token")
+		return "", false
+	}
+	return accessToken, true
+}
+
+// refreshSession replaces the access token cookie of a cookie session
+func (h *Handler) refreshSession(c *gin.Context) {
+	refreshToken := h.sessions.RefreshToken(c.Request)
+	claims, err := h.tokenManager.VerifyToken(refreshToken, internal.TokenTypeRefresh)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abort(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired refresh token")
+		return
+	}
+
+	if !h.sessions.VerifyCSRF(c.Request, claims.SessionID) {
+		abort(c, http.StatusForbidden, internal.ErrCodeCSRFFailed, "CSRF token is missing or wrong")
+		return
+	}
+
+	accessToken, ok := h.refreshAccessToken(c, refreshToken)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, SessionResponse{CSRFToken: h.sessions.Refresh(c.Writer, accessToken, claims.SessionID)})
+}
+
+// reevaluateAdmin updates the admin role of user from the active admins config, so removing an email from it
+// takes effect on the next login or refresh. If provider groups decide admins, users of single sign-on keep the
+// role given on their last single sign-on login. It aborts the request and returns false on failure.
+func (h *Handler) reevaluateAdmin(c *gin.Context, user *models.User) bool {
+	admin := internal.Contains(h.config.Current().Admins, user.Email)
+	if !admin && user.OIDCSubject != "" && h.oidc != nil && h.oidc.MapsAdmins() {
+		admin = user.Admin
+	}
+	if admin == user.Admin {
+		return true
+	}
+
+	if err := h.db.WithContext(c).UpdateUserAdmin(user.ID, admin); err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", user.ID).Msg("failed to update admin role")
+		abortInternal(c)
+		return false
+	}
+	user.Admin = admin
+	return true
+}
+
 // ForgotPasswordHandler sends user verification code
 func (h *Handler) ForgotPasswordHandler(c *gin.Context) {
 	var request EmailInput
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
 	// get user by email
-	user, err := h.db.GetUserByEmail(request.Email)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to get user ")
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to get user"})
+	user, err := h.db.WithContext(c).GetUserByEmail(request.Email)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "user not found")
 		return
+	}
 
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to get user by email")
+		abortInternal(c)
+		return
 	}
 
 	code := internal.GenerateRandomCode()
-	subject, body := h.mailService.ResetPasswordMailContent(code, h.config.MailSender.Timeout, user.Username, h.config.Server.Host)
-	err = h.mailService.SendMail(h.config.MailSender.Email, request.Email, subject, body)
-
+	content, err := h.mailService.ResetPasswordMailContent(code, h.config.Current().MailSender.Timeout, user.Username, h.config.Current().Server.Host, h.mailService.MatchLocale(user.Language))
 	if err != nil {
-		log.Error().Err(err).Msg("failed to send verification code")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("failed to render reset password mail")
+		abortInternal(c)
 		return
 	}
 
-	err = h.db.UpdateUserByID(
-		&models.User{
-			ID:        user.ID,
-			UpdatedAt: time.Now(),
-			Code:      code,
-		},
-	)
+	err = h.db.WithContext(c).Transaction(func(tx models.DB) error {
+		err := tx.UpdateUserByID(
+			&models.User{
+				ID:        user.ID,
+				UpdatedAt: time.Now(),
+				Code:      code,
+			},
+		)
+		if err != nil {
+			return err
+		}
+
+		return h.enqueueMail(tx, request.Email, content)
+	})
 
 	if err != nil {
-		log.Error().Err(err).Msg("error updating user data")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("error updating user data")
+		abortInternal(c)
 		return
 	}
+	h.outbox.Wake()
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Verification code has been sent to " + request.Email,
-		"timeout": h.config.MailSender.Timeout,
+		"timeout": h.config.Current().MailSender.Timeout,
 	})
 
 }
@@ -324,80 +496,92 @@ func (h *Handler) VerifyForgetPasswordCodeHandler(c *gin.Context) {
 	var request VerifyCodeInput
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
 	// get user by email
-	user, err := h.db.GetUserByEmail(request.Email)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-
-		}
-		log.Error().Err(err).Msg("failed to get user by email")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	user, err := h.db.WithContext(c).GetUserByEmail(request.Email)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "user not found")
 		return
+	}
 
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to get user by email")
+		abortInternal(c)
+		return
 	}
 
 	if user.Code != request.Code {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong code"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidCode, "wrong code")
 		return
 	}
 
-	if user.UpdatedAt.Add(time.Duration(h.config.MailSender.Timeout) * time.Second).Before(time.Now()) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "code has expired"})
+	if user.UpdatedAt.Add(time.Duration(h.config.Current().MailSender.Timeout) * time.Second).Before(time.Now()) {
+		abort(c, http.StatusBadRequest, internal.ErrCodeCodeExpired, "code has expired")
+		return
+	}
+	if !h.reevaluateAdmin(c, &user) {
 		return
 	}
-	isAdmin := internal.Contains(h.config.Admins, request.Email)
 
 	// create token pairs
-	tokenPair, err := h.tokenManager.CreateTokenPair(user.ID, user.Username, isAdmin)
+	tokenPair, err := h.tokenManager.CreateTokenPair(user.ID, user.Username, user.Admin)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate token pair")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("Failed to generate token pair")
+		abortInternal(c)
 		return
 	}
-	c.JSON(http.StatusCreated, tokenPair)
-
+	h.respondTokens(c, tokenPair)
 }
 
-// ChangePasswordHandler changes password of user
+// ChangePasswordHandler changes password of the logged in user
 func (h *Handler) ChangePasswordHandler(c *gin.Context) {
+	// a leaked token must not be enough to take the account over
+	if !loggedIn(c) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Password can only be changed after logging in")
+		return
+	}
+
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
 	var request ChangePasswordInput
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
 		return
 	}
 
 	if request.Password != request.ConfirmPassword {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "password and confirm password don't match"})
+		abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "password and confirm password don't match")
 		return
 	}
 
 	// hash password
 	hashedPassword, err := internal.HashAndSaltPassword([]byte(request.Password))
 	if err != nil {
-		log.Error().Err(err).Msg("error hashing password")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Msg("error hashing password")
+		abortInternal(c)
 		return
 	}
 
-	err = h.db.UpdatePassword(request.Email, hashedPassword)
+	err = h.db.WithContext(c).UpdatePassword(ID, hashedPassword)
 	if err == gorm.ErrRecordNotFound {
-		log.Error().Err(err).Msg("user not found")
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		log.Ctx(c).Error().Err(err).Msg("user not found")
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "user not found")
 		return
 	}
 
 	if err != nil {
-		log.Error().Err(err).Send()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
 		return
 
 	}
@@ -405,3 +589,221 @@ func (h *Handler) ChangePasswordHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Password is updated successfully"})
 
 }
+
+// ChangeLanguageHandler changes preferred language of user
+func (h *Handler) ChangeLanguageHandler(c *gin.Context) {
+	var request LanguageInput
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	err = h.db.WithContext(c).UpdateUserByID(&models.User{ID: ID, Language: request.Language})
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Language is updated successfully",
+		"mail_locale": h.mailService.MatchLocale(request.Language),
+	})
+}
+
+// UserExport holds all data stored about a user
+type UserExport struct {
+	ExportedAt              time.Time                       `json:"exported_at"`
+	User                    models.User                     `json:"user"`
+	Transactions            []models.Transaction            `json:"transactions"`
+	Quota                   *models.Quota                   `json:"quota,omitempty"`
+	AuditLogs               []models.AuditLog               `json:"audit_logs"`
+	Notifications           []models.Notification           `json:"notifications"`
+	NotificationPreferences []models.NotificationPreference `json:"notification_preferences"`
+	Webhooks                []WebhookExport                 `json:"webhooks"`
+	APITokens               []models.APIToken               `json:"api_tokens"`
+	Organizations           []models.Organization           `json:"organizations"` // memberships with the role of the user
+	Invitations             []models.OrganizationInvitation `json:"invitations"`   // sent to or by the user
+}
+
+// WebhookExport holds a webhook of a user with its deliveries
+type WebhookExport struct {
+	models.Webhook
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// ListTransactionsHandler lists transactions of the logged in user or of its active organization
+func (h *Handler) ListTransactionsHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	// the active organization has its own ledger
+	if c.GetInt("organization_id") != 0 {
+		if !internal.Contains(models.OrganizationBillingRoles, c.GetString("organization_role")) {
+			abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Your organization role does not allow this request")
+			return
+		}
+		h.ListOrganizationTransactionsHandler(c)
+		return
+	}
+
+	transactions, err := h.db.WithContext(c).ListUserTransactions(ID)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", ID).Msg("failed to list transactions")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// ExportUserDataHandler exports all data stored about the logged in user as json or zip
+func (h *Handler) ExportUserDataHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "format should be json or zip")
+		return
+	}
+
+	export, err := h.collectUserExport(c, ID)
+	if err == gorm.ErrRecordNotFound {
+		abort(c, http.StatusNotFound, internal.ErrCodeUserNotFound, "user not found")
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", ID).Msg("failed to collect user data")
+		abortInternal(c)
+		return
+	}
+
+	content, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to encode user data")
+		abortInternal(c)
+		return
+	}
+
+	fileName := fmt.Sprintf("kubecloud-user-%d", ID)
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", fileName))
+		c.Data(http.StatusOK, "application/json", content)
+		return
+	}
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	file, err := zipWriter.Create(fileName + ".json")
+	if err == nil {
+		_, err = file.Write(content)
+	}
+	if err == nil {
+		err = zipWriter.Close()
+	}
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to archive user data")
+		abortInternal(c)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", fileName))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// collectUserExport gathers everything stored about a user
+func (h *Handler) collectUserExport(ctx context.Context, userID int) (UserExport, error) {
+	db := h.db.WithContext(ctx)
+
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		return UserExport{}, err
+	}
+	user.Password = nil
+
+	transactions, err := db.ListUserTransactions(userID)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list transactions: %w", err)
+	}
+
+	var quota *models.Quota
+	userQuota, err := db.GetUserQuota(userID)
+	if err == nil {
+		quota = &userQuota
+	} else if err != gorm.ErrRecordNotFound {
+		return UserExport{}, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	auditLogs, err := db.ListAuditLogs(models.AuditFilter{ActorID: userID})
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+
+	notifications, err := db.ListUserNotifications(userID, false)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list notifications: %w", err)
+	}
+
+	preferences, err := db.ListNotificationPreferences(userID)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list notification preferences: %w", err)
+	}
+
+	userWebhooks, err := db.ListUserWebhooks(userID)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	webhooks := make([]WebhookExport, 0, len(userWebhooks))
+	for _, webhook := range userWebhooks {
+		deliveries, err := db.ListWebhookDeliveries(webhook.ID, -1)
+		if err != nil {
+			return UserExport{}, fmt.Errorf("failed to list webhook deliveries: %w", err)
+		}
+		webhooks = append(webhooks, WebhookExport{Webhook: webhook, Deliveries: deliveries})
+	}
+
+	apiTokens, err := db.ListUserAPITokens(userID)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+
+	organizations, err := db.ListUserOrganizations(userID)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list organizations: %w", err)
+	}
+
+	invitations, err := db.ListUserOrganizationInvitations(userID, user.Email)
+	if err != nil {
+		return UserExport{}, fmt.Errorf("failed to list invitations: %w", err)
+	}
+
+	return UserExport{
+		ExportedAt:              time.Now(),
+		User:                    user,
+		Transactions:            transactions,
+		Quota:                   quota,
+		AuditLogs:               auditLogs,
+		Notifications:           notifications,
+		NotificationPreferences: preferences,
+		Webhooks:                webhooks,
+		APITokens:               apiTokens,
+		Organizations:           organizations,
+		Invitations:             invitations,
+	}, nil
+}
@@ -0,0 +1,329 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRegisterDeletedEmail(t *testing.T) {
+	app := newTestApp(t, nil)
+	_, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	user, _ := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	register := `{"name":"user","email":"user@kubecloud.io","password":"password","confirm_password":"password"}`
+	if w := serve(app, http.MethodPost, "/api/v1/user/register", "", register); w.Code != http.StatusConflict {
+		t.Fatalf("expected a registered email to conflict, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(app, http.MethodDelete, "/api/v1/user/"+strconv.Itoa(user.ID), adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the user to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(app, http.MethodPost, "/api/v1/user/register", "", register); w.Code != http.StatusOK {
+		t.Fatalf("expected the email of a deleted user to be registered again, got %d %s", w.Code, w.Body.String())
+	}
+	registered, err := app.handlers.db.GetUserByEmail("user@kubecloud.io")
+	if err != nil || registered.ID == user.ID {
+		t.Fatalf("expected a new user, got %+v %v", registered, err)
+	}
+}
+
+func TestRegisterVerificationMail(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	register := `{"name":"user","email":"user@kubecloud.io","password":"password","confirm_password":"password"}`
+	if w := serve(app, http.MethodPost, "/api/v1/user/register", "", register); w.Code != http.StatusOK {
+		t.Fatalf("expected the user to be registered, got %d %s", w.Code, w.Body.String())
+	}
+	app.handlers.outbox.SendDue()
+
+	user, err := app.handlers.db.GetUserByEmail("user@kubecloud.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(app.config.MailSender.Directory, "*-user_at_kubecloud.io.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected the verification mail to be sent, got %v %v", files, err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), strconv.Itoa(user.Code)) {
+		t.Fatalf("expected the mail to hold the verification code %d", user.Code)
+	}
+
+	verify := `{"email":"user@kubecloud.io","code":` + strconv.Itoa(user.Code) + `}`
+	if w := serve(app, http.MethodPost, "/api/v1/user/register/verify", "", verify); w.Code != http.StatusCreated {
+		t.Fatalf("expected the code from the mail to verify the user, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAnonymizeDeletedUsers(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Audit.HashChain = true
+	})
+	db := app.handlers.db
+	user, _ := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io", Language: "de", OIDCSubject: "subject", Mnemonic: "mnemonic"})
+
+	webhook := models.Webhook{UserID: user.ID, URL: "https://example.com/hook", Secret: "secret"}
+	organization := models.Organization{Name: "organization"}
+	for _, err := range []error{
+		db.EnqueueMail(&models.OutboxMail{Receiver: user.Email, Subject: "welcome", TextBody: "hello user"}),
+		db.CreateNotification(&models.Notification{UserID: user.ID, Title: "notification"}),
+		db.CreateWebhook(&webhook),
+		db.CreateOrganization(&organization),
+		app.auditor.Record(context.Background(), models.AuditLog{ActorID: user.ID, Action: "user.login", SourceIP: "203.0.113.1"}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateWebhookDelivery(&models.WebhookDelivery{WebhookID: webhook.ID, Payload: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddOrganizationMember(&models.OrganizationMember{OrganizationID: organization.ID, UserID: user.ID, Role: models.OrganizationRoleOwner}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteUserByID(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if anonymized, err := db.AnonymizeDeletedUsers(time.Now().Add(time.Minute)); err != nil || anonymized != 1 {
+		t.Fatalf("expected 1 user to be anonymized, got %d %v", anonymized, err)
+	}
+
+	if _, err := db.GetUserByOIDCSubject("subject"); err != gorm.ErrRecordNotFound {
+		t.Fatalf("expected the single sign-on subject to be wiped, got %v", err)
+	}
+
+	// deleted users are hidden from the app, so the row is read directly
+	conn, err := gorm.Open(sqlite.Open(app.config.Database.File), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var anonymized models.User
+	if err := conn.Unscoped().First(&anonymized, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if anonymized.Email == user.Email || anonymized.Username == user.Username || anonymized.Language != "" || anonymized.Mnemonic != "" || len(anonymized.Password) != 0 {
+		t.Fatalf("expected personal data to be wiped, got %+v", anonymized)
+	}
+	for _, model := range []interface{}{&models.OutboxMail{}, &models.Notification{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.OrganizationMember{}} {
+		var count int64
+		if err := conn.Model(model).Count(&count).Error; err != nil || count != 0 {
+			t.Errorf("expected %T rows of the user to be deleted, got %d %v", model, count, err)
+		}
+	}
+
+	logs, err := db.ListAuditLogs(models.AuditFilter{ActorID: user.ID})
+	if err != nil || len(logs) != 1 || logs[0].SourceIP != "" {
+		t.Fatalf("expected the source IP of the audit log to be wiped, got %+v %v", logs, err)
+	}
+	if _, ok := internal.VerifyAuditChain(logs); !ok {
+		t.Fatal("expected the audit chain to stay intact")
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	app := newTestApp(t, nil)
+	db := app.handlers.db
+	user, token := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	if err := db.CreateNotification(&models.Notification{UserID: user.ID, Event: models.EventBalanceCredited, Title: "credited"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertNotificationPreference(&models.NotificationPreference{UserID: user.ID, Event: models.EventBalanceCredited, InApp: true}); err != nil {
+		t.Fatal(err)
+	}
+	webhook := models.Webhook{UserID: user.ID, URL: "https://hooks.kubecloud.io", Events: []string{"*"}, Active: true}
+	if err := db.CreateWebhook(&webhook); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateWebhookDelivery(&models.WebhookDelivery{WebhookID: webhook.ID, Event: models.EventBalanceCredited}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAPIToken(&models.APIToken{UserID: user.ID, Name: "ci", Hash: "hash", Scopes: []string{models.ScopeRead}}); err != nil {
+		t.Fatal(err)
+	}
+	organization := models.Organization{Name: "acme"}
+	if err := db.CreateOrganization(&organization); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddOrganizationMember(&models.OrganizationMember{OrganizationID: organization.ID, UserID: user.ID, Role: models.OrganizationRoleOwner}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateOrganizationInvitation(&models.OrganizationInvitation{OrganizationID: organization.ID, Email: "friend@kubecloud.io", InvitedBy: user.ID, Hash: "invitation"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(app, http.MethodGet, "/api/v1/user/me/export", token, "")
+	var export UserExport
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected an export, got %d %s", w.Code, w.Body.String())
+	}
+
+	if len(export.Notifications) != 1 || len(export.NotificationPreferences) != 1 || len(export.APITokens) != 1 || len(export.Invitations) != 1 {
+		t.Fatalf("expected notifications, preferences, tokens and invitations, got %+v", export)
+	}
+	if len(export.Webhooks) != 1 || len(export.Webhooks[0].Deliveries) != 1 {
+		t.Fatalf("expected the webhook with its deliveries, got %+v", export.Webhooks)
+	}
+	if len(export.Organizations) != 1 || export.Organizations[0].Role != models.OrganizationRoleOwner {
+		t.Fatalf("expected the membership, got %+v", export.Organizations)
+	}
+	if len(export.User.Password) != 0 {
+		t.Fatal("expected the password hash to be left out")
+	}
+}
+
+func TestDeletedUserTokens(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.RateLimit.Disabled = true
+	})
+	_, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	other, otherToken := newTestUser(t, app, models.User{Username: "other", Email: "other@kubecloud.io", Admin: true})
+	user, _ := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+	tokens, err := app.handlers.tokenManager.CreateTokenPair(user.ID, user.Username, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := `{"refresh_token":"` + tokens.RefreshToken + `"}`
+
+	// refreshed access tokens expire like access tokens, not like refresh tokens
+	w := serve(app, http.MethodPost, "/api/v1/user/refresh", "", refresh)
+	var refreshed struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &refreshed); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected the token to be refreshed, got %d %s", w.Code, w.Body.String())
+	}
+	claims, err := app.handlers.tokenManager.VerifyToken(refreshed.AccessToken, internal.TokenTypeAccess)
+	if err != nil || time.Until(claims.ExpiresAt.Time) > 5*time.Minute {
+		t.Fatalf("expected the refreshed token to expire in 5 minutes, got %v %v", claims, err)
+	}
+
+	if w := serve(app, http.MethodDelete, "/api/v1/user/"+strconv.Itoa(user.ID), adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the user to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+	if err := app.handlers.db.DeleteUserByID(other.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(app, http.MethodGet, "/api/v1/user/quota", tokens.AccessToken, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the token of a deleted user to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodPost, "/api/v1/user/refresh", "", refresh); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the refresh token of a deleted user to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodGet, "/api/v1/admin/audit", otherToken, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the token of a deleted admin to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.RateLimit.Disabled = true
+	})
+	admin, adminToken := newTestUser(t, app, models.User{Username: "admin", Email: "admin@kubecloud.io", Admin: true})
+	owner, ownerToken := newTestUser(t, app, models.User{Username: "owner", Email: "owner@kubecloud.io"})
+	member, _ := newTestUser(t, app, models.User{Username: "member", Email: "member@kubecloud.io"})
+
+	for path, code := range map[string]int{
+		"/api/v1/user/" + strconv.Itoa(admin.ID): http.StatusForbidden,
+		"/api/v1/user/9999":                      http.StatusNotFound,
+	} {
+		if w := serve(app, http.MethodDelete, path, adminToken, ""); w.Code != code {
+			t.Errorf("expected %d deleting %s, got %d %s", code, path, w.Code, w.Body.String())
+		}
+	}
+
+	// the only owner of an organization with members has to hand it over first
+	shared := newTestOrganization(t, app, ownerToken, map[int]string{member.ID: models.OrganizationRoleMember})
+	alone := newTestOrganization(t, app, ownerToken, nil)
+	path := "/api/v1/user/" + strconv.Itoa(owner.ID)
+	if w := serve(app, http.MethodDelete, path, adminToken, ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected deleting the only owner to conflict, got %d %s", w.Code, w.Body.String())
+	}
+
+	if err := app.handlers.db.UpdateOrganizationMemberRole(shared.ID, member.ID, models.OrganizationRoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(app, http.MethodDelete, path, adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the user to be deleted, got %d %s", w.Code, w.Body.String())
+	}
+	if _, err := app.handlers.db.GetOrganization(alone.ID); err != gorm.ErrRecordNotFound {
+		t.Fatalf("expected the organization of the user alone to be deleted, got %v", err)
+	}
+	if _, err := app.handlers.db.GetOrganization(shared.ID); err != nil {
+		t.Fatalf("expected the shared organization to be kept, got %v", err)
+	}
+}
+
+func TestAdminsReevaluated(t *testing.T) {
+	app := newTestApp(t, func(config *internal.Configuration) {
+		config.Admins = []string{"admin@kubecloud.io"}
+		config.RateLimit.Disabled = true
+	})
+
+	password, err := internal.HashAndSaltPassword([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"admin@kubecloud.io", "user@kubecloud.io"} {
+		if err := app.handlers.db.RegisterUser(&models.User{Username: email, Email: email, Password: password, Verified: true, Admin: email == "admin@kubecloud.io"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func(email string) internal.TokenPair {
+		t.Helper()
+		w := serve(app, http.MethodPost, "/api/v1/user/login", "", `{"email":"`+email+`","password":"password"}`)
+		var tokens internal.TokenPair
+		if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("expected %s to log in, got %d %s", email, w.Code, w.Body.String())
+		}
+		return tokens
+	}
+	adminTokens := login("admin@kubecloud.io")
+
+	// admins are swapped in the configuration file
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"token":{"secret":"secret"},"mailSender":{"email":"noreply@kubecloud.io"},"admins":["user@kubecloud.io"]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	app.WatchConfig(path)
+	if err := app.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(app, http.MethodPost, "/api/v1/user/refresh", "", `{"refresh_token":"`+adminTokens.RefreshToken+`"}`)
+	var refreshed internal.TokenPair
+	if err := json.Unmarshal(w.Body.Bytes(), &refreshed); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected the token to be refreshed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(app, http.MethodGet, "/api/v1/user", refreshed.AccessToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a removed admin to lose admin access on refresh, got %d", w.Code)
+	}
+	if user, err := app.handlers.db.GetUserByEmail("admin@kubecloud.io"); err != nil || user.Admin {
+		t.Fatalf("expected the admin role to be removed, got %+v %v", user, err)
+	}
+
+	if w := serve(app, http.MethodGet, "/api/v1/user", login("user@kubecloud.io").AccessToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected an added admin to get admin access on login, got %d", w.Code)
+	}
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// WebhookInput holds data needed to register a webhook
+type WebhookInput struct {
+	URL    string   `json:"url" binding:"required,url"`
+	Events []string `json:"events" binding:"required,min=1"`
+	Global bool     `json:"global"` // admins only, receives events of all users
+}
+
+// CreateWebhookHandler registers a webhook for the logged in user or its active organization
+func (h *Handler) CreateWebhookHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	organizationID, ok := webhookOrganization(c)
+	if !ok {
+		return
+	}
+
+	var request WebhookInput
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortBinding(c, err)
+		return
+	}
+
+	endpoint, err := url.Parse(request.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "webhook url should be http or https")
+		return
+	}
+
+	for _, event := range request.Events {
+		if event != "*" && !internal.Contains(models.WebhookEvents, event) {
+			abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "unknown webhook event "+event)
+			return
+		}
+	}
+
+	if request.Global && !c.GetBool("admin") {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Admin access required for global webhooks")
+		return
+	}
+
+	if request.Global && organizationID != 0 {
+		abort(c, http.StatusBadRequest, internal.ErrCodeValidationFailed, "global webhooks cannot belong to an organization")
+		return
+	}
+
+	secret, err := internal.GenerateWebhookSecret()
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to generate webhook secret")
+		abortInternal(c)
+		return
+	}
+
+	webhook := models.Webhook{
+		UserID:         ID,
+		OrganizationID: organizationID,
+		URL:            request.URL,
+		Secret:         secret,
+		Events:         request.Events,
+		Global:         request.Global,
+		Active:         true,
+		CreatedAt:      time.Now(),
+	}
+
+	if err := h.db.WithContext(c).CreateWebhook(&webhook); err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to create webhook")
+		abortInternal(c)
+		return
+	}
+
+	// secret is only shown once
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// ListWebhooksHandler lists webhooks of the logged in user or its active organization
+func (h *Handler) ListWebhooksHandler(c *gin.Context) {
+	ID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	organizationID, ok := webhookOrganization(c)
+	if !ok {
+		return
+	}
+
+	var webhooks []models.Webhook
+	if organizationID != 0 {
+		webhooks, err = h.db.WithContext(c).ListOrganizationWebhooks(organizationID)
+	} else {
+		webhooks, err = h.db.WithContext(c).ListUserWebhooks(ID)
+	}
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Msg("failed to list webhooks")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// DeleteWebhookHandler deletes a webhook of the logged in user
+func (h *Handler) DeleteWebhookHandler(c *gin.Context) {
+	webhook, ok := h.userWebhook(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.WithContext(c).DeleteWebhook(webhook.ID); err != nil {
+		log.Ctx(c).Error().Err(err).Int("webhook_id", webhook.ID).Msg("failed to delete webhook")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook is deleted successfully"})
+}
+
+// ListWebhookDeliveriesHandler lists latest deliveries of a webhook
+func (h *Handler) ListWebhookDeliveriesHandler(c *gin.Context) {
+	webhook, ok := h.userWebhook(c)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.db.WithContext(c).ListWebhookDeliveries(webhook.ID, 100)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("webhook_id", webhook.ID).Msg("failed to list webhook deliveries")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// PingWebhookHandler sends a test event to a webhook and reports the delivery
+func (h *Handler) PingWebhookHandler(c *gin.Context) {
+	webhook, ok := h.userWebhook(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhooks.Ping(webhook)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("webhook_id", webhook.ID).Msg("failed to ping webhook")
+		abortInternal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// webhookOrganization gets the active organization webhooks of the request belong to,
+// only its managers can use them
+func webhookOrganization(c *gin.Context) (int, bool) {
+	organizationID := c.GetInt("organization_id")
+	if organizationID != 0 && !internal.Contains(models.OrganizationManagerRoles, c.GetString("organization_role")) {
+		abort(c, http.StatusForbidden, internal.ErrCodeForbidden, "Your organization role does not allow this request")
+		return 0, false
+	}
+
+	return organizationID, true
+}
+
+// userWebhook gets webhook from route params if it belongs to the logged in user or its active organization
+func (h *Handler) userWebhook(c *gin.Context) (models.Webhook, bool) {
+	userID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+		return models.Webhook{}, false
+	}
+
+	organizationID, ok := webhookOrganization(c)
+	if !ok {
+		return models.Webhook{}, false
+	}
+
+	ID, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		abort(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid webhook ID")
+		return models.Webhook{}, false
+	}
+
+	webhook, err := h.db.WithContext(c).GetWebhook(ID)
+	owned := webhook.OrganizationID == organizationID && (organizationID != 0 || webhook.UserID == userID)
+	if err == gorm.ErrRecordNotFound || (err == nil && !owned) {
+		abort(c, http.StatusNotFound, internal.ErrCodeNotFound, "webhook not found")
+		return models.Webhook{}, false
+	}
+
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Send()
+		abortInternal(c)
+		return models.Webhook{}, false
+	}
+
+	return webhook, true
+}
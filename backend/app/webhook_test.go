@@ -0,0 +1,60 @@
+package app
+
+import (
+	"encoding/json"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPingWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal details"))
+	}))
+	defer server.Close()
+
+	for _, allowLoopback := range []bool{false, true} {
+		app := newTestApp(t, func(config *internal.Configuration) {
+			if allowLoopback {
+				config.Webhooks.AllowedNetworks = []string{"127.0.0.0/8"}
+			}
+		})
+		_, token := newTestUser(t, app, models.User{Username: "user", Email: "user@kubecloud.io"})
+
+		if w := serve(app, http.MethodPost, "/api/v1/user/webhooks", token, `{"url":"ftp://hooks.kubecloud.io","events":["*"]}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected a webhook url other than http to be refused, got %d %s", w.Code, w.Body.String())
+		}
+		if w := serve(app, http.MethodPost, "/api/v1/user/webhooks", token, `{"url":"`+server.URL+`","events":["cluster.ready"]}`); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected an event nothing raises to be refused, got %d %s", w.Code, w.Body.String())
+		}
+
+		w := serve(app, http.MethodPost, "/api/v1/user/webhooks", token, `{"url":"`+server.URL+`","events":["*"]}`)
+		var created struct {
+			Webhook models.Webhook `json:"webhook"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("expected the webhook to be created, got %d %s", w.Code, w.Body.String())
+		}
+
+		w = serve(app, http.MethodPost, "/api/v1/user/webhooks/"+strconv.Itoa(created.Webhook.ID)+"/ping", token, "")
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal(w.Body.Bytes(), &delivery); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("expected the ping delivery, got %d %s", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "internal details") {
+			t.Fatal("expected the response body of the webhook not to be shown")
+		}
+
+		status := models.DeliveryFailed
+		if allowLoopback {
+			status = models.DeliverySucceeded
+		}
+		if delivery.Status != status {
+			t.Fatalf("expected the ping to be %s with loopback allowed %v, got %+v", status, allowLoopback, delivery)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TokenPair holds the tokens of a logged in user
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RegisterRequest holds data of a new user
+type RegisterRequest struct {
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirm_password"`
+	Language        string `json:"language,omitempty"`
+	Challenge       string `json:"-"` // solved challenge, see Challenge
+}
+
+// CodeSent is returned when a verification code is mailed
+type CodeSent struct {
+	Message string `json:"message"`
+	Timeout int    `json:"timeout"` // in seconds
+}
+
+// Challenge is what has to be solved before registering or resetting a password
+type Challenge struct {
+	Provider  string           `json:"provider"`
+	SiteKey   string           `json:"site_key"`
+	Challenge *IssuedChallenge `json:"challenge,omitempty"`
+}
+
+// IssuedChallenge is a proof of work challenge
+type IssuedChallenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// SetTokens sets the tokens used by next requests, like ones saved from an earlier login
+func (c *Client) SetTokens(tokens TokenPair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokens = tokens
+	c.apiToken = ""
+}
+
+// Tokens returns the current tokens, the access token changes when it is refreshed
+func (c *Client) Tokens() TokenPair {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tokens
+}
+
+func (c *Client) token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.apiToken != "" {
+		return c.apiToken
+	}
+	return c.tokens.AccessToken
+}
+
+func (c *Client) canRefresh() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.apiToken == "" && c.tokens.RefreshToken != ""
+}
+
+// refreshCall is a refresh of the access token, done is closed once err is set
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// refresh gets a new access token unless expired isn't the current one anymore, like if
+// another request refreshed it meanwhile. Requests failing during a refresh wait for it instead
+// of sending their own, the lock isn't held while the refresh is sent.
+func (c *Client) refresh(ctx context.Context, expired string) error {
+	c.mu.Lock()
+	if c.tokens.AccessToken != expired {
+		c.mu.Unlock()
+		return nil
+	}
+
+	if call := c.refreshing; call != nil {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	c.refreshing = call
+	refreshToken := c.tokens.RefreshToken
+	c.mu.Unlock()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	call.err = c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/refresh",
+		body:   map[string]string{"refresh_token": refreshToken},
+		result: &result,
+		public: true,
+	})
+
+	c.mu.Lock()
+	// tokens set meanwhile, like by a login, are newer than the refreshed one
+	if call.err == nil && c.tokens.RefreshToken == refreshToken {
+		c.tokens.AccessToken = result.AccessToken
+	}
+	c.refreshing = nil
+	c.mu.Unlock()
+
+	close(call.done)
+	return call.err
+}
+
+// Refresh gets a new access token with the refresh token
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, c.Tokens().AccessToken)
+}
+
+// Challenge gets the challenge to solve before Register and ForgotPassword
+func (c *Client) Challenge(ctx context.Context) (Challenge, error) {
+	var challenge Challenge
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/challenge", result: &challenge, public: true})
+	return challenge, err
+}
+
+// Register registers a new user, a code is mailed to verify with VerifyRegistration
+func (c *Client) Register(ctx context.Context, user RegisterRequest) (CodeSent, error) {
+	var sent CodeSent
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/register",
+		body:   user,
+		result: &sent,
+		public: true,
+		header: challengeHeaders(user.Challenge),
+	})
+	return sent, err
+}
+
+// VerifyRegistration verifies the mailed code and logs in
+func (c *Client) VerifyRegistration(ctx context.Context, email string, code int) (TokenPair, error) {
+	return c.login(ctx, "/user/register/verify", map[string]interface{}{"email": email, "code": code})
+}
+
+// Login logs in with email and password, next requests are sent as the user
+func (c *Client) Login(ctx context.Context, email, password string) (TokenPair, error) {
+	return c.login(ctx, "/user/login", map[string]string{"email": email, "password": password})
+}
+
+// ForgotPassword mails a code to verify with VerifyForgotPassword
+func (c *Client) ForgotPassword(ctx context.Context, email, challenge string) (CodeSent, error) {
+	var sent CodeSent
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/forgot_password",
+		body:   map[string]string{"email": email},
+		result: &sent,
+		public: true,
+		header: challengeHeaders(challenge),
+	})
+	return sent, err
+}
+
+// VerifyForgotPassword verifies the mailed code and logs in, the password can then be changed with ChangePassword
+func (c *Client) VerifyForgotPassword(ctx context.Context, email string, code int) (TokenPair, error) {
+	return c.login(ctx, "/user/forgot_password/verify", map[string]interface{}{"email": email, "code": code})
+}
+
+func (c *Client) login(ctx context.Context, path string, body interface{}) (TokenPair, error) {
+	var tokens TokenPair
+	err := c.do(ctx, request{method: http.MethodPost, path: path, body: body, result: &tokens, public: true})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	c.SetTokens(tokens)
+	return tokens, nil
+}
+
+func challengeHeaders(challenge string) http.Header {
+	if challenge == "" {
+		return nil
+	}
+	return http.Header{challengeHeader: {challenge}}
+}
@@ -0,0 +1,260 @@
+// Package client is a Go client of the KubeCloud API.
+//
+// It covers authentication, users, vouchers and transactions. Requests are retried on rate limits and
+// unavailable servers and the access token is refreshed automatically once it expires.
+// Clusters are not served by the backend yet so they have no client methods.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultRetryWait  = 500 * time.Millisecond
+	maxRetryWait      = 30 * time.Second
+
+	challengeHeader    = "X-Challenge-Response"
+	organizationHeader = "X-Organization-ID"
+)
+
+// Config holds the options of a client
+type Config struct {
+	BaseURL    string        // address of the backend like https://api.kubecloud.io, without /api/v1
+	HTTPClient *http.Client  // defaults to a client with a 30 seconds timeout
+	MaxRetries int           // defaults to 3, negative disables retries
+	RetryWait  time.Duration // wait before the first retry, doubled after each retry, defaults to 500ms
+	APIToken   string        // personal access token, used instead of logging in
+}
+
+// Client calls the KubeCloud API, it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	retryWait  time.Duration
+
+	mu         sync.Mutex
+	tokens     TokenPair
+	apiToken   string
+	refreshing *refreshCall // refresh in flight, shared by requests whose token expired meanwhile
+}
+
+// Error is returned for responses with an error status, Code is stable and meant to be switched on
+type Error struct {
+	StatusCode int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Details    []FieldError `json:"details,omitempty"`
+	RequestID  string       `json:"request_id,omitempty"`
+}
+
+// FieldError describes a request field failing validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kubecloud: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// StatusCode returns the status of an API error, zero for other errors
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// ErrorCode returns the code of an API error, empty for other errors
+func ErrorCode(err error) string {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ""
+}
+
+// NewClient creates a new client
+func NewClient(config Config) *Client {
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	maxRetries := config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	retryWait := config.RetryWait
+	if retryWait == 0 {
+		retryWait = defaultRetryWait
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(config.BaseURL, "/") + "/api/v1",
+		httpClient: httpClient,
+		maxRetries: maxRetries,
+		retryWait:  retryWait,
+		apiToken:   config.APIToken,
+	}
+}
+
+// request is a call to the API
+type request struct {
+	method string
+	path   string
+	body   interface{}
+	result interface{} // decoded from the response if not nil
+	public bool        // sent without a token
+	header http.Header
+}
+
+// do sends a request, retrying it if possible and refreshing the access token once if it expired
+func (c *Client) do(ctx context.Context, r request) error {
+	var body []byte
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		token := ""
+		if !r.public {
+			token = c.token()
+		}
+
+		resp, err := c.send(ctx, r, body, token)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= c.maxRetries || !idempotent(r.method) {
+				return err
+			}
+			if err := c.wait(ctx, attempt, ""); err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && token != "" && !refreshed && c.canRefresh() {
+			refreshed = true
+			if err := c.refresh(ctx, token); err != nil {
+				return err
+			}
+			attempt--
+			continue
+		}
+
+		if retryable(r.method, resp.StatusCode) && attempt < c.maxRetries {
+			if err := c.wait(ctx, attempt, resp.Header.Get("Retry-After")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return decodeError(resp.StatusCode, content)
+		}
+
+		if r.result != nil && len(content) > 0 {
+			if err := json.Unmarshal(content, r.result); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+func (c *Client) send(ctx context.Context, r request, body []byte, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, c.baseURL+r.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// wait sleeps before a retry for the time asked by the server or an exponential backoff
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter string) error {
+	wait := c.retryWait << attempt
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > maxRetryWait || wait < 0 {
+		wait = maxRetryWait
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func idempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+}
+
+// retryable reports whether a response status is worth retrying, requests rejected before
+// being handled are always retried, others only if repeating them is harmless
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+func decodeError(status int, content []byte) error {
+	var body struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal(content, &body); err != nil || body.Error == nil {
+		return &Error{StatusCode: status, Message: http.StatusText(status)}
+	}
+
+	body.Error.StatusCode = status
+	return body.Error
+}
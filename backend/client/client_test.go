@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"kubecloud/app"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"kubecloud/models/sqlite"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the API with an admin user admin@kubecloud.io logging in with "password"
+func newTestServer(t *testing.T) *httptest.Server {
+	config := internal.Configuration{}
+	config.Server.Host = "localhost"
+	config.Database.File = filepath.Join(t.TempDir(), "db.sqlite")
+	config.JWT.Secret = "secret"
+	config.JWT.AccessTokenExpiryMinutes = 5
+	config.JWT.RefreshTokenExpiryHours = 1
+	config.MailSender.Driver = "file"
+	config.MailSender.Directory = t.TempDir()
+	config.MailSender.Email = "noreply@kubecloud.io"
+	config.MailSender.Timeout = 60
+	config.Admins = []string{"admin@kubecloud.io"}
+
+	server, err := app.NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sqlite.NewSqliteStorage(config.Database.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	password, err := internal.HashAndSaltPassword([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RegisterUser(&models.User{Username: "admin", Email: "admin@kubecloud.io", Password: password, Verified: true, Admin: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	c := NewClient(Config{BaseURL: ts.URL})
+
+	_, err := c.Login(ctx, "admin@kubecloud.io", "wrong password")
+	if StatusCode(err) != http.StatusUnauthorized || ErrorCode(err) != "invalid_credentials" {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+
+	tokens, err := c.Login(ctx, "admin@kubecloud.io", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quota, err := c.Quota(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.UserID != 1 {
+		t.Fatalf("expected quota of user 1, got %+v", quota)
+	}
+
+	// an invalid access token is refreshed once and the request sent again
+	c.SetTokens(TokenPair{AccessToken: "expired", RefreshToken: tokens.RefreshToken})
+	users, err := c.ListUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || c.Tokens().AccessToken == "expired" {
+		t.Fatalf("expected the access token to be refreshed, got %d users", len(users))
+	}
+	if users[0].ID != 1 || users[0].Email != "admin@kubecloud.io" || !users[0].Admin {
+		t.Fatalf("expected the admin user, got %+v", users[0])
+	}
+
+	c.SetTokens(TokenPair{AccessToken: "expired", RefreshToken: "invalid"})
+	if _, err := c.Quota(ctx); StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized with an invalid refresh token, got %v", err)
+	}
+
+	if _, err := c.Login(ctx, "admin@kubecloud.io", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	vouchers, err := c.GenerateVouchers(ctx, 1, 10, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := c.ListVouchers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vouchers) != 1 || len(listed) != 1 {
+		t.Fatalf("expected one voucher, generated %d and listed %d", len(vouchers), len(listed))
+	}
+
+	if err := c.CreditUser(ctx, 1, 25, "welcome credit"); err != nil {
+		t.Fatal(err)
+	}
+	transactions, err := c.Transactions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Amount != 25 {
+		t.Fatalf("expected the credit transaction, got %+v", transactions)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewClient(Config{BaseURL: ts.URL, RetryWait: time.Millisecond})
+
+	// rate limited twice then a bad gateway which is not retried for a login
+	_, err := c.Login(ctx, "admin@kubecloud.io", "password")
+	if StatusCode(err) != http.StatusBadGateway || calls.Load() != 3 {
+		t.Fatalf("expected a bad gateway after 3 calls, got %v after %d calls", err, calls.Load())
+	}
+
+	// idempotent requests are retried until retries run out
+	calls.Store(3)
+	_, err = c.Quota(ctx)
+	if StatusCode(err) != http.StatusBadGateway || calls.Load() != 7 {
+		t.Fatalf("expected a bad gateway after 4 more calls, got %v after %d calls", err, calls.Load())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Quota(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled context, got %v", err)
+	}
+}
+
+func TestClientConcurrentRefresh(t *testing.T) {
+	var refreshes atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/user/refresh":
+			refreshes.Add(1)
+			<-release
+			w.Write([]byte(`{"access_token":"refreshed"}`))
+		case r.Header.Get("Authorization") != "Bearer refreshed":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Write([]byte(`{"user_id":1}`))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewClient(Config{BaseURL: ts.URL})
+	c.SetTokens(TokenPair{AccessToken: "expired", RefreshToken: "refresh"})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Quota(ctx)
+			errs <- err
+		}()
+	}
+
+	// the tokens can be read while the refresh is sent
+	for refreshes.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if tokens := c.Tokens(); tokens.AccessToken != "expired" {
+		t.Fatalf("expected the expired token until the refresh is done, got %q", tokens.AccessToken)
+	}
+	close(release)
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refreshes.Load() != 1 || c.Tokens().AccessToken != "refreshed" {
+		t.Fatalf("expected a single refresh, got %d and token %q", refreshes.Load(), c.Tokens().AccessToken)
+	}
+}
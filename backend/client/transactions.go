@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transaction holds a change of a balance
+type Transaction struct {
+	ID             int       `json:"id"`
+	UserID         int       `json:"user_id"`
+	OrganizationID int       `json:"organization_id"` // set if organization balance is charged
+	AdminID        int       `json:"admin_id"`        // set if credited by an admin
+	Amount         float64   `json:"amount"`
+	Memo           string    `json:"memo"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Transactions lists transactions of the logged in user
+func (c *Client) Transactions(ctx context.Context) ([]Transaction, error) {
+	var transactions []Transaction
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/transactions", result: &transactions})
+	return transactions, err
+}
+
+// OrganizationTransactions lists the ledger of an organization the user is an owner, admin or billing member of
+func (c *Client) OrganizationTransactions(ctx context.Context, organizationID int) ([]Transaction, error) {
+	var transactions []Transaction
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/organizations/current/transactions",
+		result: &transactions,
+		header: http.Header{organizationHeader: {strconv.Itoa(organizationID)}},
+	})
+	return transactions, err
+}
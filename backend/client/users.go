@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// User holds an account as returned by the API
+type User struct {
+	ID                int       `json:"id"`
+	Username          string    `json:"username"`
+	Email             string    `json:"email"`
+	Verified          bool      `json:"verified"`
+	Admin             bool      `json:"admin"`
+	CreditCardBalance float64   `json:"credit_card_balance"` // money from credit card
+	CreditedBalance   float64   `json:"credited_balance"`    // manually added by admin or from vouchers
+	Language          string    `json:"language"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Resources holds resource limits
+type Resources struct {
+	Clusters  int `json:"clusters"`
+	Nodes     int `json:"nodes"`
+	VCPU      int `json:"vcpu"`
+	MemoryGB  int `json:"memory_gb"`
+	DiskGB    int `json:"disk_gb"`
+	PublicIPs int `json:"public_ips"`
+}
+
+// Quota holds resource limits of a user
+type Quota struct {
+	UserID     int       `json:"user_id"`
+	Limits     Resources `json:"limits"`
+	Overridden bool      `json:"overridden"`
+}
+
+// QuotaOverride holds resource limits set for a user by an admin
+type QuotaOverride struct {
+	UserID int `json:"user_id"`
+	Resources
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuditLog holds an audited request of a user
+type AuditLog struct {
+	ID         int       `json:"id"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target"`
+	SourceIP   string    `json:"source_ip"`
+	StatusCode int       `json:"status_code"`
+	Outcome    string    `json:"outcome"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UserExport holds all data stored about a user
+type UserExport struct {
+	ExportedAt   time.Time      `json:"exported_at"`
+	User         User           `json:"user"`
+	Transactions []Transaction  `json:"transactions"`
+	Quota        *QuotaOverride `json:"quota,omitempty"`
+	AuditLogs    []AuditLog     `json:"audit_logs"`
+}
+
+// ChangePassword changes password of the logged in user
+func (c *Client) ChangePassword(ctx context.Context, password string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/change_password",
+		body:   map[string]string{"password": password, "confirm_password": password},
+	})
+}
+
+// SetLanguage changes the preferred language of the logged in user
+func (c *Client) SetLanguage(ctx context.Context, language string) error {
+	return c.do(ctx, request{method: http.MethodPut, path: "/user/language", body: map[string]string{"language": language}})
+}
+
+// Quota gets resource limits of the logged in user
+func (c *Client) Quota(ctx context.Context) (Quota, error) {
+	var quota Quota
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/quota", result: &quota})
+	return quota, err
+}
+
+// Export gets all data stored about the logged in user
+func (c *Client) Export(ctx context.Context) (UserExport, error) {
+	var export UserExport
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/me/export", result: &export})
+	return export, err
+}
+
+// ListUsers lists all users, admins only
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user", result: &users})
+	return users, err
+}
+
+// DeleteUser deletes a user, admins only
+func (c *Client) DeleteUser(ctx context.Context, userID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/user/%d", userID)})
+}
+
+// CreditUser adds amount to balance of a user, admins only
+func (c *Client) CreditUser(ctx context.Context, userID int, amount float64, memo string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   fmt.Sprintf("/user/%d/credit", userID),
+		body:   map[string]interface{}{"amount": amount, "memo": memo},
+	})
+}
+
+// UserQuota gets resource limits of a user, admins only
+func (c *Client) UserQuota(ctx context.Context, userID int) (Quota, error) {
+	var quota Quota
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/user/%d/quota", userID), result: &quota})
+	return quota, err
+}
+
+// SetUserQuota overrides resource limits of a user, admins only
+func (c *Client) SetUserQuota(ctx context.Context, userID int, limits Resources) (Quota, error) {
+	var quota Quota
+	err := c.do(ctx, request{method: http.MethodPut, path: fmt.Sprintf("/user/%d/quota", userID), body: limits, result: &quota})
+	return quota, err
+}
+
+// ResetUserQuota resets resource limits of a user to defaults, admins only
+func (c *Client) ResetUserQuota(ctx context.Context, userID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/user/%d/quota", userID)})
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Voucher holds a voucher to redeem for credit
+type Voucher struct {
+	ID        int       `json:"id"`
+	Voucher   string    `json:"voucher"`
+	Value     float64   `json:"value"`
+	Redeemed  bool      `json:"redeemed"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GenerateVouchers generates count vouchers of value each, expiring after the given days, admins only
+func (c *Client) GenerateVouchers(ctx context.Context, count int, value float64, expireAfterDays int) ([]Voucher, error) {
+	var result struct {
+		Vouchers []Voucher `json:"vouchers"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/vouchers/generate",
+		body:   map[string]interface{}{"count": count, "value": value, "expire_after_days": expireAfterDays},
+		result: &result,
+	})
+	return result.Vouchers, err
+}
+
+// ListVouchers lists all vouchers, admins only
+func (c *Client) ListVouchers(ctx context.Context) ([]Voucher, error) {
+	var vouchers []Voucher
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/vouchers", result: &vouchers})
+	return vouchers, err
+}
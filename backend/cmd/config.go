@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kubecloud/internal"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultConfigFile = "./config.json"
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+	Long: "Inspect the configuration loaded from defaults, the configuration file and KUBECLOUD_* environment variables.\n" +
+		"Any variable can be set as <variable>_FILE with the path of a file holding its value, like a mounted secret.",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Short:        "Validate the configuration",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := loadConfiguration(cmd); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+		return nil
+	},
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:          "print",
+	Short:        "Print the configuration as JSON with secrets redacted, before validation",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configPath(cmd)
+		if err != nil {
+			return err
+		}
+
+		config, err := internal.ReadConfiguration(path)
+		if err != nil {
+			return err
+		}
+
+		showSecrets, err := cmd.Flags().GetBool("show-secrets")
+		if err != nil {
+			return err
+		}
+		if !showSecrets {
+			config = internal.RedactConfiguration(config)
+		}
+
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(config)
+	},
+}
+
+var configEnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "List environment variables overriding the configuration",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, variable := range internal.ConfigEnvVariables() {
+			fmt.Fprintln(cmd.OutOrStdout(), variable)
+		}
+	},
+}
+
+// configPath returns the configuration file to read, empty if the default file is used and doesn't exist
+func configPath(cmd *cobra.Command) (string, error) {
+	path, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return "", fmt.Errorf("failed to parse config flag: %w", err)
+	}
+
+	if !cmd.Flags().Changed("config") {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+	}
+
+	return path, nil
+}
+
+// loadConfiguration loads and validates the configuration
+func loadConfiguration(cmd *cobra.Command) (internal.Configuration, error) {
+	path, err := configPath(cmd)
+	if err != nil {
+		return internal.Configuration{}, err
+	}
+
+	return internal.LoadConfiguration(path)
+}
+
+func init() {
+	configPrintCmd.Flags().Bool("show-secrets", false, "Print secrets like the JWT secret and API keys instead of redacting them")
+
+	configCmd.AddCommand(configValidateCmd, configPrintCmd, configEnvCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"kubecloud/app"
 	"kubecloud/internal"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -22,48 +21,76 @@ var rootCmd = &cobra.Command{
 	Short: "This is short description!",
 	Long:  "This is long description!",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		configFile, err := cmd.Flags().GetString("config")
+		path, err := configPath(cmd)
 		if err != nil {
-			return fmt.Errorf("failed to parse config: %w", err)
+			return err
 		}
 
-		config, err := internal.ReadConfFile(configFile)
+		config, err := internal.LoadConfiguration(path)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to read configurations file")
-			return fmt.Errorf("failed to read configuration file: %w", err)
+			log.Error().Err(err).Msg("Failed to load configuration")
+			return err
 		}
 
 		app, err := app.NewApp(config)
 		if err != nil {
 			return fmt.Errorf("failed to create new app: %w", err)
 		}
+		app.WatchConfig(path)
 
-		return gracefulShutdown(app)
+		timeout := time.Duration(config.Server.ShutdownTimeoutSeconds) * time.Second
+		if timeout == 0 {
+			timeout = defaultShutdownTimeout
+		}
+
+		return gracefulShutdown(app, timeout)
 	},
 }
 
-func gracefulShutdown(app *app.App) error {
+const defaultShutdownTimeout = 25 * time.Second
+
+func gracefulShutdown(app *app.App, timeout time.Duration) error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
 	go func() {
-		log.Info().Msg("Starting KubeCloud server")
-
-		if err := app.Run(); err != nil && err != http.ErrServerClosed {
-			log.Error().Err(err).Msg("Failed to start server")
-			stop()
+		for range reload {
+			if err := app.ReloadConfig(); err != nil {
+				log.Error().Err(err).Msg("Failed to reload configuration")
+			}
 		}
 	}()
 
-	<-ctx.Done()
+	runErr := make(chan error, 1)
+	go func() {
+		log.Info().Msg("Starting KubeCloud server")
+		runErr <- app.Run()
+	}()
+
+	// a server failing to start still shuts down the jobs, then exits with its error
+	var startErr error
+	select {
+	case <-ctx.Done():
+	case startErr = <-runErr:
+		log.Error().Err(startErr).Msg("Failed to start server")
+		startErr = fmt.Errorf("failed to start server: %w", startErr)
+	}
 	log.Info().Msg("Shutting down server...")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	if err := app.Shutdown(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("Server shutdown failed")
-		return err
+		if startErr == nil {
+			return err
+		}
+	}
+	if startErr != nil {
+		return startErr
 	}
 
 	log.Info().Msg("Server gracefully stopped.")
@@ -72,6 +99,8 @@ func gracefulShutdown(app *app.App) error {
 
 func Execute() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	// contexts without a request logger still log
+	zerolog.DefaultContextLogger = &log.Logger
 
 	err := rootCmd.Execute()
 	if err != nil {
@@ -81,5 +110,5 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringP("config", "c", "./config.json", "Path to the configuration file (default: ./config.json)")
+	rootCmd.PersistentFlags().StringP("config", "c", defaultConfigFile, "Path to the JSON or YAML configuration file, the default file is optional")
 }
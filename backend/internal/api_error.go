@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is a stable code clients can switch on, messages may change but codes don't
+type ErrorCode string
+
+const (
+	// ErrCodeInvalidRequest is for malformed requests like invalid JSON or path parameters
+	ErrCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrCodeValidationFailed is for requests failing validation, details lists failed fields
+	ErrCodeValidationFailed ErrorCode = "validation_failed"
+	// ErrCodeUnauthorized is for requests without credentials
+	ErrCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrCodeInvalidToken is for invalid or expired access, refresh and personal access tokens
+	ErrCodeInvalidToken ErrorCode = "invalid_token"
+	// ErrCodeInvalidCredentials is for a wrong email or password on login
+	ErrCodeInvalidCredentials ErrorCode = "invalid_credentials"
+	// ErrCodeInsufficientScope is for personal access tokens missing the scope of the request
+	ErrCodeInsufficientScope ErrorCode = "insufficient_scope"
+	// ErrCodeForbidden is for users not allowed to do the request
+	ErrCodeForbidden ErrorCode = "forbidden"
+	// ErrCodeChallengeFailed is for a missing or wrong challenge response
+	ErrCodeChallengeFailed ErrorCode = "challenge_failed"
+	// ErrCodeCSRFFailed is for requests of a cookie session without the CSRF token in the X-CSRF-Token header
+	ErrCodeCSRFFailed ErrorCode = "csrf_failed"
+	// ErrCodeNotFound is for missing resources
+	ErrCodeNotFound ErrorCode = "not_found"
+	// ErrCodeUserNotFound is for unknown users, including unknown emails
+	ErrCodeUserNotFound ErrorCode = "user_not_found"
+	// ErrCodeConflict is for requests conflicting with the current state
+	ErrCodeConflict ErrorCode = "conflict"
+	// ErrCodeUserExists is for registering an email that is already registered
+	ErrCodeUserExists ErrorCode = "user_exists"
+	// ErrCodeInvalidCode is for a wrong verification code
+	ErrCodeInvalidCode ErrorCode = "invalid_code"
+	// ErrCodeCodeExpired is for an expired verification code
+	ErrCodeCodeExpired ErrorCode = "code_expired"
+	// ErrCodeRateLimited is for requests over the rate limit, see the Retry-After header
+	ErrCodeRateLimited ErrorCode = "rate_limited"
+	// ErrCodeInternal is for unexpected server failures
+	ErrCodeInternal ErrorCode = "internal_error"
+	// ErrCodeUpstream is for failures of services the server depends on
+	ErrCodeUpstream ErrorCode = "upstream_error"
+	// ErrCodeUnavailable is for requests that can't be handled now and should be retried later
+	ErrCodeUnavailable ErrorCode = "service_unavailable"
+)
+
+// ErrorCodes lists all error codes
+var ErrorCodes = []ErrorCode{
+	ErrCodeInvalidRequest, ErrCodeValidationFailed, ErrCodeUnauthorized, ErrCodeInvalidToken, ErrCodeInvalidCredentials,
+	ErrCodeInsufficientScope, ErrCodeForbidden, ErrCodeChallengeFailed, ErrCodeCSRFFailed, ErrCodeNotFound,
+	ErrCodeUserNotFound, ErrCodeConflict, ErrCodeUserExists, ErrCodeInvalidCode, ErrCodeCodeExpired, ErrCodeRateLimited,
+	ErrCodeInternal, ErrCodeUpstream, ErrCodeUnavailable,
+}
+
+// APIError is the body of every failed request, wrapped in an "error" field
+type APIError struct {
+	Status    int          `json:"-"`
+	Code      ErrorCode    `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError describes a field failing validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// NewAPIError creates a new API error
+func NewAPIError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// ErrInternal is returned for unexpected failures, their cause is logged and not shown to clients
+var ErrInternal = NewAPIError(http.StatusInternalServerError, ErrCodeInternal, "internal server error")
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"kubecloud/models"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// APITokenPrefix marks personal access tokens apart from JWTs
+const APITokenPrefix = "kc_"
+
+// lastUsedPrecision limits how often last used time of a token is written
+const lastUsedPrecision = time.Minute
+
+var (
+	// ErrInvalidAPIToken is returned for unknown, revoked or expired tokens
+	ErrInvalidAPIToken = errors.New("invalid, revoked or expired api token")
+)
+
+// APITokens creates and verifies personal access tokens
+type APITokens struct {
+	db models.DB
+}
+
+// NewAPITokens creates a new personal access tokens manager
+func NewAPITokens(db models.DB) *APITokens {
+	return &APITokens{db: db}
+}
+
+// IsAPIToken reports whether token is a personal access token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// HashAPIToken hashes token for storage and lookup
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a new token for user, the plain token is only returned here
+func (t *APITokens) Create(userID int, name string, scopes []string, expiresAt *time.Time) (string, models.APIToken, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", models.APIToken{}, err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	apiToken := models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(APITokenPrefix)+6],
+		Hash:      HashAPIToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	if err := t.db.CreateAPIToken(&apiToken); err != nil {
+		return "", models.APIToken{}, fmt.Errorf("failed to store api token: %w", err)
+	}
+
+	return token, apiToken, nil
+}
+
+// Verify returns the token and its owner if token is valid, and records its usage
+func (t *APITokens) Verify(token string) (models.APIToken, models.User, error) {
+	apiToken, err := t.db.GetAPITokenByHash(HashAPIToken(token))
+	if err != nil {
+		return models.APIToken{}, models.User{}, ErrInvalidAPIToken
+	}
+
+	now := time.Now()
+	if apiToken.RevokedAt != nil || (apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(now)) {
+		return models.APIToken{}, models.User{}, ErrInvalidAPIToken
+	}
+
+	user, err := t.db.GetUserByID(apiToken.UserID)
+	if err != nil {
+		return models.APIToken{}, models.User{}, ErrInvalidAPIToken
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > lastUsedPrecision {
+		if err := t.db.UpdateAPITokenLastUsed(apiToken.ID, now); err != nil {
+			log.Error().Err(err).Int("token_id", apiToken.ID).Msg("failed to update api token last used time")
+		}
+	}
+
+	return apiToken, user, nil
+}
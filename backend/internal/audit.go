@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kubecloud/models"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// AuditOutcomeSuccess is the outcome of actions that succeeded
+	AuditOutcomeSuccess = "success"
+	// AuditOutcomeFailure is the outcome of actions that were rejected or failed
+	AuditOutcomeFailure = "failure"
+)
+
+// auditRedactedFields are request body fields holding credentials, they are left out of payload digests
+var auditRedactedFields = []string{"password", "code", "token", "secret"}
+
+// auditHeadID is the ID of the single audit log head
+const auditHeadID = 1
+
+// Auditor appends entries to the audit log, optionally chaining their hashes
+type Auditor struct {
+	db        models.DB
+	hashChain bool
+	digestKey []byte
+	mu        sync.Mutex
+}
+
+// NewAuditor creates a new auditor, payload digests are keyed with a key derived from secret
+func NewAuditor(db models.DB, hashChain bool, secret string) *Auditor {
+	key := sha256.Sum256([]byte("kubecloud audit payload digest|" + secret))
+	return &Auditor{
+		db:        db,
+		hashChain: hashChain,
+		digestKey: key[:],
+	}
+}
+
+// PayloadDigest returns an HMAC of a request body, so readers of the audit log can match payloads without
+// guessing them offline. Fields of JSON bodies holding credentials are redacted first.
+func (a *Auditor) PayloadDigest(body []byte) string {
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if redacted, err := json.Marshal(redactAuditPayload(payload)); err == nil {
+			body = redacted
+		}
+	}
+
+	return a.digest(body)
+}
+
+func (a *Auditor) digest(data []byte) string {
+	mac := hmac.New(sha256.New, a.digestKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func redactAuditPayload(payload interface{}) interface{} {
+	switch value := payload.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if auditRedactedField(key) {
+				value[key] = "REDACTED"
+				continue
+			}
+			value[key] = redactAuditPayload(field)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactAuditPayload(item)
+		}
+	}
+	return payload
+}
+
+func auditRedactedField(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range auditRedactedFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record appends an entry to the audit log. Chained entries are appended in a database transaction so
+// replicas sharing the database can't fork the chain, the mutex only saves entries of this process from
+// conflicting with each other.
+func (a *Auditor) Record(ctx context.Context, entry models.AuditLog) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	db := a.db.WithContext(ctx)
+
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if entry.SourceIP != "" {
+		entry.SourceIPDigest = a.digest([]byte(entry.SourceIP))
+	}
+
+	if !a.hashChain {
+		return db.CreateAuditLog(&entry)
+	}
+
+	return db.Transaction(func(tx models.DB) error {
+		last, err := tx.LastChainedAuditLog()
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to get last audit log: %w", err)
+		}
+
+		entry.PrevHash = last.Hash
+		entry.Hash = AuditLogHash(entry)
+		if err := tx.CreateAuditLog(&entry); err != nil {
+			return err
+		}
+
+		return tx.SaveAuditHead(&models.AuditHead{ID: auditHeadID, EntryID: entry.ID, Hash: entry.Hash, MAC: a.headMAC(entry.ID, entry.Hash)})
+	})
+}
+
+func (a *Auditor) headMAC(entryID int, hash string) string {
+	return a.digest([]byte(fmt.Sprintf("%d|%s", entryID, hash)))
+}
+
+// AuditVerification is the result of checking the audit log hash chain
+type AuditVerification struct {
+	Entries   int  // entries checked
+	BrokenID  int  // ID of the first entry breaking the chain
+	Truncated bool // the chain doesn't end at its head, entries were removed from its end or the head was forged
+}
+
+// Valid reports whether the chain is intact up to its head
+func (v AuditVerification) Valid() bool {
+	return v.BrokenID == 0 && !v.Truncated
+}
+
+// VerifyChain checks the audit log hash chain, reading batchSize entries at a time, and that it ends at the head.
+// Entries recorded after the head was read are left for the next check.
+func (a *Auditor) VerifyChain(ctx context.Context, batchSize int) (AuditVerification, error) {
+	db := a.db.WithContext(ctx)
+
+	head, err := db.GetAuditHead()
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return AuditVerification{}, fmt.Errorf("failed to get audit log head: %w", err)
+	}
+	anchored := err == nil
+
+	var verification AuditVerification
+	prevHash, lastID := "", 0
+	for afterID := 0; ; {
+		entries, err := db.ListAuditLogs(models.AuditFilter{AfterID: afterID, Limit: batchSize})
+		if err != nil {
+			return AuditVerification{}, fmt.Errorf("failed to list audit logs: %w", err)
+		}
+
+		for _, entry := range entries {
+			if anchored && entry.ID > head.EntryID {
+				break
+			}
+			verification.Entries++
+
+			if entry.Hash == "" {
+				continue
+			}
+			if entry.PrevHash != prevHash || AuditLogHash(entry) != entry.Hash {
+				verification.BrokenID = entry.ID
+				return verification, nil
+			}
+			prevHash, lastID = entry.Hash, entry.ID
+		}
+
+		if len(entries) < batchSize || (anchored && entries[len(entries)-1].ID >= head.EntryID) {
+			break
+		}
+		afterID = entries[len(entries)-1].ID
+	}
+
+	if !anchored {
+		// chained entries are recorded along with the head, a chain without one lost it
+		verification.Truncated = prevHash != ""
+		return verification, nil
+	}
+
+	verification.Truncated = lastID != head.EntryID || prevHash != head.Hash ||
+		!hmac.Equal([]byte(head.MAC), []byte(a.headMAC(head.EntryID, head.Hash)))
+	return verification, nil
+}
+
+// AuditLogHash computes the hash of an audit log entry chained to its previous hash. It covers the source IP
+// digest instead of the IP if set, so IPs of anonymized users can be wiped without breaking the chain.
+func AuditLogHash(entry models.AuditLog) string {
+	sourceIP := entry.SourceIP
+	if entry.SourceIPDigest != "" {
+		sourceIP = entry.SourceIPDigest
+	}
+
+	content := fmt.Sprintf(
+		"%s|%d|%s|%s|%s|%s|%d|%s|%d",
+		entry.PrevHash, entry.ActorID, entry.Action, entry.Target, entry.PayloadDigest,
+		sourceIP, entry.StatusCode, entry.Outcome, entry.CreatedAt.UnixMicro(),
+	)
+
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain checks hashes of ordered audit log entries, returning the ID of the first broken entry.
+// Entries recorded before hash chaining was enabled are skipped.
+func VerifyAuditChain(entries []models.AuditLog) (int, bool) {
+	prevHash := ""
+	for _, entry := range entries {
+		if entry.Hash == "" {
+			continue
+		}
+
+		if entry.PrevHash != prevHash || AuditLogHash(entry) != entry.Hash {
+			return entry.ID, false
+		}
+		prevHash = entry.Hash
+	}
+
+	return 0, true
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"kubecloud/models"
+	"kubecloud/models/sqlite"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	gormsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAuditPayloadDigest(t *testing.T) {
+	auditor := NewAuditor(nil, false, "secret")
+
+	login := auditor.PayloadDigest([]byte(`{"email":"user@kubecloud.io","password":"first"}`))
+	if login != auditor.PayloadDigest([]byte(`{"email":"user@kubecloud.io","password":"second"}`)) {
+		t.Fatal("expected passwords to be left out of the digest")
+	}
+	if login == auditor.PayloadDigest([]byte(`{"email":"other@kubecloud.io","password":"first"}`)) {
+		t.Fatal("expected other fields to change the digest")
+	}
+
+	body := []byte(`{"count":1}`)
+	sum := sha256.Sum256(body)
+	if auditor.PayloadDigest(body) == hex.EncodeToString(sum[:]) {
+		t.Fatal("expected the digest to be keyed")
+	}
+	if auditor.PayloadDigest(body) == NewAuditor(nil, false, "other secret").PayloadDigest(body) {
+		t.Fatal("expected the digest to depend on the secret")
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	// two auditors stand for replicas sharing the database
+	replicas := []*Auditor{NewAuditor(db, true, "secret"), NewAuditor(db, true, "secret")}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(auditor *Auditor) {
+			defer wg.Done()
+			if err := auditor.Record(context.Background(), models.AuditLog{Action: "user.login", StatusCode: 201, Outcome: AuditOutcomeSuccess}); err != nil {
+				t.Error(err)
+			}
+		}(replicas[i%2])
+	}
+	wg.Wait()
+
+	entries, err := db.ListAuditLogs(models.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("expected 10 entries, got %d", len(entries))
+	}
+	if brokenID, valid := VerifyAuditChain(entries); !valid {
+		t.Fatalf("expected the chain to be intact, broken at %d", brokenID)
+	}
+
+	entries[4].Target = "user_id=1"
+	if brokenID, valid := VerifyAuditChain(entries); valid || brokenID != entries[4].ID {
+		t.Fatalf("expected a tampered entry to break the chain at %d, got %d", entries[4].ID, brokenID)
+	}
+
+	entries[4].Target = ""
+	entries = append(entries[:6], entries[7:]...)
+	if brokenID, valid := VerifyAuditChain(entries); valid || brokenID != entries[6].ID {
+		t.Fatalf("expected a removed entry to break the chain at %d, got %d", entries[6].ID, brokenID)
+	}
+}
+
+func TestAuditChainHead(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "db.sqlite")
+	db, err := sqlite.NewSqliteStorage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	auditor := NewAuditor(db, true, "secret")
+	for i := 0; i < 7; i++ {
+		if err := auditor.Record(context.Background(), models.AuditLog{Action: "user.login", StatusCode: 201, Outcome: AuditOutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// batches smaller than the log check that the chain carries over between them
+	verification, err := auditor.VerifyChain(context.Background(), 3)
+	if err != nil || !verification.Valid() || verification.Entries != 7 {
+		t.Fatalf("expected the chain of 7 entries to be intact, got %+v %v", verification, err)
+	}
+
+	conn, err := gorm.Open(gormsqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Where("id > ?", 5).Delete(&models.AuditLog{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	verification, err = auditor.VerifyChain(context.Background(), 3)
+	if err != nil || verification.Valid() || !verification.Truncated {
+		t.Fatalf("expected entries removed from the end to be noticed, got %+v %v", verification, err)
+	}
+
+	// moving the head back needs the secret
+	head, err := db.GetAuditHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := db.LastChainedAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.EntryID, head.Hash = last.ID, last.Hash
+	if err := db.SaveAuditHead(&head); err != nil {
+		t.Fatal(err)
+	}
+	if verification, err := auditor.VerifyChain(context.Background(), 3); err != nil || verification.Valid() {
+		t.Fatalf("expected a head moved without the secret to be noticed, got %+v %v", verification, err)
+	}
+
+	head.MAC = NewAuditor(db, true, "other secret").headMAC(last.ID, last.Hash)
+	if err := db.SaveAuditHead(&head); err != nil {
+		t.Fatal(err)
+	}
+	if verification, err := auditor.VerifyChain(context.Background(), 3); err != nil || verification.Valid() {
+		t.Fatalf("expected a head signed with another secret to be noticed, got %+v %v", verification, err)
+	}
+}
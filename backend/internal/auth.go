@@ -21,6 +21,11 @@ func HashAndSaltPassword(password []byte) ([]byte, error) {
 
 // VerifyPassword checks if given password is same as hashed one
 func VerifyPassword(hashedPassword []byte, password string) bool {
+	// users signing on with a provider have no password
+	if len(hashedPassword) < saltLen {
+		return false
+	}
+
 	hashedPasswordCopy := make([]byte, len(hashedPassword))
 
 	copy(hashedPasswordCopy, hashedPassword)
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ChallengeProviderNone lets every request through, meant for development and tests
+	ChallengeProviderNone = "none"
+	// ChallengeProviderHCaptcha verifies hCaptcha responses
+	ChallengeProviderHCaptcha = "hcaptcha"
+	// ChallengeProviderTurnstile verifies Cloudflare Turnstile responses
+	ChallengeProviderTurnstile = "turnstile"
+	// ChallengeProviderPoW verifies self-hosted proof of work, it needs no external service
+	ChallengeProviderPoW = "pow"
+
+	hCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+	defaultChallengeTimeout = 10 * time.Second
+)
+
+// ErrChallengeFailed is returned for missing, wrong or reused challenge responses
+var ErrChallengeFailed = errors.New("challenge verification failed")
+
+// ChallengeVerifier verifies a challenge solved by a client before sensitive public requests
+type ChallengeVerifier interface {
+	Provider() string
+	Verify(ctx context.Context, response, remoteIP string) error
+}
+
+// ChallengeReleaser is implemented by verifiers remembering used challenges, a challenge of a request refused
+// for another reason is released so the client can send it again
+type ChallengeReleaser interface {
+	Release(response string)
+}
+
+// ChallengeIssuer is implemented by verifiers handing out their own challenges
+type ChallengeIssuer interface {
+	Issue() (IssuedChallenge, error)
+}
+
+// IssuedChallenge is a challenge a client has to solve
+type IssuedChallenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// NewChallengeVerifier creates the verifier of the configured provider, proof of work challenges are signed
+// with a key derived from secret unless the config has its own secret key
+func NewChallengeVerifier(config Challenge, secret string) (ChallengeVerifier, error) {
+	switch config.Provider {
+	case "", ChallengeProviderNone:
+		return NoChallenge{}, nil
+
+	case ChallengeProviderHCaptcha, ChallengeProviderTurnstile:
+		if config.SecretKey == "" {
+			return nil, fmt.Errorf("%s challenge requires secret_key", config.Provider)
+		}
+
+		verifyURL := config.VerifyURL
+		if verifyURL == "" {
+			verifyURL = hCaptchaVerifyURL
+			if config.Provider == ChallengeProviderTurnstile {
+				verifyURL = turnstileVerifyURL
+			}
+		}
+
+		return &SiteVerifier{
+			provider:  config.Provider,
+			secret:    config.SecretKey,
+			siteKey:   config.SiteKey,
+			verifyURL: verifyURL,
+			client:    &http.Client{Timeout: defaultChallengeTimeout},
+		}, nil
+
+	case ChallengeProviderPoW:
+		key := config.SecretKey
+		if key == "" {
+			derived := sha256.Sum256([]byte("kubecloud pow challenge|" + secret))
+			key = hex.EncodeToString(derived[:])
+		}
+		return NewProofOfWork(key, config.Difficulty), nil
+	}
+
+	return nil, fmt.Errorf("unknown challenge provider %q", config.Provider)
+}
+
+// NoChallenge accepts every request
+type NoChallenge struct{}
+
+// Provider returns name of the provider
+func (NoChallenge) Provider() string {
+	return ChallengeProviderNone
+}
+
+// Verify accepts any response
+func (NoChallenge) Verify(ctx context.Context, response, remoteIP string) error {
+	return nil
+}
+
+// SiteVerifier verifies captcha responses with a siteverify API as used by hCaptcha and Turnstile
+type SiteVerifier struct {
+	provider  string
+	secret    string
+	siteKey   string
+	verifyURL string
+	client    *http.Client
+}
+
+// Provider returns name of the provider
+func (v *SiteVerifier) Provider() string {
+	return v.provider
+}
+
+// Verify asks the provider whether response is valid
+func (v *SiteVerifier) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return ErrChallengeFailed
+	}
+
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {response},
+		"remoteip": {remoteIP},
+	}
+	if v.siteKey != "" {
+		form.Set("sitekey", v.siteKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to verify challenge: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode challenge verification: %w", err)
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrChallengeFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
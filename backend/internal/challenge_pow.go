@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPoWDifficulty = 20
+	powChallengeTimeout  = 5 * time.Minute
+)
+
+// ProofOfWork issues stateless signed challenges, a client solves one by finding a nonce so that
+// sha256("<challenge>:<nonce>") starts with difficulty zero bits, then sends "<challenge>:<nonce>".
+// Solved challenges are only remembered by the replica verifying them, so with several replicas
+// a solution can be used once per replica until it expires.
+type ProofOfWork struct {
+	secret     []byte
+	difficulty int
+
+	mu   sync.Mutex
+	used map[string]time.Time // solved challenges by expiry, so they can't be reused
+}
+
+// NewProofOfWork creates a new proof of work verifier
+func NewProofOfWork(secret string, difficulty int) *ProofOfWork {
+	if difficulty == 0 {
+		difficulty = defaultPoWDifficulty
+	}
+
+	return &ProofOfWork{
+		secret:     []byte(secret),
+		difficulty: difficulty,
+		used:       map[string]time.Time{},
+	}
+}
+
+// Provider returns name of the provider
+func (p *ProofOfWork) Provider() string {
+	return ChallengeProviderPoW
+}
+
+// Issue creates a new challenge formatted as "<expiry>.<difficulty>.<random>.<signature>"
+func (p *ProofOfWork) Issue() (IssuedChallenge, error) {
+	random, err := randomURLString(16)
+	if err != nil {
+		return IssuedChallenge{}, err
+	}
+
+	expiresAt := time.Now().Add(powChallengeTimeout)
+	payload := fmt.Sprintf("%d.%d.%s", expiresAt.Unix(), p.difficulty, random)
+
+	return IssuedChallenge{
+		Challenge:  payload + "." + p.sign(payload),
+		Difficulty: p.difficulty,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// Verify checks the challenge is issued here, not expired nor used and solved with enough work
+func (p *ProofOfWork) Verify(ctx context.Context, response, remoteIP string) error {
+	challenge, _, found := strings.Cut(response, ":")
+	if !found {
+		return ErrChallengeFailed
+	}
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return ErrChallengeFailed
+	}
+
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(payload))) {
+		return ErrChallengeFailed
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrChallengeFailed
+	}
+	expiresAt := time.Unix(expiry, 0)
+
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ErrChallengeFailed
+	}
+
+	now := time.Now()
+	if now.After(expiresAt) || leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+		return ErrChallengeFailed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for used, usedExpiry := range p.used {
+		if now.After(usedExpiry) {
+			delete(p.used, used)
+		}
+	}
+
+	if _, ok := p.used[challenge]; ok {
+		return ErrChallengeFailed
+	}
+	p.used[challenge] = expiresAt
+
+	return nil
+}
+
+// Release forgets that the challenge of response was used, the request it came with was refused
+func (p *ProofOfWork) Release(response string) {
+	challenge, _, _ := strings.Cut(response, ":")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.used, challenge)
+}
+
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solveChallenge finds a nonce giving challenge difficulty leading zero bits
+func solveChallenge(challenge string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		response := challenge + ":" + strconv.Itoa(nonce)
+		if leadingZeroBits(sha256.Sum256([]byte(response))) >= difficulty {
+			return response
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	pow := NewProofOfWork("secret", 8)
+	ctx := context.Background()
+
+	issued, err := pow.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Difficulty != 8 || !issued.ExpiresAt.After(time.Now()) {
+		t.Fatalf("unexpected challenge %+v", issued)
+	}
+
+	response := solveChallenge(issued.Challenge, issued.Difficulty)
+	if err := pow.Verify(ctx, response, ""); err != nil {
+		t.Fatalf("expected the solved challenge to be accepted, got %v", err)
+	}
+	if err := pow.Verify(ctx, response, ""); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("expected a replayed solution to be refused, got %v", err)
+	}
+
+	// another solution of a used challenge is a replay too
+	issued, _ = pow.Issue()
+	response = solveChallenge(issued.Challenge, issued.Difficulty)
+	if err := pow.Verify(ctx, response, ""); err != nil {
+		t.Fatal(err)
+	}
+	for nonce := 0; ; nonce++ {
+		other := issued.Challenge + ":" + strconv.Itoa(nonce)
+		if other != response && leadingZeroBits(sha256.Sum256([]byte(other))) >= issued.Difficulty {
+			if err := pow.Verify(ctx, other, ""); !errors.Is(err, ErrChallengeFailed) {
+				t.Fatalf("expected another solution of a used challenge to be refused, got %v", err)
+			}
+			break
+		}
+	}
+
+	if err := NewProofOfWork("other", 8).Verify(ctx, solveChallenge(mustIssue(t, pow).Challenge, 8), ""); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("expected a challenge issued with another secret to be refused, got %v", err)
+	}
+	for _, response := range []string{"", "no nonce", "a.b.c:1"} {
+		if err := pow.Verify(ctx, response, ""); !errors.Is(err, ErrChallengeFailed) {
+			t.Errorf("expected %q to be refused, got %v", response, err)
+		}
+	}
+
+	hard := NewProofOfWork("secret", 24)
+	if err := hard.Verify(ctx, mustIssue(t, hard).Challenge+":unsolved", ""); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("expected an unsolved challenge to be refused, got %v", err)
+	}
+}
+
+func mustIssue(t *testing.T, pow *ProofOfWork) IssuedChallenge {
+	t.Helper()
+
+	issued, err := pow.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issued
+}
+
+func TestProofOfWorkExpired(t *testing.T) {
+	pow := NewProofOfWork("secret", 8)
+
+	// signed like an issued challenge that expired a second ago
+	payload := fmt.Sprintf("%d.%d.%s", time.Now().Add(-time.Second).Unix(), 8, "random")
+	challenge := payload + "." + pow.sign(payload)
+
+	if err := pow.Verify(context.Background(), solveChallenge(challenge, 8), ""); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("expected an expired challenge to be refused, got %v", err)
+	}
+
+	// used challenges are forgotten once they expire
+	pow.used[challenge] = time.Now().Add(-time.Second)
+	if err := pow.Verify(context.Background(), solveChallenge(mustIssue(t, pow).Challenge, 8), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pow.used[challenge]; ok || len(pow.used) != 1 {
+		t.Fatalf("expected expired challenges to be swept, got %v", pow.used)
+	}
+}
+
+func TestProofOfWorkTampered(t *testing.T) {
+	pow := NewProofOfWork("secret", 16)
+	parts := strings.Split(mustIssue(t, pow).Challenge, ".")
+
+	// lowering the difficulty or extending the expiry breaks the signature
+	easier := strings.Join([]string{parts[0], "1", parts[2], parts[3]}, ".")
+	later := strings.Join([]string{strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10), parts[1], parts[2], parts[3]}, ".")
+
+	for name, challenge := range map[string]string{"difficulty": easier, "expiry": later} {
+		if err := pow.Verify(context.Background(), solveChallenge(challenge, 1), ""); !errors.Is(err, ErrChallengeFailed) {
+			t.Errorf("expected a challenge with tampered %s to be refused, got %v", name, err)
+		}
+	}
+}
+
+func TestSiteVerifier(t *testing.T) {
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		form = map[string]string{"secret": r.PostForm.Get("secret"), "response": r.PostForm.Get("response"), "remoteip": r.PostForm.Get("remoteip")}
+
+		if r.PostForm.Get("response") == "valid" {
+			_, _ = w.Write([]byte(`{"success":true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	verifier, err := NewChallengeVerifier(Challenge{Provider: ChallengeProviderTurnstile, SecretKey: "secret", VerifyURL: server.URL}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifier.Verify(context.Background(), "valid", "203.0.113.1"); err != nil {
+		t.Fatalf("expected a valid response to be accepted, got %v", err)
+	}
+	if form["secret"] != "secret" || form["remoteip"] != "203.0.113.1" {
+		t.Fatalf("expected the secret and client IP to be sent, got %v", form)
+	}
+
+	err = verifier.Verify(context.Background(), "invalid", "203.0.113.1")
+	if !errors.Is(err, ErrChallengeFailed) || !strings.Contains(err.Error(), "invalid-input-response") {
+		t.Fatalf("expected an invalid response to be refused with its error codes, got %v", err)
+	}
+
+	form = nil
+	if err := verifier.Verify(context.Background(), "", ""); !errors.Is(err, ErrChallengeFailed) || form != nil {
+		t.Fatalf("expected a missing response to be refused without asking the provider, got %v", err)
+	}
+}
+
+func TestNewChallengeVerifier(t *testing.T) {
+	if verifier, err := NewChallengeVerifier(Challenge{}, "secret"); err != nil || verifier.Provider() != ChallengeProviderNone {
+		t.Fatalf("expected no challenge without a provider, got %v %v", verifier, err)
+	}
+	if provider := DefaultConfiguration().Challenge.Provider; provider != ChallengeProviderPoW {
+		t.Fatalf("expected proof of work by default, got %s", provider)
+	}
+
+	// proof of work needs no secret key of its own
+	verifier, err := NewChallengeVerifier(Challenge{Provider: ChallengeProviderPoW}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewChallengeVerifier(Challenge{Provider: ChallengeProviderPoW}, "other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Verify(context.Background(), solveChallenge(mustIssue(t, verifier.(*ProofOfWork)).Challenge, defaultPoWDifficulty), ""); !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("expected the key to be derived from the token secret, got %v", err)
+	}
+
+	for _, config := range []Challenge{
+		{Provider: ChallengeProviderHCaptcha},
+		{Provider: "captcha", SecretKey: "secret"},
+	} {
+		if _, err := NewChallengeVerifier(config, "secret"); err == nil {
+			t.Errorf("expected %+v to be refused", config)
+		}
+	}
+}
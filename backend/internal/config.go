@@ -3,25 +3,55 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"kubecloud/models"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
-	"github.com/go-playground/validator"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
 )
 
 // Configuration struct holds all configs for the app
 type Configuration struct {
-	Server     Server     `json:"server" validate:"required,dive"`
-	Database   DB         `json:"database" validate:"required"`
-	JWT        JwtToken   `json:"token" validate:"required"`
-	Admins     []string   `json:"admins"`
-	MailSender MailSender `json:"mailSender"`
-	Voucher    Voucher    `json:"voucher"`
+	Server          Server          `json:"server" validate:"required"`
+	Database        DB              `json:"database" validate:"required"`
+	JWT             JwtToken        `json:"token" validate:"required"`
+	Admins          []string        `json:"admins"` // emails of admins, checked again on every login and token refresh
+	MailSender      MailSender      `json:"mailSender"`
+	Voucher         Voucher         `json:"voucher"`
+	Quotas          Quotas          `json:"quotas"`
+	Audit           Audit           `json:"audit"`
+	Retention       Retention       `json:"retention"`
+	OIDC            OIDC            `json:"oidc"`
+	RateLimit       RateLimit       `json:"rate_limit"`
+	Challenge       Challenge       `json:"challenge"`
+	Tracing         Tracing         `json:"tracing"`
+	Metrics         Metrics         `json:"metrics"`
+	Health          Health          `json:"health"`
+	Reload          Reload          `json:"reload"`
+	TLS             TLS             `json:"tls"`
+	SecurityHeaders SecurityHeaders `json:"security_headers"`
+	CORS            CORS            `json:"cors"`
+	Session         Session         `json:"session"`
+	Webhooks        Webhooks        `json:"webhooks"`
 }
 
 // Server struct holds server's information
 type Server struct {
 	Host string `json:"host" validate:"required,hostname|ip"`
 	Port string `json:"port" validate:"required,numeric"`
+	Dev  bool   `json:"dev"` // enables development helpers like Swagger UI
+	// time given to in-flight requests and jobs on shutdown, defaults to 25 to fit the default Kubernetes grace period
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds" validate:"gte=0"`
+	// time the readiness probe fails before in-flight requests are drained, so load balancers stop sending
+	// new requests first, part of the shutdown timeout
+	ShutdownDelaySeconds int  `json:"shutdown_delay_seconds" validate:"gte=0"`
+	H2C                  bool `json:"h2c"` // serves HTTP/2 without TLS, for proxies terminating TLS and speaking HTTP/2 to the backend
+	// addresses or networks like 10.0.0.0/8 of proxies whose X-Forwarded-For header gives the client IP,
+	// none by default so clients cannot pick the IP rate limits and audit logs see
+	TrustedProxies []string `json:"trusted_proxies" validate:"dive,cidr|ip"`
 }
 
 // DB struct holds database file
@@ -31,45 +61,304 @@ type DB struct {
 
 // JWT Token struct holds info required for JWT Tokens
 type JwtToken struct {
-	Secret                   string `json:"secret" validate:"required"`
+	Secret                   string `json:"secret" validate:"required" secret:"true"`
 	AccessTokenExpiryMinutes int    `json:"access_token_expiry_minutes" validate:"required,gt=0"` // in minutes
 	RefreshTokenExpiryHours  int    `json:"refresh_token_expiry_hours" validate:"required,gt=0"`  // in hours
 }
 
-// MailSender struct to hold sender's email, password
+// MailSender struct to hold sender's email and the transport used to send mails
 type MailSender struct {
-	Email       string `json:"email" validate:"required,email"`
-	SendGridKey string `json:"sendgrid_key" validate:"required"`
-	Timeout     int    `json:"timeout" validate:"min=30"`
+	Driver       string `json:"driver" validate:"omitempty,oneof=sendgrid smtp file stdout"` // defaults to sendgrid
+	Email        string `json:"email" validate:"required,email"`
+	SendGridKey  string `json:"sendgrid_key" secret:"true"`
+	SMTP         SMTP   `json:"smtp"`
+	Directory    string `json:"directory"`     // where file driver writes .eml files
+	TemplatesDir string `json:"templates_dir"` // overrides embedded templates, laid out as <locale>/<name>.html
+	Timeout      int    `json:"timeout" validate:"min=30"`
+	MaxAttempts  int    `json:"max_attempts" validate:"gte=0"`          // before a queued mail is dead, defaults to 8
+	PollSeconds  int    `json:"poll_interval_seconds" validate:"gte=0"` // how often the outbox is checked, defaults to 5
+}
+
+// SMTP struct holds SMTP relay information
+type SMTP struct {
+	Host     string `json:"host" validate:"omitempty,hostname|ip"`
+	Port     string `json:"port" validate:"omitempty,numeric"`
+	Username string `json:"username"`
+	Password string `json:"password" secret:"true"`
+	StartTLS bool   `json:"starttls"`
+	// connects with TLS from the start like on port 465, instead of upgrading with STARTTLS
+	ImplicitTLS    bool `json:"implicit_tls"`
+	TimeoutSeconds int  `json:"timeout_seconds" validate:"gte=0"` // limits connecting and sending a mail, defaults to 30
 }
 
 type Voucher struct {
 	NameLength int `json:"name_length" validate:"required,gt=0"`
 }
 
-// ReadConfFile read configurations of json file
-func ReadConfFile(path string) (Configuration, error) {
-	config := Configuration{}
-	file, err := os.Open(path)
+// Quotas struct holds default resource limits per role, a zero limit means unlimited
+type Quotas struct {
+	User  models.Resources `json:"user"`
+	Admin models.Resources `json:"admin"`
+}
+
+// Audit struct holds audit log settings
+type Audit struct {
+	HashChain bool `json:"hash_chain"` // chain entry hashes to make tampering evident
+}
+
+// Retention struct holds how long data of deleted users is kept before anonymizing it and sent mails before deleting them
+type Retention struct {
+	DeletedUsersDays     int `json:"deleted_users_days" validate:"gte=0"`
+	SentMailsDays        int `json:"sent_mails_days" validate:"gte=0"` // defaults to 7
+	PurgeIntervalMinutes int `json:"purge_interval_minutes" validate:"gte=0"`
+}
+
+// OIDC struct holds the single sign-on provider used to log in without a password
+type OIDC struct {
+	Enabled      bool     `json:"enabled"`
+	Issuer       string   `json:"issuer" validate:"required_if=Enabled true,omitempty,url"`
+	ClientID     string   `json:"client_id" validate:"required_if=Enabled true"`
+	ClientSecret string   `json:"client_secret" secret:"true"`                                    // empty for public clients, PKCE is always used
+	RedirectURL  string   `json:"redirect_url" validate:"required_if=Enabled true,omitempty,url"` // callback registered at the provider
+	FrontendURL  string   `json:"frontend_url" validate:"omitempty,url"`                          // receives tokens in the URL fragment, JSON is returned if not set
+	Scopes       []string `json:"scopes"`                                                         // defaults to openid, email and profile
+	GroupsClaim  string   `json:"groups_claim"`                                                   // defaults to groups
+	AdminGroups  []string `json:"admin_groups"`                                                   // if set, provider groups decide who is an admin
+}
+
+// RateLimit struct holds rate limiting settings
+type RateLimit struct {
+	Disabled bool                       `json:"disabled"`
+	Store    string                     `json:"store" validate:"omitempty,oneof=memory database"` // memory by default, database shares limits between replicas
+	Policies map[string]RateLimitPolicy `json:"policies"`                                         // overrides default policies by name
+}
+
+// RateLimitPolicy is a token bucket refilled with requests every period, holding at most burst tokens
+type RateLimitPolicy struct {
+	Requests      int `json:"requests"`
+	PeriodSeconds int `json:"period_seconds"`
+	Burst         int `json:"burst"` // defaults to requests
+}
+
+// Challenge struct holds the bot challenge required on registration and password reset
+type Challenge struct {
+	Provider   string `json:"provider" validate:"omitempty,oneof=none hcaptcha turnstile pow"` // pow by default, none lets every request through, pow only stops reuse per replica
+	SiteKey    string `json:"site_key"`                                                        // handed to the frontend widget
+	SecretKey  string `json:"secret_key" secret:"true"`                                        // verifies captcha responses or signs proof of work challenges, derived from the token secret for pow if empty
+	VerifyURL  string `json:"verify_url" validate:"omitempty,url"`                             // overrides the provider verify endpoint
+	Difficulty int    `json:"difficulty" validate:"gte=0,lte=32"`                              // leading zero bits of proof of work, defaults to 20
+}
+
+// Tracing struct holds the OpenTelemetry exporter of request and database spans
+type Tracing struct {
+	Exporter    string  `json:"exporter" validate:"omitempty,oneof=none stdout otlp"` // none by default, stdout is meant for local development
+	Endpoint    string  `json:"endpoint"`                                             // OTLP HTTP collector like localhost:4318, OTEL_EXPORTER_OTLP_* variables are used if empty
+	Insecure    bool    `json:"insecure"`                                             // sends OTLP over plain HTTP
+	ServiceName string  `json:"service_name"`                                         // defaults to kubecloud
+	SampleRatio float64 `json:"sample_ratio" validate:"gte=0,lte=1"`                  // ratio of traces sampled, 0 samples all
+}
+
+// Metrics struct holds the Prometheus metrics endpoint
+type Metrics struct {
+	Enabled       bool   `json:"enabled"`
+	ListenAddress string `json:"listen_address"` // like :9090, serves /metrics apart from the API, else /metrics needs admin access
+}
+
+// Health struct holds checks of the readiness probe, the database is always checked
+type Health struct {
+	CheckMailer bool `json:"check_mailer"` // the mail provider must be reachable to be ready
+}
+
+// Reload struct holds how often the configuration source is checked for changes of reloadable keys
+type Reload struct {
+	IntervalSeconds int `json:"interval_seconds" validate:"gte=0"` // 0 only reloads on SIGHUP
+}
+
+// TLS struct holds HTTPS settings, once enabled HTTPS is served on server.port with HTTP/2
+type TLS struct {
+	Enabled      bool   `json:"enabled"`
+	CertFile     string `json:"cert_file" validate:"required_if=Enabled true ACME.Enabled false"` // checked for changes so certificates are rotated without a restart
+	KeyFile      string `json:"key_file" validate:"required_if=Enabled true ACME.Enabled false"`  // see cert_file
+	ACME         ACME   `json:"acme"`                                                             // issues certificates instead of reading them from files
+	RedirectPort string `json:"redirect_port" validate:"omitempty,numeric"`                       // like 80, redirects plain HTTP to HTTPS and answers ACME HTTP-01 challenges
+}
+
+// ACME struct holds automatic certificates from an ACME certificate authority like Let's Encrypt
+type ACME struct {
+	Enabled      bool     `json:"enabled"`
+	Domains      []string `json:"domains" validate:"required_if=Enabled true"` // certificates are only issued for these domains
+	Email        string   `json:"email" validate:"omitempty,email"`
+	CacheDir     string   `json:"cache_dir"`                              // keeps issued certificates and the account key, defaults to acme-cache
+	DirectoryURL string   `json:"directory_url" validate:"omitempty,url"` // defaults to Let's Encrypt, can point at a local stand-in like pebble
+	CAFile       string   `json:"ca_file"`                                // trusts the certificate of a local ACME server like pebble
+}
+
+// SecurityHeaders struct holds security headers of every response, an empty header is not sent
+type SecurityHeaders struct {
+	HSTSMaxAgeSeconds     int    `json:"hsts_max_age_seconds" validate:"gte=0"` // Strict-Transport-Security, only sent over HTTPS, 0 disables it
+	HSTSIncludeSubdomains bool   `json:"hsts_include_subdomains"`
+	HSTSPreload           bool   `json:"hsts_preload"`
+	ContentSecurityPolicy string `json:"content_security_policy"`
+	FrameOptions          string `json:"frame_options" validate:"omitempty,oneof=DENY SAMEORIGIN"`
+	ReferrerPolicy        string `json:"referrer_policy"`
+}
+
+// CORS struct holds which browser origins may call the API, cross origin requests are refused if no origin is allowed
+type CORS struct {
+	AllowedOrigins   []string `json:"allowed_origins"`                  // like https://app.kubecloud.io, * allows any origin without credentials
+	AllowedHeaders   []string `json:"allowed_headers"`                  // request headers allowed besides simple headers
+	ExposedHeaders   []string `json:"exposed_headers"`                  // response headers readable by browser clients
+	AllowCredentials bool     `json:"allow_credentials"`                // sends cookies cross origin, needed by the cookie session mode
+	MaxAgeSeconds    int      `json:"max_age_seconds" validate:"gte=0"` // how long browsers cache preflight responses
+}
+
+// Session struct holds the cookie session mode, an alternative to bearer tokens keeping tokens out of reach of
+// browser scripts. Tokens are set in HttpOnly cookies and changes need a CSRF token sent back in a header.
+type Session struct {
+	Enabled      bool   `json:"enabled"`       // the OIDC callback also sets cookies instead of passing tokens to frontend_url
+	CookieDomain string `json:"cookie_domain"` // like kubecloud.io to share the CSRF cookie with a frontend on another subdomain
+	SameSite     string `json:"same_site" validate:"omitempty,oneof=strict lax none"`
+	Insecure     bool   `json:"insecure" validate:"excluded_if=SameSite none"` // sends cookies over plain HTTP, for local development only, browsers refuse it with same_site none
+}
+
+// Webhooks struct holds outgoing webhook deliveries, which are refused to loopback, private and link-local addresses
+type Webhooks struct {
+	AllowedNetworks []string `json:"allowed_networks" validate:"dive,cidr"` // like 10.1.0.0/16, internal networks webhooks may still reach
+}
+
+// DefaultConfiguration returns the configuration every source is applied on, secrets and addresses of
+// external services have no default
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		Server: Server{
+			Host:                 "0.0.0.0",
+			Port:                 "8080",
+			ShutdownDelaySeconds: 5,
+		},
+		Database: DB{
+			File: "kubecloud.sqlite",
+		},
+		JWT: JwtToken{
+			AccessTokenExpiryMinutes: 15,
+			RefreshTokenExpiryHours:  24 * 7,
+		},
+		MailSender: MailSender{
+			Timeout: 300,
+		},
+		Voucher: Voucher{
+			NameLength: 10,
+		},
+		Reload: Reload{
+			IntervalSeconds: 30,
+		},
+		SecurityHeaders: SecurityHeaders{
+			HSTSMaxAgeSeconds:     365 * 24 * 60 * 60,
+			ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "no-referrer",
+		},
+		CORS: CORS{
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-ID", "X-Organization-ID", "X-Challenge-Response", "X-CSRF-Token"},
+			ExposedHeaders: []string{"X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Content-Disposition"},
+			MaxAgeSeconds:  600,
+		},
+		Session: Session{
+			SameSite: "strict",
+		},
+		Challenge: Challenge{
+			Provider: ChallengeProviderPoW,
+		},
+	}
+}
+
+// LoadConfiguration reads configuration from all sources and validates it
+func LoadConfiguration(path string) (Configuration, error) {
+	config, err := ReadConfiguration(path)
 	if err != nil {
-		return Configuration{}, fmt.Errorf("failed to open config file: %w", err)
+		return Configuration{}, err
 	}
-	defer file.Close()
 
-	dec := json.NewDecoder(file)
-	if err := dec.Decode(&config); err != nil {
-		return Configuration{}, fmt.Errorf("failed to load config: %w", err)
+	if err := ValidateConfiguration(config); err != nil {
+		return Configuration{}, err
 	}
 
-	validate := validator.New()
-	if err := validate.Struct(config); err != nil {
-		if validationErrors, ok := err.(validator.ValidationErrors); ok {
-			for _, ve := range validationErrors {
-				fmt.Printf("Validation error on field '%s': %s\n", ve.Namespace(), ve.Tag())
-			}
+	return config, nil
+}
+
+// ReadConfiguration reads configuration in layers, each overriding the previous one: defaults, the JSON or YAML
+// file at path unless path is empty, then KUBECLOUD_* environment variables, see ConfigEnvVariables.
+// The configuration is not validated.
+func ReadConfiguration(path string) (Configuration, error) {
+	config := DefaultConfiguration()
+
+	if path != "" {
+		if err := readConfigFile(path, &config); err != nil {
+			return Configuration{}, err
 		}
-		return Configuration{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := applyConfigEnv(&config, os.LookupEnv); err != nil {
+		return Configuration{}, err
 	}
 
 	return config, nil
 }
+
+// readConfigFile decodes the file at path over config, YAML files are decoded with the same keys as JSON files
+func readConfigFile(path string, config *Configuration) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var document interface{}
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if content, err = json.Marshal(document); err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(content, config); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return nil
+}
+
+// validateCORS refuses credentials for any origin, browsers would send cookies to every site
+func validateCORS(sl validator.StructLevel) {
+	cors := sl.Current().Interface().(CORS)
+	if cors.AllowCredentials && Contains(cors.AllowedOrigins, "*") {
+		sl.ReportError(cors.AllowCredentials, "allow_credentials", "AllowCredentials", "excluded_with_any_origin", "")
+	}
+}
+
+// ValidateConfiguration checks configuration against the validate tags of its fields,
+// errors name fields by their configuration key like token.secret
+func ValidateConfiguration(config Configuration) error {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return configKey(field)
+	})
+	validate.RegisterStructValidation(validateCORS, CORS{})
+
+	err := validate.Struct(config)
+	if validationErrors, ok := err.(validator.ValidationErrors); ok {
+		var failures []string
+		for _, ve := range validationErrors {
+			// namespace starts with the Configuration type name
+			key := ve.Namespace()[strings.Index(ve.Namespace(), ".")+1:]
+			failures = append(failures, fmt.Sprintf("%s failed on %s", key, ve.Tag()))
+		}
+		return fmt.Errorf("invalid configuration: %s", strings.Join(failures, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// configEnvPrefix prefixes environment variables overriding configuration
+	configEnvPrefix = "KUBECLOUD"
+	// configFileSuffix names variables holding the path of a file to read the value from, like a mounted secret
+	configFileSuffix = "_FILE"
+
+	redacted = "REDACTED"
+)
+
+// configKey returns the key of a configuration field, its JSON name, empty for fields which are not configurable
+func configKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" || !field.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// walkConfig calls fn with every configurable leaf field of value, a struct, along with its environment variable and key.
+// Keys join the keys of parent fields with dots like token.secret. Variables are named after keys, upper cased
+// and joined by underscores like KUBECLOUD_TOKEN_SECRET.
+func walkConfig(value reflect.Value, env, key string, fn func(field reflect.Value, structField reflect.StructField, env, key string) error) error {
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		name := configKey(structField)
+		if name == "" {
+			continue
+		}
+
+		field := value.Field(i)
+		fieldEnv := env + "_" + strings.ToUpper(name)
+		fieldKey := name
+		if key != "" {
+			fieldKey = key + "." + name
+		}
+
+		if field.Kind() == reflect.Struct {
+			if err := walkConfig(field, fieldEnv, fieldKey, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fn(field, structField, fieldEnv, fieldKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConfigEnvVariables lists the environment variables overriding configuration, sorted by name.
+// Each variable can also be set as <variable>_FILE with the path of a file holding the value.
+func ConfigEnvVariables() []string {
+	var variables []string
+	_ = walkConfig(reflect.ValueOf(&Configuration{}).Elem(), configEnvPrefix, "", func(_ reflect.Value, _ reflect.StructField, env, _ string) error {
+		variables = append(variables, env)
+		return nil
+	})
+
+	sort.Strings(variables)
+	return variables
+}
+
+// applyConfigEnv overrides fields of config set in the environment, either directly or through a file
+func applyConfigEnv(config *Configuration, lookup func(string) (string, bool)) error {
+	return walkConfig(reflect.ValueOf(config).Elem(), configEnvPrefix, "", func(field reflect.Value, _ reflect.StructField, env, _ string) error {
+		value, found := lookup(env)
+
+		if path, ok := lookup(env + configFileSuffix); ok {
+			if found {
+				return fmt.Errorf("both %s and %s%s are set", env, env, configFileSuffix)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s%s: %w", env, configFileSuffix, err)
+			}
+			value, found = strings.TrimRight(string(content), "\r\n"), true
+		}
+
+		if !found {
+			return nil
+		}
+
+		if err := setConfigField(field, value); err != nil {
+			return fmt.Errorf("invalid value of %s: %w", env, err)
+		}
+		return nil
+	})
+}
+
+// setConfigField parses value into field, lists are comma separated and other values like maps are JSON
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			items := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		return json.Unmarshal([]byte(value), field.Addr().Interface())
+
+	default:
+		return json.Unmarshal([]byte(value), field.Addr().Interface())
+	}
+
+	return nil
+}
+
+// RedactConfiguration returns a copy of config with the values of fields tagged secret replaced
+func RedactConfiguration(config Configuration) Configuration {
+	_ = walkConfig(reflect.ValueOf(&config).Elem(), configEnvPrefix, "", func(field reflect.Value, structField reflect.StructField, _, _ string) error {
+		if structField.Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(redacted)
+		}
+		return nil
+	})
+
+	return config
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReloadableConfigKeys are configuration keys, or parents of keys, applied without a restart.
+// Changes of other keys are logged and wait for the next restart.
+var ReloadableConfigKeys = []string{"admins", "mailSender.timeout", "voucher", "quotas"}
+
+// ErrConfigNotWatched is returned when reloading a configuration whose source is not set
+var ErrConfigNotWatched = errors.New("configuration source is not watched")
+
+// ConfigVersion describes the active configuration
+type ConfigVersion struct {
+	Version         int        `json:"version"` // incremented on every applied reload
+	Hash            string     `json:"hash"`    // of the active configuration with secrets redacted
+	LoadedAt        time.Time  `json:"loaded_at"`
+	Reloadable      []string   `json:"reloadable"`
+	RestartRequired []string   `json:"restart_required,omitempty"` // changed keys waiting for a restart
+	LastError       string     `json:"last_error,omitempty"`       // why the last reload was rejected
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+}
+
+// ConfigStore holds the active configuration, swapping its reloadable keys when the configuration source changes
+type ConfigStore struct {
+	active atomic.Pointer[Configuration]
+
+	mu      sync.Mutex
+	source  *string       // file reloads read from, nil until watched
+	read    Configuration // last configuration read from the source, applied or not
+	version ConfigVersion
+}
+
+// NewConfigStore creates a store with config active, it can't be reloaded until its source is set
+func NewConfigStore(config Configuration) *ConfigStore {
+	s := &ConfigStore{read: config}
+	s.active.Store(&config)
+	s.version = ConfigVersion{
+		Version:    1,
+		Hash:       configHash(config),
+		LoadedAt:   time.Now(),
+		Reloadable: ReloadableConfigKeys,
+	}
+	return s
+}
+
+// Current returns the active configuration, it must not be modified
+func (s *ConfigStore) Current() *Configuration {
+	return s.active.Load()
+}
+
+// Version returns the version of the active configuration
+func (s *ConfigStore) Version() ConfigVersion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// SetSource sets the configuration file reloads read from like LoadConfiguration, empty for no file
+func (s *ConfigStore) SetSource(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source = &path
+}
+
+// Reload reads the configuration source again and applies changed reloadable keys. An invalid configuration
+// is rejected and the active configuration kept. It reports whether the active configuration changed.
+func (s *ConfigStore) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil {
+		return false, ErrConfigNotWatched
+	}
+
+	next, err := LoadConfiguration(*s.source)
+	if err != nil {
+		now := time.Now()
+		s.version.LastError = err.Error()
+		s.version.LastErrorAt = &now
+		return false, fmt.Errorf("rejected configuration reload, the active configuration is kept: %w", err)
+	}
+	s.version.LastError = ""
+	s.version.LastErrorAt = nil
+
+	changed := diffConfig(s.read, next)
+	s.read = next
+	if len(changed) == 0 {
+		return false, nil
+	}
+
+	active := *s.Current()
+	activeFields := configFields(&active)
+	nextFields := configFields(&next)
+	applied := false
+	for _, change := range changed {
+		if !isReloadable(change.key) {
+			log.Warn().Str("key", change.key).RawJSON("old", change.old).RawJSON("new", change.new).
+				Msg("configuration changed, restart to apply it")
+			continue
+		}
+
+		activeFields[change.key].Set(nextFields[change.key])
+		applied = true
+		log.Info().Str("key", change.key).RawJSON("old", change.old).RawJSON("new", change.new).Msg("configuration reloaded")
+	}
+
+	s.version.RestartRequired = nil
+	for _, change := range diffConfig(active, next) {
+		s.version.RestartRequired = append(s.version.RestartRequired, change.key)
+	}
+
+	if !applied {
+		return false, nil
+	}
+
+	s.active.Store(&active)
+	s.version.Version++
+	s.version.Hash = configHash(active)
+	s.version.LoadedAt = time.Now()
+	log.Info().Int("version", s.version.Version).Str("hash", s.version.Hash).Msg("configuration version changed")
+
+	return true, nil
+}
+
+// Watch reloads the configuration every interval until ctx is done, a rejected reload is logged once
+func (s *ConfigStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastErr := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := s.Reload()
+		if err == nil {
+			lastErr = ""
+			continue
+		}
+		if err.Error() != lastErr {
+			log.Error().Err(err).Msg("failed to reload configuration")
+			lastErr = err.Error()
+		}
+	}
+}
+
+func isReloadable(key string) bool {
+	for _, reloadable := range ReloadableConfigKeys {
+		if key == reloadable || strings.HasPrefix(key, reloadable+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// configFields maps keys of config to its leaf fields
+func configFields(config *Configuration) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	_ = walkConfig(reflect.ValueOf(config).Elem(), configEnvPrefix, "", func(field reflect.Value, _ reflect.StructField, _, key string) error {
+		fields[key] = field
+		return nil
+	})
+	return fields
+}
+
+// configChange is a key whose JSON value changed, secret values are redacted
+type configChange struct {
+	key      string
+	old, new json.RawMessage
+}
+
+// diffConfig returns changed keys between two configurations, sorted by key
+func diffConfig(old, new Configuration) []configChange {
+	oldFields := configFields(&old)
+	newFields := configFields(&new)
+	oldRedacted, newRedacted := RedactConfiguration(old), RedactConfiguration(new)
+	redactedOld := configFields(&oldRedacted)
+	redactedNew := configFields(&newRedacted)
+
+	var changes []configChange
+	for key, oldField := range oldFields {
+		oldValue, _ := json.Marshal(oldField.Interface())
+		newValue, _ := json.Marshal(newFields[key].Interface())
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+
+		oldValue, _ = json.Marshal(redactedOld[key].Interface())
+		newValue, _ = json.Marshal(redactedNew[key].Interface())
+		changes = append(changes, configChange{key: key, old: oldValue, new: newValue})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].key < changes[j].key })
+	return changes
+}
+
+// configHash is a short hash of config with secrets redacted
+func configHash(config Configuration) string {
+	content, _ := json.Marshal(RedactConfiguration(config))
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "server:\n  port: \"9000\"\ntoken:\n  secret: from file\nadmins: [admin@kubecloud.io]\nrate_limit:\n  policies:\n    auth: {requests: 3}\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secretFile := filepath.Join(dir, "jwt")
+	if err := os.WriteFile(secretFile, []byte("from secret file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KUBECLOUD_TOKEN_SECRET_FILE", secretFile)
+	t.Setenv("KUBECLOUD_MAILSENDER_EMAIL", "noreply@kubecloud.io")
+	t.Setenv("KUBECLOUD_ADMINS", "a@kubecloud.io, b@kubecloud.io")
+	t.Setenv("KUBECLOUD_QUOTAS_USER_CLUSTERS", "3")
+	t.Setenv("KUBECLOUD_SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+
+	config, err := ReadConfiguration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Host != "0.0.0.0" || config.Server.Port != "9000" {
+		t.Fatalf("expected default host and port from file, got %+v", config.Server)
+	}
+	if config.JWT.Secret != "from secret file" || config.MailSender.Email != "noreply@kubecloud.io" {
+		t.Fatalf("expected values from the environment, got %+v %+v", config.JWT, config.MailSender)
+	}
+	if strings.Join(config.Admins, ",") != "a@kubecloud.io,b@kubecloud.io" || config.Quotas.User.Clusters != 3 {
+		t.Fatalf("expected admins and quota from the environment, got %v %+v", config.Admins, config.Quotas.User)
+	}
+	if strings.Join(config.Server.TrustedProxies, ",") != "10.0.0.0/8,192.0.2.1" {
+		t.Fatalf("expected trusted proxies from the environment, got %v", config.Server.TrustedProxies)
+	}
+	if config.RateLimit.Policies["auth"].Requests != 3 {
+		t.Fatalf("expected policy from file, got %+v", config.RateLimit.Policies)
+	}
+	if err := ValidateConfiguration(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if redacted := RedactConfiguration(config); redacted.JWT.Secret != "REDACTED" || config.JWT.Secret != "from secret file" {
+		t.Fatalf("expected only the copy to be redacted, got %q and %q", redacted.JWT.Secret, config.JWT.Secret)
+	}
+
+	t.Setenv("KUBECLOUD_TOKEN_SECRET", "from environment")
+	if _, err := ReadConfiguration(path); err == nil {
+		t.Fatal("expected an error with both a variable and its file set")
+	}
+}
+
+func TestValidateConfiguration(t *testing.T) {
+	config := DefaultConfiguration()
+	config.MailSender.Timeout = 10
+	config.Server.TrustedProxies = []string{"proxy"}
+
+	err := ValidateConfiguration(config)
+	if err == nil || !strings.Contains(err.Error(), "token.secret failed on required") || !strings.Contains(err.Error(), "mailSender.timeout failed on min") {
+		t.Fatalf("expected errors naming configuration keys, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "trusted_proxies[0] failed on cidr|ip") {
+		t.Fatalf("expected a trusted proxy other than an address or network to be refused, got %v", err)
+	}
+}
+
+func TestConfigStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"token":{"secret":"secret"},"mailSender":{"email":"noreply@kubecloud.io"},"admins":["a@kubecloud.io"]}`)
+	config, err := LoadConfiguration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewConfigStore(config)
+
+	if _, err := store.Reload(); err != ErrConfigNotWatched {
+		t.Fatalf("expected reload without a source to fail, got %v", err)
+	}
+	store.SetSource(path)
+
+	write(`{"token":{"secret":"secret"},"mailSender":{"email":"noreply@kubecloud.io","timeout":600},"admins":["b@kubecloud.io"],"server":{"port":"9000"}}`)
+	changed, err := store.Reload()
+	if err != nil || !changed {
+		t.Fatalf("expected a reload, got %v %v", changed, err)
+	}
+
+	active := store.Current()
+	if strings.Join(active.Admins, ",") != "b@kubecloud.io" || active.MailSender.Timeout != 600 || active.Server.Port != "8080" {
+		t.Fatalf("expected only reloadable keys to change, got %v %d %s", active.Admins, active.MailSender.Timeout, active.Server.Port)
+	}
+	if version := store.Version(); version.Version != 2 || strings.Join(version.RestartRequired, ",") != "server.port" {
+		t.Fatalf("expected version 2 waiting for a restart to apply server.port, got %+v", version)
+	}
+
+	write(`{"token":{"secret":"secret"},"mailSender":{"email":"noreply@kubecloud.io","timeout":1},"admins":["c@kubecloud.io"]}`)
+	if _, err := store.Reload(); err == nil {
+		t.Fatal("expected an invalid configuration to be rejected")
+	}
+	if store.Current() != active || store.Version().LastError == "" {
+		t.Fatalf("expected the active configuration to be kept, got %+v", store.Version())
+	}
+}
+
+func TestValidateConfigurationDependencies(t *testing.T) {
+	valid := DefaultConfiguration()
+	valid.JWT.Secret = "secret"
+	valid.MailSender.Email = "noreply@kubecloud.io"
+	if err := ValidateConfiguration(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(config *Configuration){
+		"oidc.issuer failed on required_if": func(config *Configuration) {
+			config.OIDC = OIDC{Enabled: true, ClientID: "id", RedirectURL: "https://kubecloud.io/callback"}
+		},
+		"oidc.client_id failed on required_if": func(config *Configuration) {
+			config.OIDC = OIDC{Enabled: true, Issuer: "https://id.kubecloud.io", RedirectURL: "https://kubecloud.io/callback"}
+		},
+		"tls.cert_file failed on required_if":    func(config *Configuration) { config.TLS = TLS{Enabled: true, KeyFile: "key.pem"} },
+		"tls.acme.domains failed on required_if": func(config *Configuration) { config.TLS = TLS{Enabled: true, ACME: ACME{Enabled: true}} },
+		"session.insecure failed on excluded_if": func(config *Configuration) { config.Session = Session{Enabled: true, SameSite: "none", Insecure: true} },
+		"cors.allow_credentials failed on exclude": func(config *Configuration) {
+			config.CORS.AllowedOrigins, config.CORS.AllowCredentials = []string{"*"}, true
+		},
+	}
+	for expected, configure := range tests {
+		config := valid
+		configure(&config)
+		if err := ValidateConfiguration(config); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+
+	// acme issues certificates instead of reading them from files
+	config := valid
+	config.TLS = TLS{Enabled: true, ACME: ACME{Enabled: true, Domains: []string{"kubecloud.io"}}}
+	if err := ValidateConfiguration(config); err != nil {
+		t.Fatalf("expected acme without certificate files to be valid, got %v", err)
+	}
+}
@@ -1,87 +1,110 @@
 package internal
 
 import (
-	_ "embed"
+	"context"
 	"fmt"
-	"strings"
 
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
-//go:embed templates/reset_password.html
-var resetPassTemplate []byte
-
-//go:embed templates/welcome.html
-var welcomeMail []byte
-
-//go:embed templates/signup.html
-var signupTemplate []byte
-
+// MailService builds mails content and sends them through its mailer
 type MailService struct {
-	client *sendgrid.Client
+	mailer    Mailer
+	templates *MailTemplates
 }
 
-func NewMailService(sendGridKey string) MailService {
+// NewMailService creates a new mail service rendering templates and sending through mailer
+func NewMailService(mailer Mailer, templates *MailTemplates) MailService {
 	return MailService{
-		client: sendgrid.NewSendClient(sendGridKey),
+		mailer:    mailer,
+		templates: templates,
 	}
 }
 
-// SendMail sends verification mails
-func (service *MailService) SendMail(sender, receiver, subject, body string) error {
-	from := mail.NewEmail("KubeCloud", sender)
+// MatchLocale returns the best available mail locale for language preferences
+func (service *MailService) MatchLocale(preferences ...string) string {
+	return service.templates.MatchLocale(preferences...)
+}
+
+// Ping checks the mail provider is reachable, mailers unable to check it are assumed reachable
+func (service *MailService) Ping(ctx context.Context) error {
+	if pinger, ok := service.mailer.(MailerPinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
 
+// SendMail sends verification mails
+func (service *MailService) SendMail(sender, receiver string, content MailContent) error {
 	if !isValidEmail(receiver) {
 		return fmt.Errorf("email %v is not valid", receiver)
 	}
 
-	to := mail.NewEmail("KubeCloud User", receiver)
-
-	message := mail.NewSingleEmail(from, subject, to, "", body)
-	message.Content = []*mail.Content{
-		mail.NewContent("text/html", body),
+	err := service.mailer.Send(Message{
+		FromName: "KubeCloud",
+		From:     sender,
+		ToName:   "KubeCloud User",
+		To:       receiver,
+		Subject:  content.Subject,
+		HTMLBody: content.HTMLBody,
+		TextBody: content.TextBody,
+	})
+	if err != nil {
+		MailsSent.WithLabelValues("failure").Inc()
+		return err
 	}
-	_, err := service.client.Send(message)
 
-	return err
+	MailsSent.WithLabelValues("success").Inc()
+	return nil
 }
 
 // ResetPasswordMailContent gets the email content for reset password
-func (service *MailService) ResetPasswordMailContent(code int, timeout int, username, host string) (string, string) {
-	subject := "Reset password"
-	body := string(resetPassTemplate)
-
-	body = strings.ReplaceAll(body, "-code-", fmt.Sprint(code))
-	body = strings.ReplaceAll(body, "-time-", fmt.Sprint(timeout))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func (service *MailService) ResetPasswordMailContent(code int, timeout int, username, host, locale string) (MailContent, error) {
+	return service.templates.Render(locale, ResetPasswordTemplate, MailData{
+		Name:    cases.Title(language.Und).String(username),
+		Host:    host,
+		Code:    code,
+		Timeout: timeout,
+	})
 }
 
 // WelcomeMailContent gets the email content for welcome messages
-func (service *MailService) WelcomeMailContent(username, host string) (string, string) {
-	subject := "Welcome to KubeCloud 🎉"
-	body := string(welcomeMail)
-
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func (service *MailService) WelcomeMailContent(username, host, locale string) (MailContent, error) {
+	return service.templates.Render(locale, WelcomeTemplate, MailData{
+		Name: cases.Title(language.Und).String(username),
+		Host: host,
+	})
 }
 
 // SignUpMailContent gets the email content for sign up
-func (service *MailService) SignUpMailContent(code int, timeout int, username, host string) (string, string) {
-	subject := "Welcome to KubeCloud 🎉"
-	body := string(signupTemplate)
+func (service *MailService) SignUpMailContent(code int, timeout int, username, host, locale string) (MailContent, error) {
+	return service.templates.Render(locale, SignUpTemplate, MailData{
+		Name:    cases.Title(language.Und).String(username),
+		Host:    host,
+		Code:    code,
+		Timeout: timeout,
+	})
+}
 
-	body = strings.ReplaceAll(body, "-code-", fmt.Sprint(code))
-	body = strings.ReplaceAll(body, "-time-", fmt.Sprint(timeout))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+// NotificationMailContent gets the email content for notifications
+func (service *MailService) NotificationMailContent(title, message, username, host, locale string) (MailContent, error) {
+	return service.templates.Render(locale, NotificationTemplate, MailData{
+		Name:    cases.Title(language.Und).String(username),
+		Host:    host,
+		Title:   title,
+		Message: message,
+	})
+}
 
-	return subject, body
+// InvitationMailContent gets the email content for organization invitations
+func (service *MailService) InvitationMailContent(token string, timeoutDays int, inviter, organization, role, host, locale string) (MailContent, error) {
+	return service.templates.Render(locale, InvitationTemplate, MailData{
+		Name:         cases.Title(language.Und).String(inviter),
+		Host:         host,
+		Timeout:      timeoutDays,
+		Organization: organization,
+		Role:         role,
+		Token:        token,
+	})
 }
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	spacesRegex     = regexp.MustCompile(`[ \t\r\n]+`)
+	blankLinesRegex = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText converts an html mail into its plain text alternative
+func htmlToText(content string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+
+	var text strings.Builder
+	var href string
+	hrefStart := 0
+	hidden := 0
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.DataAtom {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				if tokenType == html.StartTagToken {
+					hidden++
+				}
+			case atom.Br, atom.P, atom.Div, atom.Tr, atom.H1, atom.H2, atom.H3, atom.Li, atom.Table:
+				text.WriteString("\n")
+			case atom.A:
+				href = attribute(token, "href")
+				hrefStart = text.Len()
+			}
+
+		case html.EndTagToken:
+			switch token.DataAtom {
+			case atom.Head, atom.Style, atom.Script, atom.Title:
+				hidden--
+			case atom.P, atom.Div, atom.Tr, atom.H1, atom.H2, atom.H3, atom.Li, atom.Table:
+				text.WriteString("\n")
+			case atom.A:
+				// links without text or showing their own url need no suffix
+				linkText := strings.TrimSpace(text.String()[hrefStart:])
+				if href != "" && hidden == 0 && linkText != "" && linkText != href {
+					text.WriteString(" (" + href + ")")
+				}
+				href = ""
+			}
+
+		case html.TextToken:
+			if hidden == 0 {
+				text.WriteString(spacesRegex.ReplaceAllString(token.Data, " "))
+			}
+		}
+	}
+
+	lines := strings.Split(text.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.TrimSpace(blankLinesRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// attribute returns the value of an html token attribute
+func attribute(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
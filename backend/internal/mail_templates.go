@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// DefaultLocale is used when no template set matches the language of user
+const DefaultLocale = "en"
+
+const (
+	// SignUpTemplate is sent with the code verifying a new account
+	SignUpTemplate = "signup"
+	// WelcomeTemplate is sent after an account is verified
+	WelcomeTemplate = "welcome"
+	// ResetPasswordTemplate is sent with the code resetting a password
+	ResetPasswordTemplate = "reset_password"
+	// NotificationTemplate is sent for notifications delivered by mail
+	NotificationTemplate = "notification"
+	// InvitationTemplate is sent with the token to join an organization
+	InvitationTemplate = "invitation"
+)
+
+// MailData holds values rendered in mail templates
+type MailData struct {
+	Name    string
+	Host    string
+	Code    int
+	Timeout int // in seconds, in days for invitations
+	Title   string
+	Message string
+
+	Organization string
+	Role         string
+	Token        string
+}
+
+// MailContent holds a rendered mail
+type MailContent struct {
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+// MailTemplates holds parsed template sets per locale
+type MailTemplates struct {
+	locales map[string]map[string]*template.Template
+	names   []string // locale names in the order known by matcher
+	matcher language.Matcher
+}
+
+// LoadMailTemplates parses embedded templates, files in overrideDir replace embedded ones.
+// Templates are laid out as <locale>/<name>.html and define a "subject" template.
+func LoadMailTemplates(overrideDir string) (*MailTemplates, error) {
+	embedded, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []fs.FS{embedded}
+	if overrideDir != "" {
+		sources = append(sources, os.DirFS(overrideDir))
+	}
+
+	templates := &MailTemplates{locales: map[string]map[string]*template.Template{}}
+	for _, source := range sources {
+		files, err := fs.Glob(source, "*/*.html")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			locale := path.Dir(file)
+			if _, err := language.Parse(locale); err != nil {
+				return nil, fmt.Errorf("invalid locale directory %q: %w", locale, err)
+			}
+
+			tmpl, err := template.ParseFS(source, file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse mail template %s: %w", file, err)
+			}
+
+			if tmpl.Lookup("subject") == nil {
+				return nil, fmt.Errorf("mail template %s does not define a subject", file)
+			}
+
+			if templates.locales[locale] == nil {
+				templates.locales[locale] = map[string]*template.Template{}
+			}
+			templates.locales[locale][strings.TrimSuffix(path.Base(file), ".html")] = tmpl
+		}
+	}
+
+	if _, ok := templates.locales[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("mail templates for default locale %q are missing", DefaultLocale)
+	}
+
+	// default locale goes first so the matcher falls back to it
+	templates.names = []string{DefaultLocale}
+	for locale := range templates.locales {
+		if locale != DefaultLocale {
+			templates.names = append(templates.names, locale)
+		}
+	}
+
+	tags := make([]language.Tag, 0, len(templates.names))
+	for _, locale := range templates.names {
+		tags = append(tags, language.Make(locale))
+	}
+	templates.matcher = language.NewMatcher(tags)
+
+	return templates, nil
+}
+
+// MatchLocale returns the best available locale for language preferences such as an Accept-Language header
+func (t *MailTemplates) MatchLocale(preferences ...string) string {
+	var desired []language.Tag
+	for _, preference := range preferences {
+		tags, _, err := language.ParseAcceptLanguage(preference)
+		if err == nil {
+			desired = append(desired, tags...)
+		}
+	}
+
+	_, index, _ := t.matcher.Match(desired...)
+	return t.names[index]
+}
+
+// Render renders template name in locale falling back to the default locale
+func (t *MailTemplates) Render(locale, name string, data MailData) (MailContent, error) {
+	tmpl, ok := t.locales[locale][name]
+	if !ok {
+		tmpl, ok = t.locales[DefaultLocale][name]
+	}
+	if !ok {
+		return MailContent{}, fmt.Errorf("mail template %q is not found", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return MailContent{}, fmt.Errorf("failed to render subject of %q: %w", name, err)
+	}
+
+	if err := tmpl.Execute(&body, data); err != nil {
+		return MailContent{}, fmt.Errorf("failed to render mail %q: %w", name, err)
+	}
+
+	return MailContent{
+		Subject:  strings.TrimSpace(html.UnescapeString(subject.String())),
+		HTMLBody: body.String(),
+		TextBody: htmlToText(body.String()),
+	}, nil
+}
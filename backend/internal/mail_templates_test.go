@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderMailTemplate(t *testing.T) {
+	templates, err := LoadMailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := templates.Render(DefaultLocale, SignUpTemplate, MailData{Name: "<b>user</b>", Host: "https://kubecloud.io", Code: 123456, Timeout: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content.Subject != "Welcome to KubeCloud 🎉" {
+		t.Fatalf("unexpected subject %q", content.Subject)
+	}
+	if strings.Contains(content.HTMLBody, "<b>user</b>") || !strings.Contains(content.HTMLBody, "&lt;b&gt;user&lt;/b&gt;") {
+		t.Fatal("expected the name to be escaped in the html body")
+	}
+	if !strings.Contains(content.TextBody, "Welcome, <b>user</b>!") || !strings.Contains(content.TextBody, "123456") {
+		t.Fatalf("expected the plain text alternative to hold the name and code, got %q", content.TextBody)
+	}
+	if strings.Contains(content.TextBody, "<td") || strings.Contains(content.TextBody, "{") {
+		t.Fatalf("expected markup and styles to be left out of the plain text alternative, got %q", content.TextBody)
+	}
+}
+
+func TestMailTemplateLocales(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "de"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "en"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"de/welcome.html": `{{define "subject"}}Willkommen{{end}}<p>Hallo {{.Name}}</p>`,
+		"en/welcome.html": `{{define "subject"}}Branded welcome{{end}}<p>Hi {{.Name}}</p>`,
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := LoadMailTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for preference, locale := range map[string]string{"de-AT": "de", "fr, de;q=0.5": "de", "fr": DefaultLocale, "": DefaultLocale} {
+		if matched := templates.MatchLocale(preference); matched != locale {
+			t.Errorf("expected %q to match %q, got %q", preference, locale, matched)
+		}
+	}
+
+	cases := []struct {
+		locale, name, subject string
+	}{
+		{"de", WelcomeTemplate, "Willkommen"},
+		{DefaultLocale, WelcomeTemplate, "Branded welcome"},
+		// templates missing in a locale fall back to the embedded default
+		{"de", SignUpTemplate, "Welcome to KubeCloud 🎉"},
+	}
+	for _, c := range cases {
+		content, err := templates.Render(c.locale, c.name, MailData{Name: "user"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content.Subject != c.subject {
+			t.Errorf("expected %s in %s to have subject %q, got %q", c.name, c.locale, c.subject, content.Subject)
+		}
+	}
+
+	if _, err := templates.Render(DefaultLocale, "missing", MailData{}); err == nil {
+		t.Fatal("expected an unknown template to fail")
+	}
+}
+
+func TestLoadMailTemplatesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "en"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "en", "welcome.html"), []byte(`<p>{{.Name}}</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadMailTemplates(dir); err == nil {
+		t.Fatal("expected a template without a subject to be refused")
+	}
+}
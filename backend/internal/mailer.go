@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	// MailDriverSendGrid sends mails through SendGrid API
+	MailDriverSendGrid = "sendgrid"
+	// MailDriverSMTP sends mails through an SMTP relay
+	MailDriverSMTP = "smtp"
+	// MailDriverFile writes mails as .eml files to a directory
+	MailDriverFile = "file"
+	// MailDriverStdout prints mails to stdout
+	MailDriverStdout = "stdout"
+)
+
+// Message holds an email ready to be sent
+type Message struct {
+	FromName string
+	From     string
+	ToName   string
+	To       string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+// Mailer is a transport for sending emails
+type Mailer interface {
+	Send(message Message) error
+}
+
+// MailerPinger is implemented by mailers able to check their provider is reachable
+type MailerPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// dialPing checks a TCP connection can be opened to address
+func dialPing(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// NewMailer creates the mail transport chosen in configurations
+func NewMailer(config MailSender) (Mailer, error) {
+	switch config.Driver {
+	case "", MailDriverSendGrid:
+		if config.SendGridKey == "" {
+			return nil, fmt.Errorf("sendgrid key is required for %s mail driver", MailDriverSendGrid)
+		}
+		return NewSendGridMailer(config.SendGridKey), nil
+	case MailDriverSMTP:
+		return NewSMTPMailer(config.SMTP), nil
+	case MailDriverFile:
+		return NewFileMailer(config.Directory)
+	case MailDriverStdout:
+		return NewStdoutMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", config.Driver)
+	}
+}
+
+// rfc822 encodes message in internet message format with plain text and html alternatives
+func (message Message) rfc822() ([]byte, error) {
+	var buf bytes.Buffer
+
+	from := mail.Address{Name: message.FromName, Address: message.From}
+	to := mail.Address{Name: message.ToName, Address: message.To}
+
+	messageID, err := newMessageID(message.From)
+	if err != nil {
+		return nil, err
+	}
+
+	body := multipart.NewWriter(&buf)
+
+	headers := []struct{ key, value string }{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, body.Boundary())},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header.key, header.value)
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, content string }{
+		{"text/plain", message.TextBody},
+		{"text/html", message.HTMLBody},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+
+		writer, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + `; charset="utf-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// newMessageID generates a unique message ID in the domain of sender
+func newMessageID(sender string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	domain := "kubecloud"
+	if address, err := mail.ParseAddress(sender); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			domain = address.Address[at+1:]
+		}
+	}
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain), nil
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes mails as .eml files, used for local development and tests
+type FileMailer struct {
+	directory string
+}
+
+// NewFileMailer creates a new file mailer writing to directory
+func NewFileMailer(directory string) (*FileMailer, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("directory is required for %s mail driver", MailDriverFile)
+	}
+
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mails directory: %w", err)
+	}
+
+	return &FileMailer{directory: directory}, nil
+}
+
+// Ping checks the mails directory still exists
+func (m *FileMailer) Ping(ctx context.Context) error {
+	info, err := os.Stat(m.directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", m.directory)
+	}
+	return nil
+}
+
+// Send writes message to a new .eml file
+func (m *FileMailer) Send(message Message) error {
+	content, err := message.rfc822()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", string(filepath.Separator), "_").Replace(message.To)
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), recipient)
+
+	return os.WriteFile(filepath.Join(m.directory, name), content, 0o644)
+}
+
+// StdoutMailer prints mails to stdout
+type StdoutMailer struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+// NewStdoutMailer creates a new stdout mailer
+func NewStdoutMailer() *StdoutMailer {
+	return &StdoutMailer{out: os.Stdout}
+}
+
+// Send prints message to stdout
+func (m *StdoutMailer) Send(message Message) error {
+	content, err := message.rfc822()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.out, "%s\r\n\r\n", content)
+	return err
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+const sendGridAddress = "api.sendgrid.com:443"
+
+// SendGridMailer sends mails through SendGrid API
+type SendGridMailer struct {
+	client *sendgrid.Client
+}
+
+// NewSendGridMailer creates a new SendGrid mailer
+func NewSendGridMailer(sendGridKey string) *SendGridMailer {
+	return &SendGridMailer{
+		client: sendgrid.NewSendClient(sendGridKey),
+	}
+}
+
+// Ping checks SendGrid API accepts connections
+func (m *SendGridMailer) Ping(ctx context.Context) error {
+	return dialPing(ctx, sendGridAddress)
+}
+
+// Send sends message through SendGrid
+func (m *SendGridMailer) Send(message Message) error {
+	from := mail.NewEmail(message.FromName, message.From)
+	to := mail.NewEmail(message.ToName, message.To)
+
+	content := mail.NewSingleEmail(from, message.Subject, to, "", message.HTMLBody)
+	content.Content = []*mail.Content{
+		mail.NewContent("text/html", message.HTMLBody),
+	}
+	if message.TextBody != "" {
+		// plain text should come before html
+		content.Content = append([]*mail.Content{mail.NewContent("text/plain", message.TextBody)}, content.Content...)
+	}
+
+	response, err := m.client.Send(content)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("sendgrid responded with status %d: %s", response.StatusCode, response.Body)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPMailer sends mails through an SMTP relay
+type SMTPMailer struct {
+	config    SMTP
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// NewSMTPMailer creates a new SMTP mailer
+func NewSMTPMailer(config SMTP) *SMTPMailer {
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	if timeout == 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	return &SMTPMailer{
+		config:    config,
+		timeout:   timeout,
+		tlsConfig: &tls.Config{ServerName: config.Host, MinVersion: tls.VersionTLS12},
+	}
+}
+
+// Ping checks the SMTP relay accepts connections
+func (m *SMTPMailer) Ping(ctx context.Context) error {
+	return dialPing(ctx, net.JoinHostPort(m.config.Host, m.config.Port))
+}
+
+// Send sends message through the SMTP relay
+func (m *SMTPMailer) Send(message Message) error {
+	content, err := message.rfc822()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	conn, err := m.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+
+	// a stalled server must not block the outbox sender
+	if err := conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to set smtp deadline: %w", err)
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to create smtp client: %w", err)
+	}
+	defer client.Close()
+
+	if m.config.StartTLS && !m.config.ImplicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", m.config.Host)
+		}
+
+		if err := client.StartTLS(m.tlsConfig); err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate to smtp server: %w", err)
+		}
+	}
+
+	if err := client.Mail(message.From); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(content); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the SMTP relay, with TLS from the start if implicit TLS is enabled
+func (m *SMTPMailer) dial() (net.Conn, error) {
+	address := net.JoinHostPort(m.config.Host, m.config.Port)
+	dialer := &net.Dialer{Timeout: m.timeout}
+
+	if m.config.ImplicitTLS {
+		return tls.DialWithDialer(dialer, "tcp", address, m.tlsConfig)
+	}
+	return dialer.Dial("tcp", address)
+}
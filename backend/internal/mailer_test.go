@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	FromName: "KubeCloud",
+	From:     "noreply@kubecloud.io",
+	To:       "user@kubecloud.io",
+	Subject:  "Welcome",
+	HTMLBody: "<p>Hello</p>",
+	TextBody: "Hello",
+}
+
+// checkMessage parses an encoded mail and checks it holds testMessage
+func checkMessage(t *testing.T, content io.Reader) {
+	t.Helper()
+
+	parsed, err := mail.ReadMessage(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("To") != "<user@kubecloud.io>" || parsed.Header.Get("Subject") != "Welcome" {
+		t.Fatalf("unexpected headers %v", parsed.Header)
+	}
+
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "text/plain") || !strings.Contains(string(body), "<p>Hello</p>") {
+		t.Fatalf("expected plain text and html parts, got %s", body)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mails")
+	mailer, err := NewMailer(MailSender{Driver: MailDriverFile, Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mailer.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-user_at_kubecloud.io.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one mail named after its recipient, got %v %v", files, err)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	checkMessage(t, file)
+}
+
+// serveSMTP answers a single SMTP session on listener and sends the received data to mails
+func serveSMTP(t *testing.T, listener net.Listener, mails chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(line string) {
+		if err := text.PrintfLine("%s", line); err != nil {
+			t.Error(err)
+		}
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			reply("250 OK")
+		case "DATA":
+			reply("354 send data")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mails <- string(data)
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, "localhost", time.Now())
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	pem, err := os.ReadFile(certFile)
+	if err != nil || !roots.AppendCertsFromPEM(pem) {
+		t.Fatalf("failed to trust test certificate: %v", err)
+	}
+
+	for _, implicitTLS := range []bool{false, true} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if implicitTLS {
+			listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}})
+		}
+		defer listener.Close()
+
+		mails := make(chan string, 1)
+		go serveSMTP(t, listener, mails)
+
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		mailer := NewSMTPMailer(SMTP{Host: "127.0.0.1", Port: port, ImplicitTLS: implicitTLS, TimeoutSeconds: 5})
+		mailer.tlsConfig = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+		if err := mailer.Send(testMessage); err != nil {
+			t.Fatalf("implicit tls %v: %v", implicitTLS, err)
+		}
+		checkMessage(t, bufio.NewReader(strings.NewReader(<-mails)))
+	}
+}
+
+func TestSMTPMailerTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the server accepts connections but never greets
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(5 * time.Second)
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	mailer := NewSMTPMailer(SMTP{Host: "127.0.0.1", Port: port, TimeoutSeconds: 1})
+
+	start := time.Now()
+	if err := mailer.Send(testMessage); err == nil {
+		t.Fatal("expected a stalled server to fail sending")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected sending to give up after the timeout, took %s", elapsed)
+	}
+}
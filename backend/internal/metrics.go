@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"kubecloud/models"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsNamespace = "kubecloud"
+
+// businessMetricsTTL is how long business gauges are reused, so frequent scrapes don't load the database
+const businessMetricsTTL = 30 * time.Second
+
+const (
+	// SignupMethodPassword is a signup verified by a mailed code
+	SignupMethodPassword = "password"
+	// SignupMethodOIDC is a signup through the single sign-on provider
+	SignupMethodOIDC = "oidc"
+)
+
+var (
+	// HTTPRequests counts handled requests by method, route and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Handled HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the time taken to handle requests by method and route
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// AuthFailures counts requests rejected for failed authentication or authorization by route and error code
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected for failed authentication or authorization by route and error code.",
+	}, []string{"route", "code"})
+
+	// MailsSent counts mails handed to the mail transport by outcome, success or failure
+	MailsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mails_sent_total",
+		Help:      "Mails handed to the mail transport by outcome.",
+	}, []string{"outcome"})
+
+	// RateLimitStoreErrors counts requests let through because their rate limit couldn't be checked by policy
+	RateLimitStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_store_errors_total",
+		Help:      "Requests let through because their rate limit couldn't be checked by policy.",
+	}, []string{"policy"})
+
+	// Signups counts registered users by signup method
+	Signups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "signups_total",
+		Help:      "Registered users by signup method.",
+	}, []string{"method"})
+)
+
+// authFailureCodes are error codes counted as auth failures
+var authFailureCodes = map[ErrorCode]bool{
+	ErrCodeUnauthorized:       true,
+	ErrCodeInvalidToken:       true,
+	ErrCodeInvalidCredentials: true,
+	ErrCodeInsufficientScope:  true,
+	ErrCodeForbidden:          true,
+	ErrCodeInvalidCode:        true,
+	ErrCodeCodeExpired:        true,
+	ErrCodeChallengeFailed:    true,
+}
+
+// IsAuthFailure reports whether an error code is counted as an auth failure
+func IsAuthFailure(code ErrorCode) bool {
+	return authFailureCodes[code]
+}
+
+// BusinessCollector reports gauges of users, vouchers and balances, queried from the database at most once
+// per businessMetricsTTL
+type BusinessCollector struct {
+	db models.DB
+
+	mu          sync.Mutex
+	collected   []prometheus.Metric
+	collectedAt time.Time
+
+	verifiedUsers      *prometheus.Desc
+	outstandingVoucher *prometheus.Desc
+	redeemedVouchers   *prometheus.Desc
+	creditedBalance    *prometheus.Desc
+}
+
+// NewBusinessCollector creates a new business collector
+func NewBusinessCollector(db models.DB) *BusinessCollector {
+	return &BusinessCollector{
+		db: db,
+		verifiedUsers: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "verified_users"),
+			"Users who verified their email or signed up through single sign-on.", nil, nil),
+		outstandingVoucher: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "outstanding_voucher_value"),
+			"Total value of vouchers neither redeemed nor expired.", nil, nil),
+		redeemedVouchers: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "redeemed_vouchers"),
+			"Vouchers redeemed so far.", nil, nil),
+		creditedBalance: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "credited_balance"),
+			"Total balance credited to users by admins or vouchers.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *BusinessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.verifiedUsers
+	ch <- c.outstandingVoucher
+	ch <- c.redeemedVouchers
+	ch <- c.creditedBalance
+}
+
+// Collect implements prometheus.Collector, gauges failing to be queried are left out of the scrape
+func (c *BusinessCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.collectedAt) >= businessMetricsTTL {
+		c.collected = c.query()
+		c.collectedAt = time.Now()
+	}
+
+	for _, metric := range c.collected {
+		ch <- metric
+	}
+}
+
+func (c *BusinessCollector) query() []prometheus.Metric {
+	var metrics []prometheus.Metric
+
+	if verified, err := c.db.CountVerifiedUsers(); err != nil {
+		log.Error().Err(err).Msg("failed to count verified users")
+	} else {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.verifiedUsers, prometheus.GaugeValue, float64(verified)))
+	}
+
+	if value, err := c.db.SumOutstandingVoucherValue(time.Now()); err != nil {
+		log.Error().Err(err).Msg("failed to sum outstanding voucher value")
+	} else {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.outstandingVoucher, prometheus.GaugeValue, value))
+	}
+
+	if redeemed, err := c.db.CountRedeemedVouchers(); err != nil {
+		log.Error().Err(err).Msg("failed to count redeemed vouchers")
+	} else {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.redeemedVouchers, prometheus.GaugeValue, float64(redeemed)))
+	}
+
+	if balance, err := c.db.SumCreditedBalance(); err != nil {
+		log.Error().Err(err).Msg("failed to sum credited balance")
+	} else {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.creditedBalance, prometheus.GaugeValue, balance))
+	}
+
+	return metrics
+}
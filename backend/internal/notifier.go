@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"kubecloud/models"
+	"time"
+)
+
+// Notification holds an event to notify a user about
+type Notification struct {
+	Event   string
+	Title   string
+	Message string
+	Data    interface{} // structured details of event sent to webhooks
+}
+
+// NotificationChannel delivers notifications to users
+type NotificationChannel interface {
+	// Name is the channel users enable in their preferences
+	Name() string
+	Deliver(user models.User, notification Notification) error
+}
+
+// Notifier delivers notifications through the channels each user enabled
+type Notifier struct {
+	db       models.DB
+	channels []NotificationChannel
+}
+
+// NewNotifier creates a new notifier with its delivery channels
+func NewNotifier(db models.DB, channels ...NotificationChannel) *Notifier {
+	return &Notifier{
+		db:       db,
+		channels: channels,
+	}
+}
+
+// Notify delivers a notification to a user, every enabled channel is attempted even if one fails
+func (n *Notifier) Notify(userID int, notification Notification) error {
+	user, err := n.db.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	preference, err := n.Preference(userID, notification.Event)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, channel := range n.channels {
+		if !preference.Enabled(channel.Name()) {
+			continue
+		}
+
+		if err := channel.Deliver(user, notification); err != nil {
+			errs = append(errs, fmt.Errorf("failed to deliver through %s: %w", channel.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Preferences returns preferences of a user for all events, including defaults of unset ones
+func (n *Notifier) Preferences(userID int) ([]models.NotificationPreference, error) {
+	stored, err := n.db.ListNotificationPreferences(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notification preferences: %w", err)
+	}
+
+	byEvent := map[string]models.NotificationPreference{}
+	for _, preference := range stored {
+		byEvent[preference.Event] = preference
+	}
+
+	preferences := make([]models.NotificationPreference, 0, len(models.NotificationEvents))
+	for _, event := range models.NotificationEvents {
+		preference, ok := byEvent[event]
+		if !ok {
+			preference = models.DefaultNotificationPreference(userID, event)
+		}
+		preferences = append(preferences, preference)
+	}
+
+	return preferences, nil
+}
+
+// Preference returns preference of a user for an event
+func (n *Notifier) Preference(userID int, event string) (models.NotificationPreference, error) {
+	preferences, err := n.Preferences(userID)
+	if err != nil {
+		return models.NotificationPreference{}, err
+	}
+
+	for _, preference := range preferences {
+		if preference.Event == event {
+			return preference, nil
+		}
+	}
+
+	return models.NotificationPreference{}, fmt.Errorf("unknown notification event %q", event)
+}
+
+// InAppChannel stores notifications in the inbox of users
+type InAppChannel struct {
+	db models.DB
+}
+
+// NewInAppChannel creates a new in-app channel
+func NewInAppChannel(db models.DB) *InAppChannel {
+	return &InAppChannel{db: db}
+}
+
+// Name of in-app channel
+func (c *InAppChannel) Name() string {
+	return models.ChannelInApp
+}
+
+// Deliver adds notification to the inbox of user
+func (c *InAppChannel) Deliver(user models.User, notification Notification) error {
+	return c.db.CreateNotification(&models.Notification{
+		UserID:    user.ID,
+		Event:     notification.Event,
+		Title:     notification.Title,
+		Message:   notification.Message,
+		CreatedAt: time.Now(),
+	})
+}
+
+// EmailChannel queues notifications as mails in the outbox
+type EmailChannel struct {
+	db          models.DB
+	mailService MailService
+	outbox      *OutboxSender
+	sender      string
+	host        string
+}
+
+// NewEmailChannel creates a new email channel sending from sender
+func NewEmailChannel(db models.DB, mailService MailService, outbox *OutboxSender, sender, host string) *EmailChannel {
+	return &EmailChannel{
+		db:          db,
+		mailService: mailService,
+		outbox:      outbox,
+		sender:      sender,
+		host:        host,
+	}
+}
+
+// Name of email channel
+func (c *EmailChannel) Name() string {
+	return models.ChannelEmail
+}
+
+// Deliver queues notification mail to user
+func (c *EmailChannel) Deliver(user models.User, notification Notification) error {
+	content, err := c.mailService.NotificationMailContent(
+		notification.Title, notification.Message, user.Username, c.host, c.mailService.MatchLocale(user.Language),
+	)
+	if err != nil {
+		return err
+	}
+
+	mail, err := NewOutboxMail(c.sender, user.Email, content)
+	if err != nil {
+		return err
+	}
+
+	if err := c.db.EnqueueMail(&mail); err != nil {
+		return err
+	}
+
+	c.outbox.Wake()
+	return nil
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"errors"
+	"kubecloud/models"
+	"kubecloud/models/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+// recordingChannel records delivered notifications and fails with err if set
+type recordingChannel struct {
+	name      string
+	err       error
+	delivered []Notification
+}
+
+func (c *recordingChannel) Name() string {
+	return c.name
+}
+
+func (c *recordingChannel) Deliver(user models.User, notification Notification) error {
+	c.delivered = append(c.delivered, notification)
+	return c.err
+}
+
+func TestNotifier(t *testing.T) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	user := models.User{Username: "user", Email: "user@kubecloud.io"}
+	if err := db.RegisterUser(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	email := &recordingChannel{name: models.ChannelEmail, err: errors.New("relay is down")}
+	webhook := &recordingChannel{name: models.ChannelWebhook}
+	notifier := NewNotifier(db, email, NewInAppChannel(db), webhook)
+	credited := Notification{Event: models.EventBalanceCredited, Title: "Your balance is credited"}
+
+	// a failing channel does not keep others from delivering
+	if err := notifier.Notify(user.ID, credited); err == nil {
+		t.Fatal("expected the email failure to be reported")
+	}
+	if len(email.delivered) != 1 || len(webhook.delivered) != 1 {
+		t.Fatalf("expected every channel to be attempted, got %d email and %d webhook", len(email.delivered), len(webhook.delivered))
+	}
+	if inbox, err := db.ListUserNotifications(user.ID, true); err != nil || len(inbox) != 1 || inbox[0].Title != credited.Title {
+		t.Fatalf("expected the notification in the inbox, got %+v %v", inbox, err)
+	}
+
+	if err := db.UpsertNotificationPreference(&models.NotificationPreference{UserID: user.ID, Event: models.EventBalanceCredited, InApp: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(user.ID, credited); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.delivered) != 1 || len(webhook.delivered) != 1 {
+		t.Fatal("expected disabled channels to be skipped")
+	}
+
+	if err := notifier.Notify(user.ID, Notification{Event: "cluster.ready"}); err == nil {
+		t.Fatal("expected an unknown event to be refused")
+	}
+}
+
+func TestNotifierPreferences(t *testing.T) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	preferences, err := NewNotifier(db).Preferences(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preferences) != len(models.NotificationEvents) {
+		t.Fatalf("expected a preference per event, got %+v", preferences)
+	}
+	for _, preference := range preferences {
+		if preference != models.DefaultNotificationPreference(1, preference.Event) {
+			t.Fatalf("expected unset preferences to use defaults, got %+v", preference)
+		}
+	}
+}
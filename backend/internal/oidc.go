@@ -0,0 +1,536 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// oidcLoginTimeout is how long a started login may take until the callback is received
+	oidcLoginTimeout    = 10 * time.Minute
+	defaultGroupsClaim  = "groups"
+	defaultOIDCTimeout  = 10 * time.Second
+	oidcDiscoveryPath   = "/.well-known/openid-configuration"
+	pkceChallengeMethod = "S256"
+)
+
+// OIDCStateCookie holds the signed login started by a browser, only that browser may complete the login
+const OIDCStateCookie = "kubecloud_oidc_state"
+
+// ErrOIDCState is returned when the callback state is unknown or expired
+var ErrOIDCState = errors.New("invalid or expired login state")
+
+// OIDCIdentity holds the user information read from a verified ID token
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Groups        []string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is a started login, kept by the browser in the state cookie so any replica can complete it
+type oidcLogin struct {
+	State     string `json:"state"`
+	Verifier  string `json:"verifier"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against an OpenID Connect provider
+type OIDCProvider struct {
+	config   OIDC
+	client   *http.Client
+	stateKey []byte
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+}
+
+// NewOIDCProvider creates a new OIDCProvider, discovery happens on first use. State cookies are signed with a key
+// derived from secret, replicas sharing it can complete logins started by each other.
+func NewOIDCProvider(config OIDC, secret string) *OIDCProvider {
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = defaultGroupsClaim
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	key := sha256.Sum256([]byte("kubecloud oidc state|" + secret))
+	return &OIDCProvider{
+		config:   config,
+		client:   &http.Client{Timeout: defaultOIDCTimeout},
+		stateKey: key[:],
+		keys:     map[string]interface{}{},
+	}
+}
+
+// AuthURL starts a login and returns the provider URL the user is redirected to along with the value of the
+// state cookie of the login
+func (p *OIDCProvider) AuthURL(ctx context.Context) (string, string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomURLString(24)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := randomURLString(24)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	cookie, err := p.seal(oidcLogin{State: state, Verifier: verifier, Nonce: nonce, ExpiresAt: time.Now().Add(oidcLoginTimeout).Unix()})
+	if err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {pkceChallengeMethod},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), cookie, nil
+}
+
+// SetStateCookie binds the login returned by AuthURL to the browser starting it, so a callback with a login
+// started elsewhere is refused
+func (p *OIDCProvider) SetStateCookie(w http.ResponseWriter, cookie string) {
+	p.setStateCookie(w, cookie, oidcLoginTimeout)
+}
+
+// ClearStateCookie removes the state cookie once the callback is received
+func (p *OIDCProvider) ClearStateCookie(w http.ResponseWriter) {
+	p.setStateCookie(w, "", -1)
+}
+
+// VerifyStateCookie reports whether r comes from the browser which started the login of state
+func (p *OIDCProvider) VerifyStateCookie(r *http.Request, state string) bool {
+	login, err := p.open(cookieValue(r, OIDCStateCookie))
+	return err == nil && subtle.ConstantTimeCompare([]byte(login.State), []byte(state)) == 1
+}
+
+// seal encodes login as a cookie value signed with the state key
+func (p *OIDCProvider) seal(login oidcLogin) (string, error) {
+	payload, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + p.sign(encoded), nil
+}
+
+// open decodes a cookie value sealed by seal, cookies with an invalid signature fail with ErrOIDCState
+func (p *OIDCProvider) open(cookie string) (oidcLogin, error) {
+	encoded, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(p.sign(encoded))) {
+		return oidcLogin{}, ErrOIDCState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return oidcLogin{}, ErrOIDCState
+	}
+
+	var login oidcLogin
+	if err := json.Unmarshal(payload, &login); err != nil {
+		return oidcLogin{}, ErrOIDCState
+	}
+	return login, nil
+}
+
+func (p *OIDCProvider) sign(value string) string {
+	mac := hmac.New(sha256.New, p.stateKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setStateCookie sets the state cookie for the callback path, a negative maxAge deletes it
+func (p *OIDCProvider) setStateCookie(w http.ResponseWriter, value string, maxAge time.Duration) {
+	path := "/"
+	if redirect, err := url.Parse(p.config.RedirectURL); err == nil && redirect.Path != "" {
+		path = redirect.Path
+	}
+
+	cookie := &http.Cookie{
+		Name:     OIDCStateCookie,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   strings.HasPrefix(p.config.RedirectURL, "https://"),
+		HttpOnly: true,
+		// the provider redirects back from another site, a strict cookie would not be sent
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// Exchange redeems the authorization code of a callback r and returns the verified identity, the login is read
+// from the state cookie of r
+func (p *OIDCProvider) Exchange(ctx context.Context, r *http.Request, state, code string) (OIDCIdentity, error) {
+	login, err := p.open(cookieValue(r, OIDCStateCookie))
+	if err != nil || login.State != state || time.Now().Unix() > login.ExpiresAt {
+		return OIDCIdentity{}, ErrOIDCState
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {login.Verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return OIDCIdentity{}, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return OIDCIdentity{}, errors.New("token response has no id_token")
+	}
+
+	return p.verify(ctx, tokens.IDToken, login.Nonce)
+}
+
+// IsAdmin reports whether the identity belongs to one of the configured admin groups
+func (p *OIDCProvider) IsAdmin(identity OIDCIdentity) bool {
+	for _, group := range identity.Groups {
+		if Contains(p.config.AdminGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// MapsAdmins reports whether admin role is taken from provider groups
+func (p *OIDCProvider) MapsAdmins() bool {
+	return len(p.config.AdminGroups) > 0
+}
+
+func (p *OIDCProvider) verify(ctx context.Context, rawToken, nonce string) (OIDCIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, discovery.JWKSURI, kid)
+	})
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return OIDCIdentity{}, errors.New("invalid id token issuer")
+	}
+
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return OIDCIdentity{}, errors.New("invalid id token audience")
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return OIDCIdentity{}, errors.New("id token is expired")
+	}
+
+	if claimString(claims, "nonce") != nonce {
+		return OIDCIdentity{}, errors.New("invalid id token nonce")
+	}
+
+	identity := OIDCIdentity{
+		Subject:       claimString(claims, "sub"),
+		Email:         strings.ToLower(claimString(claims, "email")),
+		EmailVerified: claimBool(claims, "email_verified"),
+		Username:      claimString(claims, "preferred_username"),
+		Groups:        claimStrings(claims, p.config.GroupsClaim),
+	}
+
+	if identity.Subject == "" {
+		return OIDCIdentity{}, errors.New("id token has no subject")
+	}
+
+	if identity.Username == "" {
+		identity.Username = claimString(claims, "name")
+	}
+
+	if identity.Username == "" {
+		identity.Username, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	return identity, nil
+}
+
+// discover returns the discovery document of the provider, fetched once. The lock is not held while fetching,
+// so a slow provider doesn't block logins, concurrent first logins may each fetch it.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	cached := p.discovery
+	p.mu.Unlock()
+
+	if cached != nil {
+		return cached, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+oidcDiscoveryPath, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("provider issuer %q does not match configured issuer", discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("provider discovery document is incomplete")
+	}
+
+	p.mu.Lock()
+	p.discovery = &discovery
+	p.mu.Unlock()
+	return &discovery, nil
+}
+
+// key returns the signing key with the given id, keys are refetched once if it is unknown. Like discovery,
+// keys are fetched without holding the lock.
+func (p *OIDCProvider) key(ctx context.Context, jwksURI, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[jwk.Kid] = key
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported key curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding: %w", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimBool accepts both booleans and "true" strings, as some providers send the latter
+func claimBool(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPIDocument is an OpenAPI 3.0 document, only the parts used by this API are modelled
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// OpenAPIInfo describes the API
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents holds schemas and security schemes referenced by operations
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// OpenAPISecurityScheme describes how requests are authenticated
+type OpenAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`   // where an apiKey is sent
+	Name        string `json:"name,omitempty"` // name of the apiKey header or cookie
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIOperation describes a route
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+// OpenAPIParameter describes a path or query parameter
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIRequestBody describes the body of a request
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse describes a response
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType holds the schema of a body
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema is a JSON schema as used by OpenAPI 3.0
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool                      `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     bool                      `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// OpenAPISchemas generates schemas of Go types, named structs are added as components and referenced
+type OpenAPISchemas struct {
+	Components map[string]*OpenAPISchema
+}
+
+// NewOpenAPISchemas creates a new schema generator
+func NewOpenAPISchemas() *OpenAPISchemas {
+	return &OpenAPISchemas{Components: map[string]*OpenAPISchema{}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Of returns the schema of value's type
+func (s *OpenAPISchemas) Of(value interface{}) *OpenAPISchema {
+	return s.schema(reflect.TypeOf(value))
+}
+
+// Resolve follows a component reference
+func (s *OpenAPISchemas) Resolve(schema *OpenAPISchema) *OpenAPISchema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.Components[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// QueryParameters returns parameters of a struct bound from the query string with form tags
+func (s *OpenAPISchemas) QueryParameters(value interface{}) []OpenAPIParameter {
+	t := reflect.TypeOf(value)
+	var parameters []OpenAPIParameter
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("form"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		schema := s.schema(field.Type)
+		required := applyBindingRules(schema, field.Tag.Get("binding"))
+		parameters = append(parameters, OpenAPIParameter{Name: name, In: "query", Required: required, Schema: schema})
+	}
+
+	return parameters
+}
+
+func (s *OpenAPISchemas) schema(t reflect.Type) *OpenAPISchema {
+	if t == nil {
+		return &OpenAPISchema{}
+	}
+
+	if t.Kind() == reflect.Pointer {
+		schema := s.schema(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	}
+
+	switch {
+	case t == timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case t.Name() == "DeletedAt" && t.PkgPath() == "gorm.io/gorm":
+		return &OpenAPISchema{Type: "string", Format: "date-time", Nullable: true}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte", Nullable: true}
+		}
+		return &OpenAPISchema{Type: "array", Items: s.schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+
+		name := t.Name()
+		if _, ok := s.Components[name]; !ok {
+			// registered before fields are generated so recursive types terminate
+			s.Components[name] = &OpenAPISchema{}
+			*s.Components[name] = *s.structSchema(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	}
+
+	// interfaces accept anything
+	return &OpenAPISchema{}
+}
+
+func (s *OpenAPISchemas) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "-" {
+			continue
+		}
+
+		// embedded structs without a name are flattened like encoding/json does
+		if field.Anonymous && jsonName == "" && field.Type.Kind() == reflect.Struct {
+			embedded := s.structSchema(field.Type)
+			for name, property := range embedded.Properties {
+				schema.Properties[name] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+
+		property := s.schema(field.Type)
+		if property.Ref != "" && field.Tag.Get("binding") != "" {
+			// rules can't be added next to a reference
+			property = &OpenAPISchema{Ref: property.Ref}
+		} else if applyBindingRules(property, field.Tag.Get("binding")) {
+			schema.Required = append(schema.Required, jsonName)
+		}
+		schema.Properties[jsonName] = property
+	}
+
+	return schema
+}
+
+// applyBindingRules adds validator rules of a binding tag to schema and reports whether the field is required
+func applyBindingRules(schema *OpenAPISchema, tag string) bool {
+	required := false
+	target := schema
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "required":
+			required = true
+		case "dive":
+			// following rules apply to items
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "email":
+			target.Format = "email"
+		case "url":
+			target.Format = "uri"
+		case "oneof":
+			target.Enum = strings.Fields(param)
+		case "min", "max", "gt", "gte", "lt", "lte":
+			applyLimit(target, name, param)
+		}
+	}
+
+	return required
+}
+
+func applyLimit(schema *OpenAPISchema, rule, param string) {
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	count := int(value)
+
+	switch schema.Type {
+	case "string":
+		switch rule {
+		case "min", "gte":
+			schema.MinLength = &count
+		case "max", "lte":
+			schema.MaxLength = &count
+		}
+	case "array":
+		switch rule {
+		case "min", "gte":
+			schema.MinItems = &count
+		case "max", "lte":
+			schema.MaxItems = &count
+		}
+	case "integer", "number":
+		switch rule {
+		case "min", "gte":
+			schema.Minimum = &value
+		case "gt":
+			schema.Minimum = &value
+			schema.ExclusiveMinimum = true
+		case "max", "lte":
+			schema.Maximum = &value
+		case "lt":
+			schema.Maximum = &value
+			schema.ExclusiveMaximum = true
+		}
+	}
+}
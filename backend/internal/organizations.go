@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"kubecloud/models"
+)
+
+// GenerateInvitationToken generates the token sent to an invited email and the hash stored for it
+func GenerateInvitationToken() (string, string, error) {
+	token, err := randomURLString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashInvitationToken(token), nil
+}
+
+// HashInvitationToken hashes an invitation token for storage and lookup
+func HashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CanAssignOrganizationRole reports whether a member with actorRole may give or take role of another member.
+// Owners manage everyone, admins manage everyone but owners.
+func CanAssignOrganizationRole(actorRole, role string) bool {
+	switch actorRole {
+	case models.OrganizationRoleOwner:
+		return true
+	case models.OrganizationRoleAdmin:
+		return role != models.OrganizationRoleOwner
+	}
+	return false
+}
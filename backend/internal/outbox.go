@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"kubecloud/models"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	outboxBatchSize      = 50
+	retryBaseBackoff     = 30 * time.Second
+	retryMaxBackoff      = 6 * time.Hour
+	defaultOutboxRetries = 8
+	defaultOutboxPolling = 5 * time.Second
+	// outboxClaimLease outlasts a send so a claimed mail is only retried if its sender died
+	outboxClaimLease = 5 * time.Minute
+)
+
+// OutboxSender delivers queued mails in the background, retrying failures with exponential backoff
+type OutboxSender struct {
+	db          models.DB
+	mailService MailService
+	maxAttempts int
+	interval    time.Duration
+	wake        chan struct{}
+}
+
+// NewOutboxSender creates a new outbox sender, zero values use defaults
+func NewOutboxSender(db models.DB, mailService MailService, maxAttempts int, interval time.Duration) *OutboxSender {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultOutboxRetries
+	}
+
+	if interval <= 0 {
+		interval = defaultOutboxPolling
+	}
+
+	return &OutboxSender{
+		db:          db,
+		mailService: mailService,
+		maxAttempts: maxAttempts,
+		interval:    interval,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// NewOutboxMail prepares a mail to be queued in the outbox
+func NewOutboxMail(sender, receiver string, content MailContent) (models.OutboxMail, error) {
+	if !isValidEmail(receiver) {
+		return models.OutboxMail{}, fmt.Errorf("email %v is not valid", receiver)
+	}
+
+	return models.OutboxMail{
+		Sender:        sender,
+		Receiver:      receiver,
+		Subject:       content.Subject,
+		HTMLBody:      content.HTMLBody,
+		TextBody:      content.TextBody,
+		Status:        models.OutboxPending,
+		NextAttemptAt: time.Now(),
+	}, nil
+}
+
+// Wake makes the sender check the outbox without waiting for the next poll
+func (s *OutboxSender) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due mails until ctx is cancelled
+func (s *OutboxSender) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.SendDue()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// SendDue attempts delivery of all mails which are due
+func (s *OutboxSender) SendDue() {
+	now := time.Now()
+	mails, err := s.db.ListDueMails(now, outboxBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list due mails")
+		return
+	}
+
+	for _, mail := range mails {
+		// another instance may have listed the same mail
+		claimed, err := s.db.ClaimOutboxMail(mail.ID, now, time.Now().Add(outboxClaimLease))
+		if err != nil {
+			log.Error().Err(err).Int("mail_id", mail.ID).Msg("failed to claim outbox mail")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		s.send(mail)
+	}
+}
+
+// send attempts delivery of a mail and records the outcome
+func (s *OutboxSender) send(mail models.OutboxMail) {
+	err := s.mailService.SendMail(mail.Sender, mail.Receiver, MailContent{
+		Subject:  mail.Subject,
+		HTMLBody: mail.HTMLBody,
+		TextBody: mail.TextBody,
+	})
+
+	mail.Attempts++
+	now := time.Now()
+
+	if err == nil {
+		mail.Status = models.OutboxSent
+		mail.SentAt = &now
+		mail.LastError = ""
+	} else {
+		mail.LastError = err.Error()
+		if mail.Attempts >= s.maxAttempts {
+			mail.Status = models.OutboxDead
+			log.Error().Err(err).Int("mail_id", mail.ID).Int("attempts", mail.Attempts).Msg("mail moved to dead letter")
+		} else {
+			mail.NextAttemptAt = now.Add(retryBackoff(mail.Attempts))
+			log.Warn().Err(err).Int("mail_id", mail.ID).Time("next_attempt_at", mail.NextAttemptAt).Msg("failed to send mail, will retry")
+		}
+	}
+
+	if err := s.db.UpdateOutboxMail(&mail); err != nil {
+		log.Error().Err(err).Int("mail_id", mail.ID).Msg("failed to update outbox mail")
+	}
+}
+
+// retryBackoff is the delay before the next attempt after a number of failed attempts
+func retryBackoff(attempts int) time.Duration {
+	backoff := float64(retryBaseBackoff) * math.Pow(2, float64(attempts-1))
+	if backoff > float64(retryMaxBackoff) {
+		return retryMaxBackoff
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"errors"
+	"kubecloud/models"
+	"kubecloud/models/sqlite"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMailer counts sent mails and fails while err is set
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []Message
+	err  error
+}
+
+func (m *recordingMailer) Send(message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+func newTestOutbox(t *testing.T, maxAttempts int) (models.DB, *recordingMailer, *OutboxSender) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	mailer := &recordingMailer{}
+	return db, mailer, NewOutboxSender(db, NewMailService(mailer, nil), maxAttempts, 0)
+}
+
+func enqueueTestMail(t *testing.T, db models.DB) models.OutboxMail {
+	t.Helper()
+
+	mail, err := NewOutboxMail("noreply@kubecloud.io", "user@kubecloud.io", MailContent{Subject: "Welcome", TextBody: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnqueueMail(&mail); err != nil {
+		t.Fatal(err)
+	}
+	return mail
+}
+
+func TestOutboxRetry(t *testing.T) {
+	db, mailer, outbox := newTestOutbox(t, 3)
+	mail := enqueueTestMail(t, db)
+	mailer.err = errors.New("relay is down")
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		start := time.Now()
+		outbox.SendDue()
+
+		mail, err := db.GetOutboxMail(mail.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mail.Attempts != attempt || mail.LastError != "relay is down" {
+			t.Fatalf("expected attempt %d to be recorded, got %+v", attempt, mail)
+		}
+
+		if attempt == 3 {
+			if mail.Status != models.OutboxDead {
+				t.Fatalf("expected the mail to be dead after all attempts, got %s", mail.Status)
+			}
+			break
+		}
+
+		if mail.Status != models.OutboxPending || mail.NextAttemptAt.Before(start.Add(retryBackoff(attempt))) {
+			t.Fatalf("expected attempt %d to be retried after backoff, got %+v", attempt, mail)
+		}
+
+		// not due yet
+		outbox.SendDue()
+		if again, _ := db.GetOutboxMail(mail.ID); again.Attempts != attempt {
+			t.Fatal("expected the mail to wait for its backoff")
+		}
+
+		mail.NextAttemptAt = time.Now().Add(-time.Second)
+		if err := db.UpdateOutboxMail(&mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dead mails are left for admins
+	mailer.err = nil
+	outbox.SendDue()
+	if len(mailer.sent) != 0 {
+		t.Fatal("expected dead mails not to be sent")
+	}
+}
+
+func TestOutboxSent(t *testing.T) {
+	db, mailer, outbox := newTestOutbox(t, 3)
+	mail := enqueueTestMail(t, db)
+
+	outbox.SendDue()
+	outbox.SendDue()
+
+	mail, err := db.GetOutboxMail(mail.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail.Status != models.OutboxSent || mail.SentAt == nil || mail.Attempts != 1 {
+		t.Fatalf("expected the mail to be sent, got %+v", mail)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "user@kubecloud.io" {
+		t.Fatalf("expected the mail to be sent once, got %+v", mailer.sent)
+	}
+}
+
+func TestOutboxRetention(t *testing.T) {
+	db, _, outbox := newTestOutbox(t, 3)
+	sent := enqueueTestMail(t, db)
+	outbox.SendDue()
+	pending := enqueueTestMail(t, db)
+
+	if deleted, err := db.DeleteSentMailsBefore(time.Now().Add(-time.Hour)); err != nil || deleted != 0 {
+		t.Fatalf("expected a recently sent mail to be kept, got %d %v", deleted, err)
+	}
+
+	mails, err := db.ListOutboxMails("", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 || mails[0].ID != sent.ID {
+		t.Fatalf("expected the second page to hold the older mail, got %+v", mails)
+	}
+
+	if deleted, err := db.DeleteSentMailsBefore(time.Now().Add(time.Minute)); err != nil || deleted != 1 {
+		t.Fatalf("expected the sent mail to be deleted, got %d %v", deleted, err)
+	}
+	mails, err = db.ListOutboxMails("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 || mails[0].ID != pending.ID {
+		t.Fatalf("expected only the pending mail to be kept, got %+v", mails)
+	}
+}
+
+func TestOutboxClaim(t *testing.T) {
+	db, mailer, outbox := newTestOutbox(t, 3)
+	for i := 0; i < 10; i++ {
+		enqueueTestMail(t, db)
+	}
+
+	// a second sender stands for another instance sharing the database
+	replicas := []*OutboxSender{outbox, NewOutboxSender(db, NewMailService(mailer, nil), 3, 0)}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(sender *OutboxSender) {
+			defer wg.Done()
+			sender.SendDue()
+		}(replicas[i%2])
+	}
+	wg.Wait()
+
+	if len(mailer.sent) != 10 {
+		t.Fatalf("expected each mail to be sent once, got %d sends", len(mailer.sent))
+	}
+
+	mail := enqueueTestMail(t, db)
+	now := time.Now()
+	if claimed, err := db.ClaimOutboxMail(mail.ID, now, now.Add(time.Minute)); err != nil || !claimed {
+		t.Fatalf("expected the mail to be claimed, got %v %v", claimed, err)
+	}
+	if claimed, err := db.ClaimOutboxMail(mail.ID, now, now.Add(time.Minute)); err != nil || claimed {
+		t.Fatalf("expected a claimed mail not to be claimed again, got %v %v", claimed, err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: retryMaxBackoff,
+	}
+
+	for attempts, backoff := range cases {
+		if got := retryBackoff(attempts); got != backoff {
+			t.Errorf("expected backoff %s after %d attempts, got %s", backoff, attempts, got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"kubecloud/models"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// RateLimitPolicyMail limits routes sending mails
+	RateLimitPolicyMail = "mail"
+	// RateLimitPolicyMailTarget limits routes sending mails per receiving address
+	RateLimitPolicyMailTarget = "mail_target"
+	// RateLimitPolicyAuth limits routes checking credentials
+	RateLimitPolicyAuth = "auth"
+	// RateLimitPolicyUser limits routes of logged in users
+	RateLimitPolicyUser = "user"
+
+	// memorySweepInterval is how often full buckets are dropped from memory
+	memorySweepInterval = time.Minute
+)
+
+// DefaultRateLimitPolicies are used for policies missing from configuration
+var DefaultRateLimitPolicies = map[string]RateLimitPolicy{
+	RateLimitPolicyMail:       {Requests: 5, PeriodSeconds: 900},
+	RateLimitPolicyMailTarget: {Requests: 3, PeriodSeconds: 3600},
+	RateLimitPolicyAuth:       {Requests: 10, PeriodSeconds: 60},
+	RateLimitPolicyUser:       {Requests: 120, PeriodSeconds: 60},
+}
+
+// RateLimitResult describes the bucket of a key after taking a token from it
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until bucket is full again
+	RetryAfter time.Duration // until next token, set if not allowed
+}
+
+// RateLimitStore keeps token buckets, stores shared between replicas make limits global
+type RateLimitStore interface {
+	// Take removes a token from bucket of key if one is available
+	Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimiter applies named policies to keys
+type RateLimiter struct {
+	store    RateLimitStore
+	policies map[string]RateLimitPolicy
+}
+
+// NewRateLimiter creates a rate limiter from configuration, it returns nil if rate limiting is disabled
+func NewRateLimiter(config RateLimit, db models.DB) (*RateLimiter, error) {
+	if config.Disabled {
+		return nil, nil
+	}
+
+	policies := map[string]RateLimitPolicy{}
+	for name, policy := range DefaultRateLimitPolicies {
+		policies[name] = policy
+	}
+
+	for name, policy := range config.Policies {
+		if policy.Requests <= 0 || policy.PeriodSeconds <= 0 || policy.Burst < 0 {
+			return nil, fmt.Errorf("rate limit policy %q needs positive requests and period_seconds", name)
+		}
+		policies[name] = policy
+	}
+
+	var store RateLimitStore
+	switch config.Store {
+	case "", "memory":
+		store = NewMemoryRateLimitStore()
+	case "database":
+		store = NewDatabaseRateLimitStore(db)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", config.Store)
+	}
+
+	return &RateLimiter{store: store, policies: policies}, nil
+}
+
+// Policy returns policy by its name
+func (l *RateLimiter) Policy(name string) (RateLimitPolicy, bool) {
+	policy, ok := l.policies[name]
+	return policy, ok
+}
+
+// Take removes a token of policy from bucket of key
+func (l *RateLimiter) Take(policyName, key string) (RateLimitResult, error) {
+	policy, ok := l.policies[policyName]
+	if !ok {
+		return RateLimitResult{}, fmt.Errorf("rate limit policy %q is not found", policyName)
+	}
+
+	return l.store.Take(policyName+":"+key, policy, time.Now())
+}
+
+// Capacity returns the maximum tokens of a bucket
+func (p RateLimitPolicy) Capacity() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Requests
+}
+
+// refillRate returns tokens added per second
+func (p RateLimitPolicy) refillRate() float64 {
+	return float64(p.Requests) / float64(p.PeriodSeconds)
+}
+
+// takeToken refills tokens left at updatedAt until now and takes one if available
+func takeToken(tokens float64, updatedAt, now time.Time, policy RateLimitPolicy) (float64, RateLimitResult) {
+	capacity := float64(policy.Capacity())
+	rate := policy.refillRate()
+
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(capacity, tokens+elapsed*rate)
+	}
+
+	result := RateLimitResult{Limit: policy.Capacity()}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = secondsDuration((capacity - tokens) / rate)
+	return tokens, result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in memory, limits are per replica
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitStore creates a new in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+}
+
+// Take removes a token from bucket of key if one is available
+func (s *MemoryRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// full buckets are the same as missing ones
+	if now.Sub(s.lastSweep) > memorySweepInterval {
+		for k, bucket := range s.buckets {
+			if now.After(bucket.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(policy.Capacity()), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	tokens, result := takeToken(bucket.tokens, bucket.updatedAt, now, policy)
+	bucket.tokens = tokens
+	bucket.updatedAt = now
+	bucket.fullAt = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// DatabaseRateLimitStore keeps buckets in the database so replicas sharing it share limits
+type DatabaseRateLimitStore struct {
+	db models.DB
+}
+
+// NewDatabaseRateLimitStore creates a new database store
+func NewDatabaseRateLimitStore(db models.DB) *DatabaseRateLimitStore {
+	return &DatabaseRateLimitStore{db: db}
+}
+
+// Take removes a token from bucket of key if one is available. The transaction takes the write lock
+// when it begins, so replicas reading the same bucket wait for each other instead of losing updates.
+func (s *DatabaseRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	var result RateLimitResult
+
+	err := s.db.Transaction(func(tx models.DB) error {
+		bucket, err := tx.GetRateLimitBucket(key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			bucket = models.RateLimitBucket{Key: key, Tokens: float64(policy.Capacity()), UpdatedAt: now}
+		} else if err != nil {
+			return err
+		}
+
+		bucket.Tokens, result = takeToken(bucket.Tokens, bucket.UpdatedAt, now, policy)
+		bucket.UpdatedAt = now
+		return tx.SaveRateLimitBucket(&bucket)
+	})
+
+	return result, err
+}
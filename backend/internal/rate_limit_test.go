@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"kubecloud/models/sqlite"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	policy := RateLimitPolicy{Requests: 2, PeriodSeconds: 60, Burst: 3}
+	now := time.Now()
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take("key", policy, now)
+		if err != nil || !result.Allowed || result.Remaining != remaining || result.Limit != 3 {
+			t.Fatalf("expected a token to be taken leaving %d, got %+v %v", remaining, result, err)
+		}
+	}
+
+	result, _ := store.Take("key", policy, now)
+	if result.Allowed || result.RetryAfter != 30*time.Second || result.Reset != 90*time.Second {
+		t.Fatalf("expected an empty bucket to refuse for 30s, got %+v", result)
+	}
+	if other, _ := store.Take("other", policy, now); !other.Allowed {
+		t.Fatal("expected keys to have their own bucket")
+	}
+
+	if result, _ := store.Take("key", policy, now.Add(30*time.Second)); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected a token to be refilled after 30s, got %+v", result)
+	}
+	if result, _ := store.Take("key", policy, now.Add(time.Hour)); !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("expected the bucket to refill up to its burst, got %+v", result)
+	}
+}
+
+func TestDatabaseRateLimitStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "db.sqlite")
+	policy := RateLimitPolicy{Requests: 20, PeriodSeconds: 3600}
+
+	// each store stands for a replica with its own connections to the shared database
+	var stores []*DatabaseRateLimitStore
+	for i := 0; i < 2; i++ {
+		db, err := sqlite.NewSqliteStorage(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		stores = append(stores, NewDatabaseRateLimitStore(db))
+	}
+
+	var (
+		mu      sync.Mutex
+		allowed int
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(store *DatabaseRateLimitStore) {
+			defer wg.Done()
+
+			result, err := store.Take("key", policy, time.Now())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+
+	if allowed != policy.Requests {
+		t.Fatalf("expected %d of concurrent requests to be allowed, got %d", policy.Requests, allowed)
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if limiter, err := NewRateLimiter(RateLimit{Disabled: true}, nil); limiter != nil || err != nil {
+		t.Fatalf("expected no limiter when disabled, got %v %v", limiter, err)
+	}
+	if _, err := NewRateLimiter(RateLimit{Store: "redis"}, nil); err == nil {
+		t.Fatal("expected an unknown store to be refused")
+	}
+	if _, err := NewRateLimiter(RateLimit{Policies: map[string]RateLimitPolicy{RateLimitPolicyAuth: {Requests: 1}}}, nil); err == nil {
+		t.Fatal("expected a policy without a period to be refused")
+	}
+
+	limiter, err := NewRateLimiter(RateLimit{Policies: map[string]RateLimitPolicy{RateLimitPolicyAuth: {Requests: 1, PeriodSeconds: 60}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy, _ := limiter.Policy(RateLimitPolicyUser); policy != DefaultRateLimitPolicies[RateLimitPolicyUser] {
+		t.Fatalf("expected policies missing from configuration to use defaults, got %+v", policy)
+	}
+	if _, err := limiter.Take("missing", "key"); err == nil {
+		t.Fatal("expected an unknown policy to fail")
+	}
+	if first, _ := limiter.Take(RateLimitPolicyAuth, "key"); !first.Allowed {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if second, _ := limiter.Take(RateLimitPolicyAuth, "key"); second.Allowed {
+		t.Fatal("expected the configured policy to apply")
+	}
+}
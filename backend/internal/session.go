@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+const (
+	// SessionAccessCookie holds the access token of a cookie session
+	SessionAccessCookie = "kubecloud_access"
+	// SessionRefreshCookie holds the refresh token of a cookie session, it is only sent to the refresh endpoint
+	SessionRefreshCookie = "kubecloud_refresh"
+	// CSRFCookie holds the CSRF token of a cookie session, readable by scripts to send it back in CSRFHeader
+	CSRFCookie = "kubecloud_csrf"
+	// CSRFHeader must repeat the CSRF cookie on requests changing state with a cookie session
+	CSRFHeader = "X-CSRF-Token"
+
+	sessionCookiePath = "/api"
+	refreshCookiePath = "/api/v1/user/refresh"
+)
+
+// Sessions sets and reads the cookies of cookie sessions
+type Sessions struct {
+	config        Session
+	accessExpiry  time.Duration
+	refreshExpiry time.Duration
+	csrfKey       []byte
+}
+
+// NewSessions creates the cookie session mode, nil if it is disabled
+func NewSessions(config Session, token JwtToken) *Sessions {
+	if !config.Enabled {
+		return nil
+	}
+
+	key := sha256.Sum256([]byte("kubecloud csrf token|" + token.Secret))
+	return &Sessions{
+		config:        config,
+		accessExpiry:  time.Duration(token.AccessTokenExpiryMinutes) * time.Minute,
+		refreshExpiry: time.Duration(token.RefreshTokenExpiryHours) * time.Hour,
+		csrfKey:       key[:],
+	}
+}
+
+// Start sets the cookies of a new session holding tokenPair and returns its CSRF token
+func (s *Sessions) Start(w http.ResponseWriter, tokenPair *TokenPair) string {
+	csrfToken := s.csrfToken(tokenPair.SessionID)
+
+	s.setCookie(w, SessionAccessCookie, tokenPair.AccessToken, sessionCookiePath, s.accessExpiry, true)
+	s.setCookie(w, SessionRefreshCookie, tokenPair.RefreshToken, refreshCookiePath, s.refreshExpiry, true)
+	s.setCookie(w, CSRFCookie, csrfToken, "/", s.refreshExpiry, false)
+	return csrfToken
+}
+
+// Refresh replaces the access token of session sessionID and returns its CSRF token
+func (s *Sessions) Refresh(w http.ResponseWriter, accessToken, sessionID string) string {
+	s.setCookie(w, SessionAccessCookie, accessToken, sessionCookiePath, s.accessExpiry, true)
+	return s.csrfToken(sessionID)
+}
+
+// End clears the cookies of a session
+func (s *Sessions) End(w http.ResponseWriter) {
+	s.setCookie(w, SessionAccessCookie, "", sessionCookiePath, -1, true)
+	s.setCookie(w, SessionRefreshCookie, "", refreshCookiePath, -1, true)
+	s.setCookie(w, CSRFCookie, "", "/", -1, false)
+}
+
+// AccessToken returns the access token of the session of r, empty if r has no session
+func (s *Sessions) AccessToken(r *http.Request) string {
+	return cookieValue(r, SessionAccessCookie)
+}
+
+// RefreshToken returns the refresh token of the session of r, empty if r has no session
+func (s *Sessions) RefreshToken(r *http.Request) string {
+	return cookieValue(r, SessionRefreshCookie)
+}
+
+// VerifyCSRF reports whether r sends the CSRF token of session sessionID in CSRFHeader, requests not changing
+// state always pass. The token is derived from the session, so a CSRF cookie planted by another site can't match.
+func (s *Sessions) VerifyCSRF(r *http.Request, sessionID string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	header := r.Header.Get(CSRFHeader)
+	return sessionID != "" && subtle.ConstantTimeCompare([]byte(s.csrfToken(sessionID)), []byte(header)) == 1
+}
+
+// csrfToken returns the CSRF token of session sessionID
+func (s *Sessions) csrfToken(sessionID string) string {
+	mac := hmac.New(sha256.New, s.csrfKey)
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setCookie sets a session cookie expiring after maxAge, a negative maxAge deletes it
+func (s *Sessions) setCookie(w http.ResponseWriter, name, value, path string, maxAge time.Duration, httpOnly bool) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   s.config.CookieDomain,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   !s.config.Insecure,
+		HttpOnly: httpOnly,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+
+	switch s.config.SameSite {
+	case "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+func cookieValue(r *http.Request, name string) string {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
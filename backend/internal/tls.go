@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// certificateCheckInterval is how often certificate files are checked for rotation
+	certificateCheckInterval = 10 * time.Second
+
+	defaultACMECacheDir = "acme-cache"
+)
+
+// ServerTLS holds the TLS configuration of the server, with certificates either read from files or issued by ACME
+type ServerTLS struct {
+	Config  *tls.Config
+	manager *autocert.Manager
+}
+
+// NewServerTLS creates the TLS configuration of the server, nil if TLS is disabled
+func NewServerTLS(config TLS) (*ServerTLS, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	if config.ACME.Enabled {
+		manager, err := newACMEManager(config.ACME)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return &ServerTLS{Config: tlsConfig, manager: manager}, nil
+	}
+
+	certificates, err := NewCertificateReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServerTLS{
+		Config: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificates.GetCertificate,
+		},
+	}, nil
+}
+
+// HTTPHandler answers ACME HTTP-01 challenges and passes other requests to fallback
+func (t *ServerTLS) HTTPHandler(fallback http.Handler) http.Handler {
+	if t.manager == nil {
+		return fallback
+	}
+	return t.manager.HTTPHandler(fallback)
+}
+
+func newACMEManager(config ACME) (*autocert.Manager, error) {
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultACMECacheDir
+	}
+
+	client := &acme.Client{DirectoryURL: config.DirectoryURL}
+	if config.CAFile != "" {
+		// trusts a local ACME server like pebble, which serves its directory with its own certificate
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read acme ca file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("acme ca file %s has no certificates", config.CAFile)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      config.Email,
+		Client:     client,
+	}, nil
+}
+
+// CertificateReloader serves a certificate read from files, read again once they change so certificates
+// can be rotated without a restart
+type CertificateReloader struct {
+	certFile, keyFile string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time // latest modification time of both files
+	checkedAt   time.Time
+}
+
+// NewCertificateReloader creates a new certificate reloader, failing if the certificate can't be loaded
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, it is meant for tls.Config. A certificate failing
+// to load is logged and the previous one kept.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= certificateCheckInterval {
+		r.checkedAt = time.Now()
+
+		modTime, err := r.latestModTime()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to check certificate files")
+		} else if !modTime.Equal(r.modTime) {
+			if err := r.load(); err != nil {
+				log.Error().Err(err).Msg("failed to reload certificate, the previous one is kept")
+			} else {
+				log.Info().Str("cert_file", r.certFile).Msg("certificate reloaded")
+			}
+		}
+	}
+
+	return r.certificate, nil
+}
+
+func (r *CertificateReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.certificate = &certificate
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+	return nil
+}
+
+func (r *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat certificate file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, block := range map[string]*pem.Block{certFile: {Type: "CERTIFICATE", Bytes: der}, keyFile: {Type: "EC PRIVATE KEY", Bytes: keyDER}} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, "first", time.Now().Add(-time.Minute))
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func() string {
+		t.Helper()
+		certificate, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+
+	writeCertificate(t, certFile, keyFile, "second", time.Now())
+	if name := commonName(); name != "first" {
+		t.Fatalf("expected the certificate to be kept until the next check, got %s", name)
+	}
+
+	reloader.checkedAt = time.Time{}
+	if name := commonName(); name != "second" {
+		t.Fatalf("expected the rotated certificate, got %s", name)
+	}
+
+	if err := os.WriteFile(certFile, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	reloader.checkedAt = time.Time{}
+	if name := commonName(); name != "second" {
+		t.Fatalf("expected the previous certificate to be kept on a failed reload, got %s", name)
+	}
+}
+
+// acmeStub is an in-process ACME server, it validates HTTP-01 challenges against challengeURL and
+// signs certificates with its own key
+type acmeStub struct {
+	t            *testing.T
+	server       *httptest.Server
+	challengeURL string
+	key          *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	domain string
+	valid  bool
+	cert   []byte
+	orders int
+}
+
+func newACMEStub(t *testing.T) *acmeStub {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &acmeStub{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		stub.respond(w, http.StatusOK, "", map[string]string{
+			"newNonce":   stub.server.URL + "/nonce",
+			"newAccount": stub.server.URL + "/account",
+			"newOrder":   stub.server.URL + "/order",
+			"revokeCert": stub.server.URL + "/revoke",
+			"keyChange":  stub.server.URL + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", stub.nonce())
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		stub.respond(w, http.StatusCreated, stub.server.URL+"/account/1", map[string]string{"status": acme.StatusValid})
+	})
+	mux.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
+		var order struct {
+			Identifiers []struct{ Value string }
+		}
+		stub.payload(r, &order)
+
+		stub.mu.Lock()
+		stub.orders++
+		stub.domain = order.Identifiers[0].Value
+		stub.valid = false
+		stub.mu.Unlock()
+
+		stub.respond(w, http.StatusCreated, stub.server.URL+"/order/1", stub.order())
+	})
+	mux.HandleFunc("/order/1", func(w http.ResponseWriter, r *http.Request) {
+		stub.respond(w, http.StatusOK, stub.server.URL+"/order/1", stub.order())
+	})
+	mux.HandleFunc("/authz/1", func(w http.ResponseWriter, r *http.Request) {
+		stub.respond(w, http.StatusOK, "", stub.authorization())
+	})
+	mux.HandleFunc("/challenge/1", func(w http.ResponseWriter, r *http.Request) {
+		stub.validate()
+		stub.respond(w, http.StatusOK, "", stub.challenge())
+	})
+	mux.HandleFunc("/finalize/1", func(w http.ResponseWriter, r *http.Request) {
+		var finalize struct{ CSR string }
+		stub.payload(r, &finalize)
+		stub.sign(finalize.CSR)
+		stub.respond(w, http.StatusOK, stub.server.URL+"/order/1", stub.order())
+	})
+	mux.HandleFunc("/cert/1", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		w.Header().Set("Replay-Nonce", stub.nonce())
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: stub.cert})
+	})
+
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *acmeStub) nonce() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func (s *acmeStub) respond(w http.ResponseWriter, status int, location string, body any) {
+	w.Header().Set("Replay-Nonce", s.nonce())
+	w.Header().Set("Content-Type", "application/json")
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// payload decodes the payload of a JWS request, signatures are not checked
+func (s *acmeStub) payload(r *http.Request, v any) {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		s.t.Errorf("invalid jws: %v", err)
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		s.t.Errorf("invalid jws payload: %v", err)
+		return
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		s.t.Errorf("invalid jws payload: %v", err)
+	}
+}
+
+func (s *acmeStub) order() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order := map[string]any{
+		"status":         acme.StatusPending,
+		"identifiers":    []map[string]string{{"type": "dns", "value": s.domain}},
+		"authorizations": []string{s.server.URL + "/authz/1"},
+		"finalize":       s.server.URL + "/finalize/1",
+	}
+	switch {
+	case s.cert != nil:
+		order["status"] = acme.StatusValid
+		order["certificate"] = s.server.URL + "/cert/1"
+	case s.valid:
+		order["status"] = acme.StatusReady
+	}
+	return order
+}
+
+func (s *acmeStub) authorization() map[string]any {
+	status := acme.StatusPending
+	s.mu.Lock()
+	if s.valid {
+		status = acme.StatusValid
+	}
+	domain := s.domain
+	s.mu.Unlock()
+
+	return map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": domain},
+		"challenges": []map[string]string{s.challenge()},
+	}
+}
+
+func (s *acmeStub) challenge() map[string]string {
+	status := acme.StatusPending
+	s.mu.Lock()
+	if s.valid {
+		status = acme.StatusValid
+	}
+	s.mu.Unlock()
+
+	return map[string]string{"type": "http-01", "url": s.server.URL + "/challenge/1", "token": "token", "status": status}
+}
+
+// validate fetches the key authorization of the challenge the way a CA would, from the domain over HTTP
+func (s *acmeStub) validate() {
+	s.mu.Lock()
+	domain := s.domain
+	s.mu.Unlock()
+
+	request, err := http.NewRequest(http.MethodGet, s.challengeURL+"/.well-known/acme-challenge/token", nil)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	request.Host = domain
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	if response.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "token.") {
+		s.t.Errorf("expected the key authorization of the challenge, got %d %q", response.StatusCode, body)
+		return
+	}
+
+	s.mu.Lock()
+	s.valid = true
+	s.mu.Unlock()
+}
+
+func (s *acmeStub) sign(encodedCSR string) {
+	der, err := base64.RawURLEncoding.DecodeString(encodedCSR)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "acme stub"},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	issuer := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "acme stub ca"}}
+	cert, err := x509.CreateCertificate(rand.Reader, template, issuer, csr.PublicKey, s.key)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+
+	s.mu.Lock()
+	s.cert = cert
+	s.mu.Unlock()
+}
+
+func TestACME(t *testing.T) {
+	stub := newACMEStub(t)
+	config := TLS{Enabled: true, ACME: ACME{
+		Enabled:      true,
+		Domains:      []string{"kubecloud.test"},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: stub.server.URL + "/directory",
+	}}
+
+	serverTLS, err := NewServerTLS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challengeServer := httptest.NewServer(serverTLS.HTTPHandler(http.NotFoundHandler()))
+	defer challengeServer.Close()
+	stub.challengeURL = challengeServer.URL
+
+	certificate, err := serverTLS.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: "kubecloud.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := certificate.Leaf.VerifyHostname("kubecloud.test"); err != nil {
+		t.Fatalf("expected a certificate for the domain: %v", err)
+	}
+
+	if _, err := serverTLS.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.test"}); err == nil {
+		t.Fatal("expected a domain missing from the config to be refused")
+	}
+
+	// a restarted server serves the certificate from the cache instead of ordering another one
+	restarted, err := NewServerTLS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: "kubecloud.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if stub.orders != 1 {
+		t.Fatalf("expected a single order, got %d", stub.orders)
+	}
+}
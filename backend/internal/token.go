@@ -1,17 +1,27 @@
 package internal
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
+const (
+	// TokenTypeAccess is the type of short-lived tokens authenticating requests
+	TokenTypeAccess = "access"
+	// TokenTypeRefresh is the type of long-lived tokens only accepted to get new access tokens
+	TokenTypeRefresh = "refresh"
+)
+
 // TokenManager defines the interface for token operations.
 type TokenManager interface {
 	CreateTokenPair(userID int, username string, isAdmin bool) (*TokenPair, error)
-	VerifyToken(tokenString string) (*TokenClaims, error)
-	AccessTokenFromRefresh(refreshToken string) (string, error)
+	CreateOrganizationTokenPair(userID int, username string, isAdmin bool, organizationID int) (*TokenPair, error)
+	VerifyToken(tokenString, tokenType string) (*TokenClaims, error)
+	AccessTokenFromRefresh(refreshToken string, isAdmin bool) (string, error)
 }
 
 // TokenHandler struct holds the JWT operations
@@ -25,14 +35,18 @@ type TokenHandler struct {
 type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	SessionID    string `json:"-"`
 }
 
 // TokenClaims represents the claims in a JWT token
 type TokenClaims struct {
 	jwt.RegisteredClaims
-	Username string `json:"username"`
-	UserID   int    `json:"user_id"`
-	Admin    bool   `json:"admin"`
+	Username       string `json:"username"`
+	UserID         int    `json:"user_id"`
+	Admin          bool   `json:"admin"`
+	OrganizationID int    `json:"organization_id,omitempty"` // active organization, personal account if not set
+	Type           string `json:"token_type"`                // access or refresh
+	SessionID      string `json:"session_id"`                // shared by the tokens of a login, revoked on logout
 }
 
 func NewTokenHandler(secretKey string, accessExpiry, refreshExpiry time.Duration) *TokenHandler {
@@ -45,22 +59,40 @@ func NewTokenHandler(secretKey string, accessExpiry, refreshExpiry time.Duration
 
 // CreateTokenPair generates a new access and refresh token pair
 func (h *TokenHandler) CreateTokenPair(userID int, username string, isAdmin bool) (*TokenPair, error) {
-	accessToken, err := h.createToken(userID, username, isAdmin, h.accessExpiry)
+	return h.CreateOrganizationTokenPair(userID, username, isAdmin, 0)
+}
+
+// CreateOrganizationTokenPair generates a new access and refresh token pair with an active organization
+func (h *TokenHandler) CreateOrganizationTokenPair(userID int, username string, isAdmin bool, organizationID int) (*TokenPair, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
+	}
+	claims := TokenClaims{
+		Username:       username,
+		UserID:         userID,
+		Admin:          isAdmin,
+		OrganizationID: organizationID,
+		SessionID:      base64.RawURLEncoding.EncodeToString(random),
+	}
+
+	accessToken, err := h.createToken(claims, TokenTypeAccess, h.accessExpiry)
 	if err != nil {
 		return nil, err
 	}
-	refreshToken, err := h.createToken(userID, username, isAdmin, h.refreshExpiry)
+	refreshToken, err := h.createToken(claims, TokenTypeRefresh, h.refreshExpiry)
 	if err != nil {
 		return nil, err
 	}
 	return &TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		SessionID:    claims.SessionID,
 	}, nil
 }
 
-// VerifyToken verifies the token and returns the claims
-func (h *TokenHandler) VerifyToken(tokenString string) (*TokenClaims, error) {
+// VerifyToken verifies the token is valid and of tokenType and returns the claims
+func (h *TokenHandler) VerifyToken(tokenString, tokenType string) (*TokenClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return h.secretKey, nil
 	})
@@ -81,32 +113,35 @@ func (h *TokenHandler) VerifyToken(tokenString string) (*TokenClaims, error) {
 		return nil, fmt.Errorf("token has expired")
 	}
 
+	// a leaked access token must not be refreshed and a refresh token must not authenticate requests
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("expected %s token, got %q", tokenType, claims.Type)
+	}
+
 	return claims, nil
 }
 
-// createToken creates token with given expiry time
-func (h *TokenHandler) createToken(userID int, username string, isAdmin bool, expiry time.Duration) (string, error) {
-	claims := TokenClaims{
-		Username: username,
-		UserID:   userID,
-		Admin:    isAdmin,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// createToken creates token of tokenType from claims with given expiry time
+func (h *TokenHandler) createToken(claims TokenClaims, tokenType string, expiry time.Duration) (string, error) {
+	claims.Type = tokenType
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(h.secretKey)
 }
 
-// RefreshAccessToken refreshes the access token using a refresh token
-func (h *TokenHandler) AccessTokenFromRefresh(refreshToken string) (string, error) {
-	claims, err := h.VerifyToken(refreshToken)
+// AccessTokenFromRefresh refreshes the access token using a refresh token, the admin role is given by the caller
+// so changes since the login take effect
+func (h *TokenHandler) AccessTokenFromRefresh(refreshToken string, isAdmin bool) (string, error) {
+	claims, err := h.VerifyToken(refreshToken, TokenTypeRefresh)
 	if err != nil {
 		return "", err
 	}
+	claims.Admin = isAdmin
 
-	accessToken, err := h.createToken(claims.UserID, claims.Username, claims.Admin, h.refreshExpiry)
+	accessToken, err := h.createToken(*claims, TokenTypeAccess, h.accessExpiry)
 	if err != nil {
 		return "", err
 	}
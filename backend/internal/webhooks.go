@@ -0,0 +1,380 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kubecloud/models"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	webhookTimeout         = 10 * time.Second
+	webhookBatchSize       = 50
+	webhookMaxAttempts     = 8
+	webhookPolling         = 5 * time.Second
+	webhookResponseLimit   = 1024
+	webhookSignatureHeader = "X-KubeCloud-Signature"
+	// webhookClaimLease outlasts an attempt so a claimed delivery is only retried if its dispatcher died
+	webhookClaimLease = 5 * time.Minute
+)
+
+// WebhookPayload is the body posted to webhooks
+type WebhookPayload struct {
+	Event          string      `json:"event"`
+	UserID         int         `json:"user_id"`
+	OrganizationID int         `json:"organization_id,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Data           interface{} `json:"data"`
+}
+
+// errBlockedAddress is returned when a webhook resolves to an address webhooks may not reach
+var errBlockedAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is used by carrier-grade NAT and some cloud metadata services
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// WebhookDispatcher queues events for webhooks and delivers them in the background
+type WebhookDispatcher struct {
+	db     models.DB
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewWebhookDispatcher creates a new webhook dispatcher, deliveries to internal addresses are refused
+// unless they are in one of the allowed networks of config
+func NewWebhookDispatcher(db models.DB, config Webhooks) (*WebhookDispatcher, error) {
+	var allowed []*net.IPNet
+	for _, cidr := range config.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed webhook network %q: %w", cidr, err)
+		}
+		allowed = append(allowed, network)
+	}
+
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl(allowed)}
+	client := &http.Client{
+		Timeout: webhookTimeout,
+		// no proxy, every connection has to be dialed through the address check
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+		// a redirect could lead to an internal address, it counts as a failed delivery
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &WebhookDispatcher{
+		db:     db,
+		client: client,
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// webhookDialControl refuses connections to loopback, private, link-local and unspecified addresses outside of
+// allowed networks. It checks the address being dialed after DNS resolution, so names resolving to such
+// addresses are refused too.
+func webhookDialControl(allowed []*net.IPNet) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("%w: %s", errBlockedAddress, host)
+		}
+
+		for _, network := range allowed {
+			if network.Contains(ip) {
+				return nil
+			}
+		}
+
+		if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+			ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+			return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+		}
+		return nil
+	}
+}
+
+// GenerateWebhookSecret generates the secret webhook payloads are signed with
+func GenerateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// SignWebhookPayload signs timestamp and payload with secret, receivers should compute the same signature
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish queues event about a user to its own webhooks and global ones
+func (d *WebhookDispatcher) Publish(userID int, event string, data interface{}) error {
+	return d.publish(userID, event, data, func(webhook models.Webhook) bool { return true })
+}
+
+// PublishToUser queues event about a user to its own webhooks only
+func (d *WebhookDispatcher) PublishToUser(userID int, event string, data interface{}) error {
+	return d.publish(userID, event, data, func(webhook models.Webhook) bool { return !webhook.Global })
+}
+
+// PublishGlobal queues event about a user to global webhooks only
+func (d *WebhookDispatcher) PublishGlobal(userID int, event string, data interface{}) error {
+	return d.publish(userID, event, data, func(webhook models.Webhook) bool { return webhook.Global })
+}
+
+// PublishToOrganization queues event about an organization to its webhooks and global ones
+func (d *WebhookDispatcher) PublishToOrganization(organizationID int, event string, data interface{}) error {
+	webhooks, err := d.db.ListOrganizationWebhooks(organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	global, err := d.db.ListEventWebhooks(0)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	payload := WebhookPayload{Event: event, OrganizationID: organizationID, CreatedAt: time.Now(), Data: data}
+	return d.queue(append(webhooks, global...), payload, func(webhook models.Webhook) bool {
+		return webhook.Active && (webhook.Global || webhook.OrganizationID == organizationID)
+	})
+}
+
+func (d *WebhookDispatcher) publish(userID int, event string, data interface{}, include func(models.Webhook) bool) error {
+	webhooks, err := d.db.ListEventWebhooks(userID)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	payload := WebhookPayload{Event: event, UserID: userID, CreatedAt: time.Now(), Data: data}
+	return d.queue(webhooks, payload, include)
+}
+
+// queue creates a delivery of payload for each included webhook subscribed to its event
+func (d *WebhookDispatcher) queue(webhooks []models.Webhook, payload WebhookPayload, include func(models.Webhook) bool) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	queued := false
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(payload.Event) || !include(webhook) {
+			continue
+		}
+
+		delivery := newWebhookDelivery(webhook.ID, payload.Event, body)
+		if err := d.db.CreateWebhookDelivery(&delivery); err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+		queued = true
+	}
+
+	if queued {
+		d.Wake()
+	}
+	return nil
+}
+
+// Ping delivers a test event to webhook right away
+func (d *WebhookDispatcher) Ping(webhook models.Webhook) (models.WebhookDelivery, error) {
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     models.EventPing,
+		UserID:    webhook.UserID,
+		CreatedAt: time.Now(),
+		Data:      map[string]int{"webhook_id": webhook.ID},
+	})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	// pings are attempted once right away, they are never pending so the background loop skips them
+	delivery := newWebhookDelivery(webhook.ID, models.EventPing, payload)
+	delivery.Status = models.DeliveryFailed
+	if err := d.db.CreateWebhookDelivery(&delivery); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	d.attempt(webhook, &delivery, 1)
+	return delivery, nil
+}
+
+// Wake makes the dispatcher check pending deliveries without waiting for the next poll
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due events until ctx is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPolling)
+	defer ticker.Stop()
+
+	for {
+		d.DeliverDue()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue attempts all deliveries which are due
+func (d *WebhookDispatcher) DeliverDue() {
+	now := time.Now()
+	deliveries, err := d.db.ListDueWebhookDeliveries(now, webhookBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list due webhook deliveries")
+		return
+	}
+
+	for _, delivery := range deliveries {
+		// another instance may have listed the same delivery
+		claimed, err := d.db.ClaimWebhookDelivery(delivery.ID, now, time.Now().Add(webhookClaimLease))
+		if err != nil {
+			log.Error().Err(err).Int("delivery_id", delivery.ID).Msg("failed to claim webhook delivery")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		webhook, err := d.db.GetWebhook(delivery.WebhookID)
+		if err != nil || !webhook.Active {
+			delivery.Status = models.DeliveryFailed
+			delivery.LastError = "webhook is deleted or inactive"
+			if err := d.db.UpdateWebhookDelivery(&delivery); err != nil {
+				log.Error().Err(err).Int("delivery_id", delivery.ID).Msg("failed to update webhook delivery")
+			}
+			continue
+		}
+
+		d.attempt(webhook, &delivery, webhookMaxAttempts)
+	}
+}
+
+// attempt posts delivery to webhook and records the outcome
+func (d *WebhookDispatcher) attempt(webhook models.Webhook, delivery *models.WebhookDelivery, maxAttempts int) {
+	delivery.Attempts++
+	now := time.Now()
+
+	status, err := d.post(webhook, delivery)
+	delivery.ResponseStatus = status
+	delivery.LatencyMS = time.Since(now).Milliseconds()
+
+	if err == nil && status >= 200 && status < 300 {
+		delivery.Status = models.DeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	} else {
+		if err == nil {
+			err = fmt.Errorf("webhook responded with status %d", status)
+		}
+		delivery.LastError = err.Error()
+
+		if delivery.Attempts >= maxAttempts {
+			delivery.Status = models.DeliveryFailed
+		} else {
+			delivery.NextAttemptAt = now.Add(retryBackoff(delivery.Attempts))
+		}
+		log.Warn().Err(err).Int("webhook_id", webhook.ID).Int("delivery_id", delivery.ID).Msg("failed to deliver webhook")
+	}
+
+	if err := d.db.UpdateWebhookDelivery(delivery); err != nil {
+		log.Error().Err(err).Int("delivery_id", delivery.ID).Msg("failed to update webhook delivery")
+	}
+}
+
+// post sends signed delivery payload to webhook and returns the response status, the response body is discarded
+func (d *WebhookDispatcher) post(webhook models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	payload := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "KubeCloud-Webhook")
+	request.Header.Set("X-KubeCloud-Event", delivery.Event)
+	request.Header.Set("X-KubeCloud-Delivery", strconv.Itoa(delivery.ID))
+	request.Header.Set("X-KubeCloud-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set(webhookSignatureHeader, SignWebhookPayload(webhook.Secret, timestamp, payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// drained so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, webhookResponseLimit))
+	return response.StatusCode, nil
+}
+
+func newWebhookDelivery(webhookID int, event string, payload []byte) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       string(payload),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+}
+
+// WebhookChannel delivers notifications to webhooks of the notified user
+type WebhookChannel struct {
+	dispatcher *WebhookDispatcher
+}
+
+// NewWebhookChannel creates a new webhook notification channel
+func NewWebhookChannel(dispatcher *WebhookDispatcher) *WebhookChannel {
+	return &WebhookChannel{dispatcher: dispatcher}
+}
+
+// Name of webhook channel
+func (c *WebhookChannel) Name() string {
+	return models.ChannelWebhook
+}
+
+// Deliver queues notification to webhooks of user subscribed to its event
+func (c *WebhookChannel) Deliver(user models.User, notification Notification) error {
+	return c.dispatcher.PublishToUser(user.ID, notification.Event, map[string]interface{}{
+		"title":   notification.Title,
+		"message": notification.Message,
+		"details": notification.Data,
+	})
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"kubecloud/models"
+	"kubecloud/models/sqlite"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWebhookDialControl(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("10.1.0.0/16")
+	control := webhookDialControl([]*net.IPNet{allowed})
+
+	cases := map[string]bool{
+		"93.184.216.34:443":        true,
+		"[2606:4700::1111]:443":    true,
+		"10.1.2.3:80":              true,
+		"10.2.0.1:80":              false,
+		"127.0.0.1:80":             false,
+		"192.168.1.1:80":           false,
+		"172.16.0.1:80":            false,
+		"169.254.169.254:80":       false,
+		"100.100.100.200:80":       false,
+		"0.0.0.0:80":               false,
+		"[::1]:80":                 false,
+		"[::]:80":                  false,
+		"[fe80::1]:80":             false,
+		"[fd00::1]:80":             false,
+		"[::ffff:127.0.0.1]:80":    false,
+		"[::ffff:169.254.1.1]:443": false,
+	}
+
+	for address, public := range cases {
+		err := control("tcp", address, nil)
+		if public && err != nil {
+			t.Errorf("expected %s to be reachable, got %v", address, err)
+		}
+		if !public && !errors.Is(err, errBlockedAddress) {
+			t.Errorf("expected %s to be blocked, got %v", address, err)
+		}
+	}
+}
+
+func newTestWebhook(t *testing.T, db models.DB, url string) models.Webhook {
+	t.Helper()
+
+	webhook := models.Webhook{UserID: 1, URL: url, Secret: "secret", Events: []string{"*"}, Active: true}
+	if err := db.CreateWebhook(&webhook); err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func TestWebhookPing(t *testing.T) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+			return
+		}
+
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-KubeCloud-Timestamp"), 10, 64)
+		payload, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhookSignatureHeader) != SignWebhookPayload("secret", timestamp, payload) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("internal details"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	blocked, err := NewWebhookDispatcher(db, Webhooks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// by address and by a name resolving to a loopback address
+	for _, url := range []string{server.URL, "http://localhost:" + port} {
+		delivery, err := blocked.Ping(newTestWebhook(t, db, url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != models.DeliveryFailed || !strings.Contains(delivery.LastError, errBlockedAddress.Error()) {
+			t.Fatalf("expected %s to be blocked, got %+v", url, delivery)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Fatal("expected blocked webhooks not to be reached")
+	}
+
+	allowed, err := NewWebhookDispatcher(db, Webhooks{AllowedNetworks: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, err := allowed.Ping(newTestWebhook(t, db, server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != models.DeliverySucceeded || delivery.ResponseStatus != http.StatusOK {
+		t.Fatalf("expected the signed ping to be delivered, got %+v", delivery)
+	}
+
+	delivery, err = allowed.Ping(newTestWebhook(t, db, server.URL+"/redirect"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != models.DeliveryFailed || delivery.ResponseStatus != http.StatusFound || hits.Load() != 2 {
+		t.Fatalf("expected the redirect not to be followed, got %+v after %d requests", delivery, hits.Load())
+	}
+
+	if _, err := NewWebhookDispatcher(db, Webhooks{AllowedNetworks: []string{"not a network"}}); err == nil {
+		t.Fatal("expected an invalid network to be refused")
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dispatcher, err := NewWebhookDispatcher(db, Webhooks{AllowedNetworks: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := newTestWebhook(t, db, server.URL)
+	if err := dispatcher.Publish(1, models.EventBalanceCredited, map[string]int{"amount": 10}); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.DeliverDue()
+
+	deliveries, err := db.ListWebhookDeliveries(webhook.ID, -1)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("expected a delivery, got %+v %v", deliveries, err)
+	}
+	delivery := deliveries[0]
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusServiceUnavailable {
+		t.Fatalf("expected the delivery to be retried, got %+v", delivery)
+	}
+	if delivery.NextAttemptAt.Before(delivery.CreatedAt.Add(retryBackoff(1))) {
+		t.Fatalf("expected the retry to wait for backoff, got %s", delivery.NextAttemptAt)
+	}
+}
+
+func TestWebhookClaim(t *testing.T) {
+	db, err := sqlite.NewSqliteStorage(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	// a second dispatcher stands for another instance sharing the database
+	var replicas []*WebhookDispatcher
+	for i := 0; i < 2; i++ {
+		dispatcher, err := NewWebhookDispatcher(db, Webhooks{AllowedNetworks: []string{"127.0.0.0/8"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, dispatcher)
+	}
+	newTestWebhook(t, db, server.URL)
+	for i := 0; i < 10; i++ {
+		if err := replicas[0].Publish(1, models.EventBalanceCredited, map[string]int{"amount": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(dispatcher *WebhookDispatcher) {
+			defer wg.Done()
+			dispatcher.DeliverDue()
+		}(replicas[i%2])
+	}
+	wg.Wait()
+
+	if hits.Load() != 10 {
+		t.Fatalf("expected each delivery to be posted once, got %d posts", hits.Load())
+	}
+}
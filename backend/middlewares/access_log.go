@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// AccessLogMiddleware logs every request with its status, latency and user, failed requests are logged as warnings
+// and server errors as errors. Successful requests to quiet routes, like probes, are logged at debug level.
+func AccessLogMiddleware(quietRoutes ...string) gin.HandlerFunc {
+	quiet := make(map[string]bool, len(quietRoutes))
+	for _, route := range quietRoutes {
+		quiet[route] = true
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := zerolog.InfoLevel
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = zerolog.ErrorLevel
+		case status >= http.StatusBadRequest:
+			level = zerolog.WarnLevel
+		case quiet[c.FullPath()]:
+			level = zerolog.DebugLevel
+		}
+
+		event := log.Ctx(c.Request.Context()).WithLevel(level).
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Str("route", c.FullPath()).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("client_ip", c.ClientIP()).
+			Int("bytes", c.Writer.Size())
+
+		if userID := actorID(c); userID != 0 {
+			event = event.Int("user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			event = event.Str("error", c.Errors.Last().Error())
+		}
+
+		event.Msg("request")
+	}
+}
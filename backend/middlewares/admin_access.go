@@ -2,30 +2,45 @@ package middlewares
 
 import (
 	"kubecloud/internal"
+	"kubecloud/models"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AdminMiddleware validates requests to admin endpoints
-func AdminMiddleware(tokenManager internal.TokenManager) gin.HandlerFunc {
+// AdminMiddleware validates requests to admin endpoints, personal access tokens need the admin scope
+func AdminMiddleware(db models.DB, tokenManager internal.TokenManager, apiTokens *internal.APITokens, sessions *internal.Sessions) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
+		user, err := authenticate(c, db, tokenManager, apiTokens, sessions)
+		if err == errMissingCredentials {
+			AbortWithError(c, http.StatusUnauthorized, internal.ErrCodeUnauthorized, "Authorization header missing")
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := tokenManager.VerifyToken(tokenStr)
-		if err != nil || !claims.Admin {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if err == errUserLookup {
+			AbortWithAPIError(c, internal.ErrInternal)
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("admin", claims.Admin)
+		if err == errInvalidCSRF {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeCSRFFailed, "CSRF token is missing or wrong")
+			return
+		}
+
+		if err != nil && err != errMissingScope {
+			// expired tokens are told apart from non admins so clients know to refresh
+			AbortWithError(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired token")
+			return
+		}
+
+		if err != nil || !user.admin {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeForbidden, "Admin access required")
+			return
+		}
+
+		c.Set("user_id", user.userID)
+		c.Set("admin", user.admin)
+		c.Set("auth_method", user.method)
 		c.Next()
 	}
 }
@@ -0,0 +1,70 @@
+package middlewares
+
+import (
+	"fmt"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// AuditMiddleware records the outcome of the action handled by the route in the audit log
+func AuditMiddleware(auditor *internal.Auditor, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := bufferBody(c)
+		if !ok {
+			return
+		}
+
+		var digest string
+		if len(body) > 0 {
+			digest = auditor.PayloadDigest(body)
+		}
+
+		c.Next()
+
+		outcome := internal.AuditOutcomeSuccess
+		if c.Writer.Status() >= http.StatusBadRequest {
+			outcome = internal.AuditOutcomeFailure
+		}
+
+		entry := models.AuditLog{
+			ActorID:       actorID(c),
+			Action:        action,
+			Target:        auditTarget(c),
+			PayloadDigest: digest,
+			SourceIP:      c.ClientIP(),
+			StatusCode:    c.Writer.Status(),
+			Outcome:       outcome,
+		}
+
+		if err := auditor.Record(c, entry); err != nil {
+			log.Ctx(c).Error().Err(err).Str("action", action).Msg("failed to record audit log")
+		}
+	}
+}
+
+// actorID gets ID of the authenticated user, admin and user middlewares store it with different types
+func actorID(c *gin.Context) int {
+	switch id := c.Value("user_id").(type) {
+	case int:
+		return id
+	case string:
+		ID, _ := strconv.Atoi(id)
+		return ID
+	}
+	return 0
+}
+
+// auditTarget describes the resource an action is applied to from the route params
+func auditTarget(c *gin.Context) string {
+	targets := make([]string, 0, len(c.Params))
+	for _, param := range c.Params {
+		targets = append(targets, fmt.Sprintf("%s=%s", param.Key, param.Value))
+	}
+	return strings.Join(targets, ",")
+}
@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"errors"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// AuthMethodJWT is set in context for requests authenticated by a JWT
+	AuthMethodJWT = "jwt"
+	// AuthMethodAPIToken is set in context for requests authenticated by a personal access token
+	AuthMethodAPIToken = "api_token"
+	// AuthMethodSession is set in context for requests authenticated by a cookie session
+	AuthMethodSession = "session"
+)
+
+var (
+	errMissingCredentials = errors.New("request has no bearer token or session cookie")
+	errMissingScope       = errors.New("api token is missing required scope")
+	errInvalidCSRF        = errors.New("csrf token is missing or wrong")
+	errDeletedUser        = errors.New("user of token is deleted")
+	errUserLookup         = errors.New("failed to look up user of token")
+)
+
+// identity holds who is making a request
+type identity struct {
+	userID         int
+	admin          bool
+	method         string
+	organizationID int // active organization claimed by a JWT
+}
+
+// authenticate verifies the bearer token of request, either a JWT or a personal access token, or else its
+// cookie session if sessions is not nil. Personal access tokens need the read or write scope depending on the
+// request method, cookie sessions need the CSRF token on requests changing state. Tokens of deleted users fail.
+func authenticate(c *gin.Context, db models.DB, tokenManager internal.TokenManager, apiTokens *internal.APITokens, sessions *internal.Sessions) (identity, error) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" && sessions != nil && sessions.AccessToken(c.Request) != "" {
+		user, err := authenticateSession(c, tokenManager, sessions)
+		if err != nil {
+			return identity{}, err
+		}
+		return user, checkUserExists(c, db, user.userID)
+	}
+
+	if authHeader == "" {
+		return identity{}, errMissingCredentials
+	}
+	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+
+	if !internal.IsAPIToken(tokenStr) {
+		claims, err := tokenManager.VerifyToken(tokenStr, internal.TokenTypeAccess)
+		if err != nil {
+			return identity{}, err
+		}
+		if err := checkUserExists(c, db, claims.UserID); err != nil {
+			return identity{}, err
+		}
+		return identity{
+			userID:         claims.UserID,
+			admin:          claims.Admin,
+			method:         AuthMethodJWT,
+			organizationID: claims.OrganizationID,
+		}, nil
+	}
+
+	apiToken, user, err := apiTokens.Verify(tokenStr)
+	if err != nil {
+		return identity{}, err
+	}
+
+	scope := models.ScopeWrite
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scope = models.ScopeRead
+	}
+
+	if !apiToken.HasScope(scope) {
+		return identity{}, errMissingScope
+	}
+
+	return identity{
+		userID: user.ID,
+		admin:  user.Admin && apiToken.HasScope(models.ScopeAdmin),
+		method: AuthMethodAPIToken,
+	}, nil
+}
+
+// checkUserExists fails with errDeletedUser if the user of a JWT is deleted, JWTs outlive their user otherwise
+func checkUserExists(c *gin.Context, db models.DB, userID int) error {
+	_, err := db.WithContext(c).GetUserByID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errDeletedUser
+	}
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Int("user_id", userID).Msg("failed to get user of token")
+		return errUserLookup
+	}
+	return nil
+}
+
+// authenticateSession verifies the access token cookie of request, a valid token with a wrong CSRF token fails with errInvalidCSRF
+func authenticateSession(c *gin.Context, tokenManager internal.TokenManager, sessions *internal.Sessions) (identity, error) {
+	claims, err := tokenManager.VerifyToken(sessions.AccessToken(c.Request), internal.TokenTypeAccess)
+	if err != nil {
+		return identity{}, err
+	}
+
+	if !sessions.VerifyCSRF(c.Request, claims.SessionID) {
+		return identity{}, errInvalidCSRF
+	}
+
+	return identity{
+		userID:         claims.UserID,
+		admin:          claims.Admin,
+		method:         AuthMethodSession,
+		organizationID: claims.OrganizationID,
+	}, nil
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"kubecloud/internal"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBufferedBodyBytes is the size of the largest request body read by middlewares before the handler
+const maxBufferedBodyBytes = 1 << 20
+
+// bufferBody reads the request body and puts it back for the handler. The request is aborted and false is
+// returned if the body is too large or can't be read.
+func bufferBody(c *gin.Context) ([]byte, bool) {
+	if c.Request.Body == nil {
+		return nil, true
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBufferedBodyBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		AbortWithError(c, http.StatusRequestEntityTooLarge, internal.ErrCodeInvalidRequest, "Request body is too large")
+		return nil, false
+	}
+	if err != nil {
+		AbortWithError(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid request body")
+		return nil, false
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
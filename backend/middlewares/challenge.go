@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"errors"
+	"kubecloud/internal"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ChallengeHeader holds the solved challenge of a request
+const ChallengeHeader = "X-Challenge-Response"
+
+// ChallengeMiddleware requires a solved bot challenge before the request is handled. A challenge of a request
+// refused by the handler, like one with an invalid body, is released if the verifier allows it.
+func ChallengeMiddleware(verifier internal.ChallengeVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := c.GetHeader(ChallengeHeader)
+		err := verifier.Verify(c.Request.Context(), response, c.ClientIP())
+		if errors.Is(err, internal.ErrChallengeFailed) {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeChallengeFailed, "Challenge is missing or invalid")
+			return
+		}
+
+		if err != nil {
+			log.Ctx(c).Error().Err(err).Str("provider", verifier.Provider()).Msg("failed to verify challenge")
+			AbortWithError(c, http.StatusServiceUnavailable, internal.ErrCodeUnavailable, "Challenge could not be verified, please try again later")
+			return
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if releaser, ok := verifier.(internal.ChallengeReleaser); ok && status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+			releaser.Release(response)
+		}
+	}
+}
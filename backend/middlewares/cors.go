@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"kubecloud/internal"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsMethods are the methods routes are registered with
+const corsMethods = "GET, POST, PUT, DELETE"
+
+// CORSMiddleware allows browsers on allowed origins to call the API and answers their preflight requests
+func CORSMiddleware(config internal.CORS) gin.HandlerFunc {
+	allowAny := internal.Contains(config.AllowedOrigins, "*")
+	allowedHeaders := strings.Join(config.AllowedHeaders, ", ")
+	exposedHeaders := strings.Join(config.ExposedHeaders, ", ")
+
+	return func(c *gin.Context) {
+		if len(config.AllowedOrigins) == 0 {
+			c.Next()
+			return
+		}
+
+		// responses depend on the origin even without one, caches must not serve them to allowed origins
+		c.Writer.Header().Add("Vary", "Origin")
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !allowAny && !internal.Contains(config.AllowedOrigins, origin) {
+			if preflight {
+				AbortWithError(c, http.StatusForbidden, internal.ErrCodeForbidden, "Origin is not allowed")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if allowAny && !config.AllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if config.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposedHeaders != "" {
+				c.Header("Access-Control-Expose-Headers", exposedHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Methods", corsMethods)
+		if allowedHeaders != "" {
+			c.Header("Access-Control-Allow-Headers", allowedHeaders)
+		}
+		if config.MaxAgeSeconds > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(config.MaxAgeSeconds))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
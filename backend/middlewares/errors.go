@@ -0,0 +1,150 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kubecloud/internal"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var registerFieldNames sync.Once
+
+// ErrorMiddleware renders the error of aborted requests as {"error": {code, message, details, request_id}}.
+// Errors other than internal.APIError are rendered as internal errors.
+func ErrorMiddleware() gin.HandlerFunc {
+	// validation errors name fields like clients send them
+	registerFieldNames.Do(func() {
+		if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+			validate.RegisterTagNameFunc(jsonFieldName)
+		}
+	})
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		var apiErr *internal.APIError
+		if !errors.As(c.Errors.Last().Err, &apiErr) {
+			apiErr = internal.ErrInternal
+		}
+
+		rendered := *apiErr
+		rendered.RequestID = c.GetString(RequestIDKey)
+		c.JSON(rendered.Status, gin.H{"error": rendered})
+	}
+}
+
+// AbortWithError stops the request with an error rendered by ErrorMiddleware. The status is set right away so
+// middlewares running after the handler see it.
+func AbortWithError(c *gin.Context, status int, code internal.ErrorCode, message string) {
+	AbortWithAPIError(c, internal.NewAPIError(status, code, message))
+}
+
+// AbortWithAPIError stops the request with err rendered by ErrorMiddleware
+func AbortWithAPIError(c *gin.Context, err *internal.APIError) {
+	c.Status(err.Status)
+	c.Abort()
+	_ = c.Error(err)
+}
+
+// AbortWithBindingError stops the request with the error of binding its body or query
+func AbortWithBindingError(c *gin.Context, err error) {
+	AbortWithAPIError(c, BindingError(err))
+}
+
+// BindingError describes an error of gin binding, listing fields failing validation
+func BindingError(err error) *internal.APIError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		apiErr := internal.NewAPIError(http.StatusBadRequest, internal.ErrCodeValidationFailed, "Request validation failed")
+		for _, fieldErr := range validationErrs {
+			apiErr.Details = append(apiErr.Details, internal.FieldError{
+				Field:   fieldPath(fieldErr),
+				Rule:    fieldErr.Tag(),
+				Message: ruleMessage(fieldErr),
+			})
+		}
+		return apiErr
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		apiErr := internal.NewAPIError(http.StatusBadRequest, internal.ErrCodeValidationFailed, "Request validation failed")
+		apiErr.Details = []internal.FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "should be " + typeErr.Type.String(),
+		}}
+		return apiErr
+	}
+
+	if errors.Is(err, io.EOF) {
+		return internal.NewAPIError(http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Request body is required")
+	}
+
+	return internal.NewAPIError(http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid request format")
+}
+
+// fieldPath drops the struct name from the namespace so nested fields read like "limits.vcpu"
+func fieldPath(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if _, path, found := strings.Cut(namespace, "."); found {
+		return path
+	}
+	return fieldErr.Field()
+}
+
+func ruleMessage(fieldErr validator.FieldError) string {
+	param := fieldErr.Param()
+
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "should be a valid email"
+	case "url":
+		return "should be a valid URL"
+	case "oneof":
+		return "should be one of " + strings.Join(strings.Fields(param), ", ")
+	case "eqfield":
+		return "should match " + param
+	case "min", "gte":
+		return "should be at least " + param
+	case "max", "lte":
+		return "should be at most " + param
+	case "gt":
+		return "should be more than " + param
+	case "lt":
+		return "should be less than " + param
+	case "bcp47_language_tag":
+		return "should be a language tag like en or fr-CA"
+	}
+
+	return fmt.Sprintf("failed %s validation", fieldErr.Tag())
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		if name, _, _ = strings.Cut(field.Tag.Get("form"), ","); name != "" {
+			return name
+		}
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"errors"
+	"kubecloud/internal"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware records rate, errors and duration of requests per route, and counts auth failures.
+// Requests not matching a route share the "unmatched" route so scanners can't blow up label cardinality.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		internal.HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		internal.HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+
+		for _, err := range c.Errors {
+			var apiErr *internal.APIError
+			if errors.As(err.Err, &apiErr) && internal.IsAuthFailure(apiErr.Code) {
+				internal.AuthFailures.WithLabelValues(route, string(apiErr.Code)).Inc()
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"errors"
+	"kubecloud/internal"
+	"kubecloud/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// OrganizationHeader selects the active organization of a request, it overrides the organization of the token
+const OrganizationHeader = "X-Organization-ID"
+
+// OrganizationMiddleware resolves the active organization of a logged in user from the header or the token
+// and checks the user is a member of it. Requests without an active organization act on the personal account.
+func OrganizationMiddleware(db models.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		organizationID := c.GetInt("token_organization_id")
+
+		if header := c.GetHeader(OrganizationHeader); header != "" {
+			ID, err := strconv.Atoi(header)
+			if err != nil || ID < 0 {
+				AbortWithError(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid organization ID")
+				return
+			}
+			organizationID = ID
+		}
+
+		if organizationID == 0 {
+			c.Next()
+			return
+		}
+
+		userID, err := strconv.Atoi(c.GetString("user_id"))
+		if err != nil {
+			AbortWithError(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "Invalid user ID")
+			return
+		}
+
+		member, err := db.WithContext(c).GetOrganizationMember(organizationID, userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeForbidden, "You are not a member of this organization")
+			return
+		}
+
+		if err != nil {
+			log.Ctx(c).Error().Err(err).Int("organization_id", organizationID).Msg("failed to get organization member")
+			AbortWithAPIError(c, internal.ErrInternal)
+			return
+		}
+
+		c.Set("organization_id", organizationID)
+		c.Set("organization_role", member.Role)
+		c.Next()
+	}
+}
+
+// OrganizationRoleMiddleware requires an active organization in which the user has one of roles
+func OrganizationRoleMiddleware(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetInt("organization_id") == 0 {
+			AbortWithError(c, http.StatusBadRequest, internal.ErrCodeInvalidRequest, "No active organization, select one with the "+OrganizationHeader+" header")
+			return
+		}
+
+		if !internal.Contains(roles, c.GetString("organization_role")) {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeForbidden, "Your organization role does not allow this request")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,99 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"kubecloud/internal"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitMiddleware limits requests with a token bucket policy, per user if logged in and per client IP otherwise.
+// Requests are let through if the store fails, failures are counted in internal.RateLimitStoreErrors.
+func RateLimitMiddleware(limiter *internal.RateLimiter, policyName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		key := "ip:" + c.ClientIP()
+		if userID, ok := c.Get("user_id"); ok {
+			key = fmt.Sprintf("user:%v", userID)
+		}
+
+		if takeRateLimit(c, limiter, policyName, key) {
+			c.Next()
+		}
+	}
+}
+
+// TargetEmailRateLimitMiddleware limits requests mailing the email in their JSON body per address, so an address
+// can't be flooded from many clients. Bucket keys hold a hash of the address, requests without one are left to
+// the handler to refuse.
+func TargetEmailRateLimitMiddleware(limiter *internal.RateLimiter, policyName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		body, ok := bufferBody(c)
+		if !ok {
+			return
+		}
+
+		var target struct {
+			Email string `json:"email"`
+		}
+		_ = json.Unmarshal(body, &target)
+
+		email := strings.ToLower(strings.TrimSpace(target.Email))
+		if email == "" {
+			c.Next()
+			return
+		}
+
+		sum := sha256.Sum256([]byte(email))
+		if takeRateLimit(c, limiter, policyName, "email:"+hex.EncodeToString(sum[:])) {
+			c.Next()
+		}
+	}
+}
+
+// takeRateLimit takes a token of policy from the bucket of key and sets the rate limit headers, it aborts the
+// request and returns false if the bucket is empty
+func takeRateLimit(c *gin.Context, limiter *internal.RateLimiter, policyName, key string) bool {
+	result, err := limiter.Take(policyName, key)
+	if err != nil {
+		log.Ctx(c).Error().Err(err).Str("policy", policyName).Msg("failed to check rate limit")
+		internal.RateLimitStoreErrors.WithLabelValues(policyName).Inc()
+		return true
+	}
+
+	policy, _ := limiter.Policy(policyName)
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", policy.Requests, policy.PeriodSeconds, policy.Capacity()))
+
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		AbortWithError(c, http.StatusTooManyRequests, internal.ErrCodeRateLimited, "Too many requests, please try again later")
+		return false
+	}
+
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
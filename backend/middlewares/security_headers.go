@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"fmt"
+	"kubecloud/internal"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SecurityHeadersMiddleware sets security headers on every response, handlers may override them.
+// HSTS is only sent over HTTPS, including HTTPS terminated by a proxy setting X-Forwarded-Proto.
+func SecurityHeadersMiddleware(config internal.SecurityHeaders) gin.HandlerFunc {
+	hsts := ""
+	if config.HSTSMaxAgeSeconds > 0 {
+		hsts = fmt.Sprintf("max-age=%d", config.HSTSMaxAgeSeconds)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	headers := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": config.ContentSecurityPolicy,
+		"X-Frame-Options":         config.FrameOptions,
+		"Referrer-Policy":         config.ReferrerPolicy,
+	}
+
+	return func(c *gin.Context) {
+		for name, value := range headers {
+			if value != "" {
+				c.Header(name, value)
+			}
+		}
+
+		if hsts != "" && (c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")) {
+			c.Header("Strict-Transport-Security", hsts)
+		}
+
+		c.Next()
+	}
+}
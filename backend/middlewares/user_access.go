@@ -2,30 +2,47 @@ package middlewares
 
 import (
 	"kubecloud/internal"
+	"kubecloud/models"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UserMiddleware(tokenManager internal.TokenManager) gin.HandlerFunc {
+// UserMiddleware validates requests of logged in users made with a JWT, a personal access token
+// or a cookie session if sessions is not nil
+func UserMiddleware(db models.DB, tokenManager internal.TokenManager, apiTokens *internal.APITokens, sessions *internal.Sessions) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
+		user, err := authenticate(c, db, tokenManager, apiTokens, sessions)
+		if err == errMissingCredentials {
+			AbortWithError(c, http.StatusUnauthorized, internal.ErrCodeUnauthorized, "Authorization header missing")
+			return
+		}
+
+		if err == errMissingScope {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeInsufficientScope, "Token scope does not allow this request")
+			return
+		}
+
+		if err == errUserLookup {
+			AbortWithAPIError(c, internal.ErrInternal)
+			return
+		}
+
+		if err == errInvalidCSRF {
+			AbortWithError(c, http.StatusForbidden, internal.ErrCodeCSRFFailed, "CSRF token is missing or wrong")
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := tokenManager.VerifyToken(tokenStr)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			AbortWithError(c, http.StatusUnauthorized, internal.ErrCodeInvalidToken, "Invalid or expired token")
 			return
 		}
 
-		c.Set("user_id", strconv.Itoa(claims.UserID))
-		c.Set("admin", claims.Admin)
+		c.Set("user_id", strconv.Itoa(user.userID))
+		c.Set("admin", user.admin)
+		c.Set("auth_method", user.method)
+		c.Set("token_organization_id", user.organizationID)
 		c.Next()
 	}
 }
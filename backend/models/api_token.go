@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+const (
+	// ScopeRead allows read only requests
+	ScopeRead = "read"
+	// ScopeWrite allows requests changing data
+	ScopeWrite = "write"
+	// ScopeAdmin allows admin endpoints if the owner is an admin
+	ScopeAdmin = "admin"
+)
+
+// APIToken is a long-lived personal access token, only its hash is stored
+type APIToken struct {
+	ID         int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID     int        `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of token to identify it
+	Hash       string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether token is granted scope
+func (t APIToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
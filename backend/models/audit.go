@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// AuditLog is an append-only record of a privileged or security sensitive action
+type AuditLog struct {
+	ID             int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	ActorID        int       `json:"actor_id" gorm:"index"`
+	Action         string    `json:"action" gorm:"index"`
+	Target         string    `json:"target"`
+	PayloadDigest  string    `json:"payload_digest"` // keyed hash of the request body with credentials redacted
+	SourceIP       string    `json:"source_ip"`
+	SourceIPDigest string    `json:"-"` // keyed hash of the source IP, chained instead of it so it can be wiped
+	StatusCode     int       `json:"status_code"`
+	Outcome        string    `json:"outcome"`
+	CreatedAt      time.Time `json:"created_at" gorm:"index"`
+	PrevHash       string    `json:"prev_hash,omitempty"`
+	Hash           string    `json:"hash,omitempty"`
+}
+
+// AuditHead anchors the latest chained audit log entry, so entries removed from the end of the chain are noticed
+type AuditHead struct {
+	ID      int    `gorm:"primaryKey"` // there is a single head
+	EntryID int    // ID of the latest chained entry
+	Hash    string // hash of the latest chained entry
+	MAC     string // keyed hash of the entry ID and hash, so the head can't be moved back without the secret
+}
+
+// AuditFilter holds optional filters for listing audit logs
+type AuditFilter struct {
+	AfterID int // lists entries with a greater ID, to read the log in batches
+	ActorID int
+	Action  string
+	Target  string
+	Outcome string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	Offset  int
+}
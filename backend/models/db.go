@@ -1,17 +1,101 @@
 package models
 
+import (
+	"context"
+	"time"
+)
+
 // DB interface for databases
 type DB interface {
+	// WithContext returns a DB running queries with ctx, so they are traced as part of its request
+	WithContext(ctx context.Context) DB
+	// Transaction runs fn with a DB whose changes are committed only if fn succeeds
+	Transaction(fn func(tx DB) error) error
+	// Ping checks the database is reachable
+	Ping() error
+	// CheckMigrations returns an error if the schema is missing tables or columns of the models
+	CheckMigrations() error
 	RegisterUser(user *User) error
 	GetUserByEmail(email string) (User, error)
 	GetUserByID(userID int) (User, error)
+	GetUserByOIDCSubject(subject string) (User, error)
+	UpdateUserOIDC(userID int, subject string, admin bool) error
+	UpdateUserAdmin(userID int, admin bool) error
 	UpdateUserByID(user *User) error
-	UpdatePassword(email string, hashedPassword []byte) error
+	UpdatePassword(userID int, hashedPassword []byte) error
 	UpdateUserVerification(userID int, verified bool) error
 	ListAllUsers() ([]User, error)
+	CountVerifiedUsers() (int64, error)
+	SumCreditedBalance() (float64, error)
 	DeleteUserByID(userID int) error
+	AnonymizeDeletedUsers(deletedBefore time.Time) (int64, error)
 	CreateVoucher(voucher *Voucher) error
 	ListAllVouchers() ([]Voucher, error)
+	SumOutstandingVoucherValue(now time.Time) (float64, error)
+	CountRedeemedVouchers() (int64, error)
 	CreateTransaction(transaction *Transaction) error
+	ListUserTransactions(userID int) ([]Transaction, error)
 	CreditUserBalance(userID int, amount float64) error
+	GetUserQuota(userID int) (Quota, error)
+	UpsertUserQuota(quota *Quota) error
+	DeleteUserQuota(userID int) error
+	CreateAuditLog(entry *AuditLog) error
+	LastChainedAuditLog() (AuditLog, error)
+	GetAuditHead() (AuditHead, error)
+	SaveAuditHead(head *AuditHead) error
+	ListAuditLogs(filter AuditFilter) ([]AuditLog, error)
+	EnqueueMail(mail *OutboxMail) error
+	GetOutboxMail(id int) (OutboxMail, error)
+	ListDueMails(now time.Time, limit int) ([]OutboxMail, error)
+	ClaimOutboxMail(id int, now, until time.Time) (bool, error)
+	ListOutboxMails(status string, limit, offset int) ([]OutboxMail, error)
+	DeleteSentMailsBefore(before time.Time) (int64, error)
+	UpdateOutboxMail(mail *OutboxMail) error
+	CreateNotification(notification *Notification) error
+	ListUserNotifications(userID int, unreadOnly bool) ([]Notification, error)
+	MarkNotificationsRead(userID int, notificationIDs []int) (int64, error)
+	ListNotificationPreferences(userID int) ([]NotificationPreference, error)
+	UpsertNotificationPreference(preference *NotificationPreference) error
+	CreateWebhook(webhook *Webhook) error
+	GetWebhook(id int) (Webhook, error)
+	ListUserWebhooks(userID int) ([]Webhook, error)
+	ListEventWebhooks(userID int) ([]Webhook, error)
+	ListOrganizationWebhooks(organizationID int) ([]Webhook, error)
+	DeleteWebhook(id int) error
+	CreateWebhookDelivery(delivery *WebhookDelivery) error
+	ListDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	ClaimWebhookDelivery(id int, now, until time.Time) (bool, error)
+	ListWebhookDeliveries(webhookID int, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(delivery *WebhookDelivery) error
+	CreateAPIToken(token *APIToken) error
+	GetAPITokenByHash(hash string) (APIToken, error)
+	ListUserAPITokens(userID int) ([]APIToken, error)
+	UpdateAPITokenLastUsed(tokenID int, lastUsed time.Time) error
+	RevokeAPIToken(userID, tokenID int) error
+	CreateOrganization(organization *Organization) error
+	GetOrganization(id int) (Organization, error)
+	ListAllOrganizations() ([]Organization, error)
+	ListUserOrganizations(userID int) ([]Organization, error)
+	UpdateOrganizationName(id int, name string) error
+	DeleteOrganization(id int) error
+	CreditOrganizationBalance(id int, amount float64) error
+	ListOrganizationTransactions(id int) ([]Transaction, error)
+	AddOrganizationMember(member *OrganizationMember) error
+	GetOrganizationMember(organizationID, userID int) (OrganizationMember, error)
+	ListOrganizationMembers(organizationID int) ([]OrganizationMember, error)
+	CountOrganizationOwners(organizationID int) (int64, error)
+	UpdateOrganizationMemberRole(organizationID, userID int, role string) error
+	RemoveOrganizationMember(organizationID, userID int) error
+	CreateOrganizationInvitation(invitation *OrganizationInvitation) error
+	GetOrganizationInvitationByHash(hash string) (OrganizationInvitation, error)
+	ListOrganizationInvitations(organizationID int) ([]OrganizationInvitation, error)
+	ListUserOrganizationInvitations(userID int, email string) ([]OrganizationInvitation, error)
+	AcceptOrganizationInvitation(id int, acceptedAt time.Time) error
+	DeleteOrganizationInvitation(organizationID, id int) error
+	GetRateLimitBucket(key string) (RateLimitBucket, error)
+	SaveRateLimitBucket(bucket *RateLimitBucket) error
+	DeleteRateLimitBucketsBefore(before time.Time) (int64, error)
+	RevokeSession(session *RevokedSession) error
+	IsSessionRevoked(sessionID string) (bool, error)
+	DeleteExpiredRevokedSessions(now time.Time) (int64, error)
 }
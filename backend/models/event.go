@@ -0,0 +1,23 @@
+package models
+
+const (
+	// EventBalanceCredited is raised when balance of user is credited
+	EventBalanceCredited = "balance.credited"
+	// EventUserRegistered is raised when a user verifies a new account
+	EventUserRegistered = "user.registered"
+	// EventPing is sent to test a webhook
+	EventPing = "ping"
+)
+
+// WebhookEvents lists all event types webhooks can subscribe to,
+// events are added once something raises them
+var WebhookEvents = []string{
+	EventUserRegistered,
+	EventBalanceCredited,
+}
+
+// NotificationEvents lists all event types users can be notified about,
+// events are added once something raises them
+var NotificationEvents = []string{
+	EventBalanceCredited,
+}
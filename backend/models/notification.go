@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+const (
+	// ChannelEmail delivers notifications by mail
+	ChannelEmail = "email"
+	// ChannelInApp delivers notifications to the in-app inbox
+	ChannelInApp = "in_app"
+	// ChannelWebhook delivers notifications to webhooks of user
+	ChannelWebhook = "webhook"
+)
+
+// Notification is a message in the in-app inbox of a user
+type Notification struct {
+	ID        int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    int        `json:"user_id" gorm:"index"`
+	Event     string     `json:"event"`
+	Title     string     `json:"title"`
+	Message   string     `json:"message"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NotificationPreference holds the channels a user is notified through for an event
+type NotificationPreference struct {
+	ID      int    `json:"-" gorm:"primaryKey;autoIncrement"`
+	UserID  int    `json:"-" gorm:"uniqueIndex:idx_user_event"`
+	Event   string `json:"event" gorm:"uniqueIndex:idx_user_event" binding:"required"`
+	Email   bool   `json:"email"`
+	InApp   bool   `json:"in_app"`
+	Webhook bool   `json:"webhook"`
+}
+
+// DefaultNotificationPreference is used for events a user did not set preferences for,
+// webhooks are enabled since they only receive events they subscribed to
+func DefaultNotificationPreference(userID int, event string) NotificationPreference {
+	return NotificationPreference{
+		UserID:  userID,
+		Event:   event,
+		Email:   true,
+		InApp:   true,
+		Webhook: true,
+	}
+}
+
+// Enabled reports whether notifications are delivered through channel
+func (p NotificationPreference) Enabled(channel string) bool {
+	switch channel {
+	case ChannelEmail:
+		return p.Email
+	case ChannelInApp:
+		return p.InApp
+	case ChannelWebhook:
+		return p.Webhook
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// OrganizationRoleOwner manages everything including the organization itself
+	OrganizationRoleOwner = "owner"
+	// OrganizationRoleAdmin manages members and resources
+	OrganizationRoleAdmin = "admin"
+	// OrganizationRoleMember uses resources of the organization
+	OrganizationRoleMember = "member"
+	// OrganizationRoleBilling manages balance and ledger of the organization
+	OrganizationRoleBilling = "billing"
+)
+
+// OrganizationRoles lists all roles a member can have
+var OrganizationRoles = []string{
+	OrganizationRoleOwner,
+	OrganizationRoleAdmin,
+	OrganizationRoleMember,
+	OrganizationRoleBilling,
+}
+
+// OrganizationManagerRoles manage members and resources of an organization
+var OrganizationManagerRoles = []string{OrganizationRoleOwner, OrganizationRoleAdmin}
+
+// OrganizationBillingRoles see the balance and ledger of an organization
+var OrganizationBillingRoles = []string{OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleBilling}
+
+// Organization is a team sharing resources and a balance
+type Organization struct {
+	ID                int            `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name              string         `json:"name"`
+	CreditCardBalance float64        `json:"credit_card_balance" gorm:"default:0"`
+	CreditedBalance   float64        `json:"credited_balance" gorm:"default:0"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
+	Role              string         `json:"role,omitempty" gorm:"->;-:migration"` // role of the listing user
+}
+
+// OrganizationMember is a user belonging to an organization with a role
+type OrganizationMember struct {
+	ID             int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	OrganizationID int       `json:"organization_id" gorm:"uniqueIndex:idx_organization_member"`
+	UserID         int       `json:"user_id" gorm:"uniqueIndex:idx_organization_member;index"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+	Username       string    `json:"username,omitempty" gorm:"->;-:migration"`
+	Email          string    `json:"email,omitempty" gorm:"->;-:migration"`
+}
+
+// OrganizationInvitation invites an email to join an organization, only the hash of its token is stored
+type OrganizationInvitation struct {
+	ID             int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	OrganizationID int        `json:"organization_id" gorm:"index"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	Hash           string     `json:"-" gorm:"uniqueIndex"`
+	InvitedBy      int        `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
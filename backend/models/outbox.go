@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	// OutboxPending is the status of mails waiting to be sent
+	OutboxPending = "pending"
+	// OutboxSent is the status of delivered mails
+	OutboxSent = "sent"
+	// OutboxDead is the status of mails that failed all delivery attempts
+	OutboxDead = "dead"
+)
+
+// OutboxMail is a mail queued for delivery by the background sender
+type OutboxMail struct {
+	ID            int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	Sender        string     `json:"sender"`
+	Receiver      string     `json:"receiver"`
+	Subject       string     `json:"subject"`
+	HTMLBody      string     `json:"-"`
+	TextBody      string     `json:"-"`
+	Status        string     `json:"status" gorm:"index;default:pending"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastError     string     `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
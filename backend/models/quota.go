@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Resources holds amounts of deployable resources, used both as limits and as usage
+type Resources struct {
+	Clusters  int `json:"clusters" binding:"gte=0" validate:"gte=0"`
+	Nodes     int `json:"nodes" binding:"gte=0" validate:"gte=0"`
+	VCPU      int `json:"vcpu" binding:"gte=0" validate:"gte=0"`
+	MemoryGB  int `json:"memory_gb" binding:"gte=0" validate:"gte=0"`
+	DiskGB    int `json:"disk_gb" binding:"gte=0" validate:"gte=0"`
+	PublicIPs int `json:"public_ips" binding:"gte=0" validate:"gte=0"`
+}
+
+// Quota holds resource limits overridden by an admin for a single user
+type Quota struct {
+	ID        int `json:"-" gorm:"primaryKey;autoIncrement"`
+	UserID    int `json:"user_id" gorm:"uniqueIndex"`
+	Resources `gorm:"embedded"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
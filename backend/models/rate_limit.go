@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RateLimitBucket holds tokens left for a rate limit key, used when limits are shared between replicas
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// RevokedSession is a logged out session whose refresh tokens must not be used, kept until they expire
+type RevokedSession struct {
+	SessionID string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -1,12 +1,15 @@
 package sqlite
 
 import (
+	"context"
 	"fmt"
 	"kubecloud/models"
+	"strings"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Sqlite struct implements db interface with sqlite
@@ -14,19 +17,51 @@ type Sqlite struct {
 	db *gorm.DB
 }
 
+// migratedModels are the models whose tables are migrated on startup
+var migratedModels = []interface{}{
+	&models.User{}, &models.Voucher{}, &models.Transaction{}, &models.Quota{},
+	&models.AuditLog{}, &models.AuditHead{}, &models.OutboxMail{}, &models.Notification{}, &models.NotificationPreference{},
+	&models.Webhook{}, &models.WebhookDelivery{}, &models.APIToken{},
+	&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationInvitation{},
+	&models.RateLimitBucket{}, &models.RevokedSession{},
+}
+
+// connectionOptions make transactions take the write lock when they begin, so transactions reading
+// before writing wait for each other instead of failing with database is locked
+const connectionOptions = "_txlock=immediate&_busy_timeout=5000"
+
 // NewSqliteStorage connects to the database file
 func NewSqliteStorage(file string) (*Sqlite, error) {
-	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	separator := "?"
+	if strings.Contains(file, "?") {
+		separator = "&"
+	}
+
+	db, err := gorm.Open(sqlite.Open(file+separator+connectionOptions), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
 	// Migrate models
-	err = db.AutoMigrate(&models.User{}, &models.Voucher{}, models.Transaction{})
+	err = db.AutoMigrate(migratedModels...)
 	if err != nil {
 		return nil, err
 	}
 
+	// response bodies of webhooks used to be kept, they may hold data of services webhooks reached
+	if db.Migrator().HasColumn(&models.WebhookDelivery{}, "response_body") {
+		if err := db.Migrator().DropColumn(&models.WebhookDelivery{}, "response_body"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := registerTracing(db); err != nil {
+		return nil, err
+	}
+	if err := registerMetrics(db); err != nil {
+		return nil, err
+	}
+
 	return &Sqlite{db: db}, nil
 }
 
@@ -39,6 +74,60 @@ func (s *Sqlite) Close() error {
 	return sqlDB.Close()
 }
 
+// Ping checks the database is reachable
+func (s *Sqlite) Ping() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(s.db.Statement.Context)
+}
+
+// CheckMigrations checks every model has its table with all its columns
+func (s *Sqlite) CheckMigrations() error {
+	migrator := s.db.Migrator()
+	for _, model := range migratedModels {
+		statement := &gorm.Statement{DB: s.db}
+		if err := statement.Parse(model); err != nil {
+			return err
+		}
+		table := statement.Schema.Table
+
+		if !migrator.HasTable(table) {
+			return fmt.Errorf("table %s is missing", table)
+		}
+
+		columnTypes, err := migrator.ColumnTypes(table)
+		if err != nil {
+			return err
+		}
+		columns := make(map[string]bool, len(columnTypes))
+		for _, columnType := range columnTypes {
+			columns[columnType.Name()] = true
+		}
+
+		for _, field := range statement.Schema.Fields {
+			if field.DBName != "" && !field.IgnoreMigration && !columns[field.DBName] {
+				return fmt.Errorf("column %s.%s is missing", table, field.DBName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// WithContext returns a DB running queries with ctx
+func (s *Sqlite) WithContext(ctx context.Context) models.DB {
+	return &Sqlite{db: s.db.WithContext(ctx)}
+}
+
+// Transaction runs fn inside a database transaction
+func (s *Sqlite) Transaction(fn func(tx models.DB) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&Sqlite{db: tx})
+	})
+}
+
 // RegisterUser registers a new user to the system
 func (s *Sqlite) RegisterUser(user *models.User) error {
 	return s.db.Create(user).Error
@@ -58,6 +147,29 @@ func (s *Sqlite) GetUserByID(userID int) (models.User, error) {
 	return user, query.Error
 }
 
+// GetUserByOIDCSubject returns user linked to a single sign-on subject if found
+func (s *Sqlite) GetUserByOIDCSubject(subject string) (models.User, error) {
+	var user models.User
+	query := s.db.First(&user, "oidc_subject = ?", subject)
+	return user, query.Error
+}
+
+// UpdateUserAdmin sets the admin role of user
+func (s *Sqlite) UpdateUserAdmin(userID int, admin bool) error {
+	return s.db.Model(&models.User{}).Where("id = ?", userID).Update("admin", admin).Error
+}
+
+// UpdateUserOIDC links user to a single sign-on subject and sets its admin role
+func (s *Sqlite) UpdateUserOIDC(userID int, subject string, admin bool) error {
+	return s.db.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"oidc_subject": subject,
+			"admin":        admin,
+			"verified":     true,
+		}).Error
+}
+
 // UpdateUserByID updates user data by its ID
 func (s *Sqlite) UpdateUserByID(user *models.User) error {
 	return s.db.Model(&models.User{}).
@@ -65,9 +177,10 @@ func (s *Sqlite) UpdateUserByID(user *models.User) error {
 		Updates(user).Error
 }
 
-func (s *Sqlite) UpdatePassword(email string, hashedPassword []byte) error {
+// UpdatePassword sets the password hash of a user
+func (s *Sqlite) UpdatePassword(userID int, hashedPassword []byte) error {
 	result := s.db.Model(&models.User{}).
-		Where("email = ?", email).
+		Where("id = ?", userID).
 		Updates(map[string]interface{}{
 			"password":   hashedPassword,
 			"updated_at": time.Now(),
@@ -78,7 +191,7 @@ func (s *Sqlite) UpdatePassword(email string, hashedPassword []byte) error {
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("no user found with email %s", email)
+		return gorm.ErrRecordNotFound
 	}
 
 	return nil
@@ -112,11 +225,85 @@ func (s *Sqlite) ListAllUsers() ([]models.User, error) {
 
 }
 
-// DeleteUserByID deletes user by its ID
+// CountVerifiedUsers counts verified users which are not deleted
+func (s *Sqlite) CountVerifiedUsers() (int64, error) {
+	var count int64
+	err := s.db.Model(&models.User{}).Where("verified = ?", true).Count(&count).Error
+	return count, err
+}
+
+// SumCreditedBalance sums credited balance of users which are not deleted
+func (s *Sqlite) SumCreditedBalance() (float64, error) {
+	var sum float64
+	err := s.db.Model(&models.User{}).Select("COALESCE(SUM(credited_balance), 0)").Scan(&sum).Error
+	return sum, err
+}
+
+// DeleteUserByID soft deletes user by its ID, its data is kept until anonymized
 func (s *Sqlite) DeleteUserByID(userID int) error {
 	return s.db.Where("id = ?", userID).Delete(&models.User{}).Error
 }
 
+// AnonymizeDeletedUsers wipes personal data of users deleted before the given time along with their mails,
+// personal webhooks, notifications, tokens, memberships and the source IPs of their audit logs.
+// Source IPs of audit logs chained before IP digests were recorded are kept, wiping them breaks the chain.
+func (s *Sqlite) AnonymizeDeletedUsers(deletedBefore time.Time) (int64, error) {
+	var anonymized int64
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var users []models.User
+		err := tx.Unscoped().Select("id", "email").
+			Where("deleted_at IS NOT NULL AND deleted_at < ? AND anonymized = ?", deletedBefore, false).
+			Find(&users).Error
+		if err != nil || len(users) == 0 {
+			return err
+		}
+
+		ids := make([]int, 0, len(users))
+		emails := make([]string, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.ID)
+			emails = append(emails, user.Email)
+		}
+
+		webhooks := tx.Model(&models.Webhook{}).Select("id").Where("user_id IN ? AND organization_id = 0", ids)
+		for _, query := range []*gorm.DB{
+			tx.Where("receiver IN ?", emails).Delete(&models.OutboxMail{}),
+			tx.Where("webhook_id IN (?)", webhooks).Delete(&models.WebhookDelivery{}),
+			tx.Where("user_id IN ? AND organization_id = 0", ids).Delete(&models.Webhook{}),
+			tx.Where("user_id IN ?", ids).Delete(&models.Notification{}),
+			tx.Where("user_id IN ?", ids).Delete(&models.NotificationPreference{}),
+			tx.Where("user_id IN ?", ids).Delete(&models.APIToken{}),
+			tx.Where("user_id IN ?", ids).Delete(&models.OrganizationMember{}),
+			tx.Where("email IN ?", emails).Delete(&models.OrganizationInvitation{}),
+			tx.Model(&models.AuditLog{}).
+				Where("actor_id IN ? AND (hash = '' OR source_ip_digest != '')", ids).
+				Update("source_ip", ""),
+		} {
+			if query.Error != nil {
+				return query.Error
+			}
+		}
+
+		result := tx.Unscoped().Model(&models.User{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"username":     "deleted user",
+				"email":        gorm.Expr("'deleted-' || id || '@deleted.invalid'"),
+				"password":     nil,
+				"mnemonic":     "",
+				"code":         0,
+				"oidc_subject": "",
+				"language":     "",
+				"anonymized":   true,
+			})
+		anonymized = result.RowsAffected
+		return result.Error
+	})
+
+	return anonymized, err
+}
+
 // CreateVoucher creates new voucher in system
 func (s *Sqlite) CreateVoucher(voucher *models.Voucher) error {
 	return s.db.Create(voucher).Error
@@ -133,11 +320,39 @@ func (s *Sqlite) ListAllVouchers() ([]models.Voucher, error) {
 	return vouchers, nil
 }
 
+// SumOutstandingVoucherValue sums value of vouchers neither redeemed nor expired at the given time
+func (s *Sqlite) SumOutstandingVoucherValue(now time.Time) (float64, error) {
+	var sum float64
+	err := s.db.Model(&models.Voucher{}).
+		Where("redeemed = ? AND expires_at > ?", false, now).
+		Select("COALESCE(SUM(value), 0)").
+		Scan(&sum).Error
+	return sum, err
+}
+
+// CountRedeemedVouchers counts redeemed vouchers
+func (s *Sqlite) CountRedeemedVouchers() (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Voucher{}).Where("redeemed = ?", true).Count(&count).Error
+	return count, err
+}
+
 // CreateTransaction creates a payment transaction
 func (s *Sqlite) CreateTransaction(transaction *models.Transaction) error {
 	return s.db.Create(transaction).Error
 }
 
+// ListUserTransactions lists all transactions of a user
+func (s *Sqlite) ListUserTransactions(userID int) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	err := s.db.Where("user_id = ? AND organization_id = 0", userID).Order("created_at asc").Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
 // CreditUserBalance add credited balance to user by its ID
 func (s *Sqlite) CreditUserBalance(userID int, amount float64) error {
 	return s.db.Model(&models.User{}).
@@ -145,3 +360,568 @@ func (s *Sqlite) CreditUserBalance(userID int, amount float64) error {
 		UpdateColumn("credited_balance", gorm.Expr("credited_balance + ?", amount)).
 		Error
 }
+
+// GetUserQuota returns quota overrides of user if found
+func (s *Sqlite) GetUserQuota(userID int) (models.Quota, error) {
+	var quota models.Quota
+	query := s.db.First(&quota, "user_id = ?", userID)
+	return quota, query.Error
+}
+
+// UpsertUserQuota creates or replaces quota overrides of a user
+func (s *Sqlite) UpsertUserQuota(quota *models.Quota) error {
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		UpdateAll: true,
+	}).Create(quota).Error
+}
+
+// DeleteUserQuota removes quota overrides of a user
+func (s *Sqlite) DeleteUserQuota(userID int) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.Quota{}).Error
+}
+
+// CreateAuditLog appends an entry to the audit log
+func (s *Sqlite) CreateAuditLog(entry *models.AuditLog) error {
+	return s.db.Create(entry).Error
+}
+
+// LastChainedAuditLog returns the latest audit log entry that has a hash
+func (s *Sqlite) LastChainedAuditLog() (models.AuditLog, error) {
+	var entry models.AuditLog
+	query := s.db.Where("hash != ''").Order("id desc").First(&entry)
+	return entry, query.Error
+}
+
+// GetAuditHead returns the head of the audit log hash chain
+func (s *Sqlite) GetAuditHead() (models.AuditHead, error) {
+	var head models.AuditHead
+	query := s.db.First(&head)
+	return head, query.Error
+}
+
+// SaveAuditHead creates or moves the head of the audit log hash chain
+func (s *Sqlite) SaveAuditHead(head *models.AuditHead) error {
+	return s.db.Save(head).Error
+}
+
+// ListAuditLogs lists audit log entries matching filter ordered by ID
+func (s *Sqlite) ListAuditLogs(filter models.AuditFilter) ([]models.AuditLog, error) {
+	query := s.db.Model(&models.AuditLog{})
+
+	if filter.AfterID != 0 {
+		query = query.Where("id > ?", filter.AfterID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at <= ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var entries []models.AuditLog
+	if err := query.Order("id asc").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// EnqueueMail adds a mail to the outbox
+func (s *Sqlite) EnqueueMail(mail *models.OutboxMail) error {
+	return s.db.Create(mail).Error
+}
+
+// GetOutboxMail returns outbox mail by its ID
+func (s *Sqlite) GetOutboxMail(id int) (models.OutboxMail, error) {
+	var mail models.OutboxMail
+	query := s.db.First(&mail, "id = ?", id)
+	return mail, query.Error
+}
+
+// ListDueMails lists pending mails which should be attempted before now
+func (s *Sqlite) ListDueMails(now time.Time, limit int) ([]models.OutboxMail, error) {
+	var mails []models.OutboxMail
+
+	err := s.db.Where("status = ? AND next_attempt_at <= ?", models.OutboxPending, now).
+		Order("next_attempt_at asc").
+		Limit(limit).
+		Find(&mails).Error
+	if err != nil {
+		return nil, err
+	}
+	return mails, nil
+}
+
+// ClaimOutboxMail leases a due pending mail until a time so other senders skip it, it reports whether the claim succeeded
+func (s *Sqlite) ClaimOutboxMail(id int, now, until time.Time) (bool, error) {
+	query := s.db.Model(&models.OutboxMail{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.OutboxPending, now).
+		Update("next_attempt_at", until)
+	return query.RowsAffected == 1, query.Error
+}
+
+// ListOutboxMails lists outbox mails newest first, filtered by status if given and paginated if limit is positive
+func (s *Sqlite) ListOutboxMails(status string, limit, offset int) ([]models.OutboxMail, error) {
+	var mails []models.OutboxMail
+
+	query := s.db.Order("id desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+
+	if err := query.Find(&mails).Error; err != nil {
+		return nil, err
+	}
+	return mails, nil
+}
+
+// DeleteSentMailsBefore deletes sent mails delivered before a time, their bodies hold codes and personal data
+func (s *Sqlite) DeleteSentMailsBefore(before time.Time) (int64, error) {
+	query := s.db.Where("status = ? AND sent_at < ?", models.OutboxSent, before).Delete(&models.OutboxMail{})
+	return query.RowsAffected, query.Error
+}
+
+// UpdateOutboxMail saves all fields of an outbox mail
+func (s *Sqlite) UpdateOutboxMail(mail *models.OutboxMail) error {
+	return s.db.Save(mail).Error
+}
+
+// CreateNotification adds a notification to the inbox of a user
+func (s *Sqlite) CreateNotification(notification *models.Notification) error {
+	return s.db.Create(notification).Error
+}
+
+// ListUserNotifications lists notifications of a user, newest first
+func (s *Sqlite) ListUserNotifications(userID int, unreadOnly bool) ([]models.Notification, error) {
+	var notifications []models.Notification
+
+	query := s.db.Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+
+	if err := query.Order("id desc").Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks notifications of a user as read, all unread ones if no IDs are given
+func (s *Sqlite) MarkNotificationsRead(userID int, notificationIDs []int) (int64, error) {
+	query := s.db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if len(notificationIDs) > 0 {
+		query = query.Where("id IN ?", notificationIDs)
+	}
+
+	result := query.Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// ListNotificationPreferences lists preferences a user set
+func (s *Sqlite) ListNotificationPreferences(userID int) ([]models.NotificationPreference, error) {
+	var preferences []models.NotificationPreference
+
+	if err := s.db.Where("user_id = ?", userID).Find(&preferences).Error; err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// UpsertNotificationPreference creates or replaces preference of a user for an event
+func (s *Sqlite) UpsertNotificationPreference(preference *models.NotificationPreference) error {
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "in_app", "webhook"}),
+	}).Create(preference).Error
+}
+
+// CreateWebhook registers a new webhook
+func (s *Sqlite) CreateWebhook(webhook *models.Webhook) error {
+	return s.db.Create(webhook).Error
+}
+
+// GetWebhook returns webhook by its ID
+func (s *Sqlite) GetWebhook(id int) (models.Webhook, error) {
+	var webhook models.Webhook
+	query := s.db.First(&webhook, "id = ?", id)
+	return webhook, query.Error
+}
+
+// ListUserWebhooks lists webhooks registered by a user
+func (s *Sqlite) ListUserWebhooks(userID int) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+
+	if err := s.db.Where("user_id = ? AND organization_id = 0", userID).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// ListEventWebhooks lists active webhooks receiving events of a user, its own and global ones
+func (s *Sqlite) ListEventWebhooks(userID int) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+
+	err := s.db.Where("active = ? AND (global = ? OR (user_id = ? AND organization_id = 0))", true, true, userID).Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// ListOrganizationWebhooks lists webhooks of an organization
+func (s *Sqlite) ListOrganizationWebhooks(organizationID int) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+
+	if err := s.db.Where("organization_id = ?", organizationID).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes webhook by its ID
+func (s *Sqlite) DeleteWebhook(id int) error {
+	return s.db.Where("id = ?", id).Delete(&models.Webhook{}).Error
+}
+
+// CreateWebhookDelivery queues delivery of an event to a webhook
+func (s *Sqlite) CreateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	return s.db.Create(delivery).Error
+}
+
+// ListDueWebhookDeliveries lists pending deliveries which should be attempted before now
+func (s *Sqlite) ListDueWebhookDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := s.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at asc").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDelivery leases a due pending delivery until a time so other dispatchers skip it, it reports whether
+// the claim succeeded
+func (s *Sqlite) ClaimWebhookDelivery(id int, now, until time.Time) (bool, error) {
+	query := s.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.DeliveryPending, now).
+		Update("next_attempt_at", until)
+	return query.RowsAffected == 1, query.Error
+}
+
+// ListWebhookDeliveries lists latest deliveries of a webhook
+func (s *Sqlite) ListWebhookDeliveries(webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	err := s.db.Where("webhook_id = ?", webhookID).Order("id desc").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery saves all fields of a delivery
+func (s *Sqlite) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	return s.db.Save(delivery).Error
+}
+
+// CreateAPIToken stores a new personal access token
+func (s *Sqlite) CreateAPIToken(token *models.APIToken) error {
+	return s.db.Create(token).Error
+}
+
+// GetAPITokenByHash returns personal access token by its hash
+func (s *Sqlite) GetAPITokenByHash(hash string) (models.APIToken, error) {
+	var token models.APIToken
+	query := s.db.First(&token, "hash = ?", hash)
+	return token, query.Error
+}
+
+// ListUserAPITokens lists personal access tokens of a user
+func (s *Sqlite) ListUserAPITokens(userID int) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+
+	if err := s.db.Where("user_id = ?", userID).Order("id desc").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// UpdateAPITokenLastUsed records when a personal access token was last used
+func (s *Sqlite) UpdateAPITokenLastUsed(tokenID int, lastUsed time.Time) error {
+	return s.db.Model(&models.APIToken{}).
+		Where("id = ?", tokenID).
+		UpdateColumn("last_used_at", lastUsed).
+		Error
+}
+
+// RevokeAPIToken revokes a personal access token of a user
+func (s *Sqlite) RevokeAPIToken(userID, tokenID int) error {
+	result := s.db.Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CreateOrganization creates a new organization
+func (s *Sqlite) CreateOrganization(organization *models.Organization) error {
+	return s.db.Create(organization).Error
+}
+
+// GetOrganization returns organization by its ID if found
+func (s *Sqlite) GetOrganization(id int) (models.Organization, error) {
+	var organization models.Organization
+	query := s.db.First(&organization, "id = ?", id)
+	return organization, query.Error
+}
+
+// ListAllOrganizations lists all organizations
+func (s *Sqlite) ListAllOrganizations() ([]models.Organization, error) {
+	var organizations []models.Organization
+
+	if err := s.db.Order("id asc").Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// ListUserOrganizations lists organizations a user is member of with its role
+func (s *Sqlite) ListUserOrganizations(userID int) ([]models.Organization, error) {
+	var organizations []models.Organization
+
+	err := s.db.Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Order("organizations.id asc").
+		Find(&organizations).Error
+	if err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// UpdateOrganizationName renames an organization
+func (s *Sqlite) UpdateOrganizationName(id int, name string) error {
+	return s.db.Model(&models.Organization{}).
+		Where("id = ?", id).
+		Update("name", name).Error
+}
+
+// DeleteOrganization deletes an organization with its members and invitations, its ledger is kept
+func (s *Sqlite) DeleteOrganization(id int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", id).Delete(&models.OrganizationMember{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("organization_id = ?", id).Delete(&models.OrganizationInvitation{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("organization_id = ?", id).Delete(&models.Webhook{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.Organization{}, id).Error
+	})
+}
+
+// CreditOrganizationBalance add credited balance to organization by its ID
+func (s *Sqlite) CreditOrganizationBalance(id int, amount float64) error {
+	return s.db.Model(&models.Organization{}).
+		Where("id = ?", id).
+		UpdateColumn("credited_balance", gorm.Expr("credited_balance + ?", amount)).
+		Error
+}
+
+// ListOrganizationTransactions lists all transactions charged to an organization
+func (s *Sqlite) ListOrganizationTransactions(id int) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	err := s.db.Where("organization_id = ?", id).Order("created_at asc").Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// AddOrganizationMember adds a user to an organization
+func (s *Sqlite) AddOrganizationMember(member *models.OrganizationMember) error {
+	return s.db.Create(member).Error
+}
+
+// GetOrganizationMember returns membership of a user in an organization if found
+func (s *Sqlite) GetOrganizationMember(organizationID, userID int) (models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	query := s.db.First(&member, "organization_id = ? AND user_id = ?", organizationID, userID)
+	return member, query.Error
+}
+
+// ListOrganizationMembers lists members of an organization with their username and email
+func (s *Sqlite) ListOrganizationMembers(organizationID int) ([]models.OrganizationMember, error) {
+	var members []models.OrganizationMember
+
+	err := s.db.Select("organization_members.*, users.username, users.email").
+		Joins("JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", organizationID).
+		Order("organization_members.id asc").
+		Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// CountOrganizationOwners counts owners of an organization
+func (s *Sqlite) CountOrganizationOwners(organizationID int) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.OrganizationMember{}).
+		Where("organization_id = ? AND role = ?", organizationID, models.OrganizationRoleOwner).
+		Count(&count).Error
+	return count, err
+}
+
+// UpdateOrganizationMemberRole changes role of a member
+func (s *Sqlite) UpdateOrganizationMemberRole(organizationID, userID int, role string) error {
+	return s.db.Model(&models.OrganizationMember{}).
+		Where("organization_id = ? AND user_id = ?", organizationID, userID).
+		Update("role", role).Error
+}
+
+// RemoveOrganizationMember removes a user from an organization
+func (s *Sqlite) RemoveOrganizationMember(organizationID, userID int) error {
+	return s.db.Where("organization_id = ? AND user_id = ?", organizationID, userID).
+		Delete(&models.OrganizationMember{}).Error
+}
+
+// CreateOrganizationInvitation stores a new invitation
+func (s *Sqlite) CreateOrganizationInvitation(invitation *models.OrganizationInvitation) error {
+	return s.db.Create(invitation).Error
+}
+
+// GetOrganizationInvitationByHash returns invitation by the hash of its token
+func (s *Sqlite) GetOrganizationInvitationByHash(hash string) (models.OrganizationInvitation, error) {
+	var invitation models.OrganizationInvitation
+	query := s.db.First(&invitation, "hash = ?", hash)
+	return invitation, query.Error
+}
+
+// ListOrganizationInvitations lists invitations of an organization that are not accepted yet
+func (s *Sqlite) ListOrganizationInvitations(organizationID int) ([]models.OrganizationInvitation, error) {
+	var invitations []models.OrganizationInvitation
+
+	err := s.db.Where("organization_id = ? AND accepted_at IS NULL", organizationID).
+		Order("id desc").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// ListUserOrganizationInvitations lists invitations sent to the email of a user or sent by the user
+func (s *Sqlite) ListUserOrganizationInvitations(userID int, email string) ([]models.OrganizationInvitation, error) {
+	var invitations []models.OrganizationInvitation
+
+	err := s.db.Where("email = ? OR invited_by = ?", email, userID).
+		Order("id desc").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// AcceptOrganizationInvitation marks an invitation as accepted
+func (s *Sqlite) AcceptOrganizationInvitation(id int, acceptedAt time.Time) error {
+	return s.db.Model(&models.OrganizationInvitation{}).
+		Where("id = ?", id).
+		Update("accepted_at", acceptedAt).Error
+}
+
+// DeleteOrganizationInvitation deletes a pending invitation of an organization
+func (s *Sqlite) DeleteOrganizationInvitation(organizationID, id int) error {
+	result := s.db.Where("id = ? AND organization_id = ? AND accepted_at IS NULL", id, organizationID).
+		Delete(&models.OrganizationInvitation{})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetRateLimitBucket returns rate limit bucket by its key if found
+func (s *Sqlite) GetRateLimitBucket(key string) (models.RateLimitBucket, error) {
+	var bucket models.RateLimitBucket
+	query := s.db.First(&bucket, "key = ?", key)
+	return bucket, query.Error
+}
+
+// SaveRateLimitBucket creates or updates a rate limit bucket
+func (s *Sqlite) SaveRateLimitBucket(bucket *models.RateLimitBucket) error {
+	return s.db.Save(bucket).Error
+}
+
+// DeleteRateLimitBucketsBefore deletes rate limit buckets not used since before
+func (s *Sqlite) DeleteRateLimitBucketsBefore(before time.Time) (int64, error) {
+	result := s.db.Where("updated_at < ?", before).Delete(&models.RateLimitBucket{})
+	return result.RowsAffected, result.Error
+}
+
+// RevokeSession records a logged out session, revoking it again is a no-op
+func (s *Sqlite) RevokeSession(session *models.RevokedSession) error {
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(session).Error
+}
+
+// IsSessionRevoked reports whether a session was logged out
+func (s *Sqlite) IsSessionRevoked(sessionID string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.RevokedSession{}).Where("session_id = ?", sessionID).Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteExpiredRevokedSessions deletes revoked sessions whose tokens expired before now
+func (s *Sqlite) DeleteExpiredRevokedSessions(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at < ?", now).Delete(&models.RevokedSession{})
+	return result.RowsAffected, result.Error
+}
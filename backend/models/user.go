@@ -1,18 +1,26 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // User represents a user in the system
 type User struct {
-	ID                int       `gorm:"primaryKey;autoIncrement;column:id"`
-	Username          string    `json:"username" binding:"required"`
-	Email             string    `json:"email" gorm:"unique" binding:"required"`
-	Password          []byte    `json:"password" binding:"required"`
-	UpdatedAt         time.Time `json:"updated_at"`
-	Verified          bool      `json:"verified"`
-	Code              int       `json:"code"`
-	Admin             bool      `json:"admin"`
-	CreditCardBalance float64   `json:"credit_card_balance" gorm:"default:0"` // money from credit card
-	CreditedBalance   float64   `json:"credited_balance" gorm:"default:0"`    // manually added by admin or from vouchers
-	Mnemonic          string    `json:"-" gorm:"column:mnemonic"`
+	ID                int            `gorm:"primaryKey;autoIncrement;column:id"`
+	Username          string         `json:"username" binding:"required"`
+	Email             string         `json:"email" gorm:"uniqueIndex:idx_users_email,where:deleted_at IS NULL" binding:"required"` // deleted users free their email
+	Password          []byte         `json:"password" binding:"required"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	Verified          bool           `json:"verified"`
+	Code              int            `json:"code"`
+	Admin             bool           `json:"admin"`
+	CreditCardBalance float64        `json:"credit_card_balance" gorm:"default:0"` // money from credit card
+	CreditedBalance   float64        `json:"credited_balance" gorm:"default:0"`    // manually added by admin or from vouchers
+	Mnemonic          string         `json:"-" gorm:"column:mnemonic"`
+	Language          string         `json:"language" gorm:"default:en"` // preferred language of mails
+	DeletedAt         gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	Anonymized        bool           `json:"-" gorm:"default:false"`
+	OIDCSubject       string         `json:"-" gorm:"column:oidc_subject;index"` // subject at the single sign-on provider
 }
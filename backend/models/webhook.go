@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+const (
+	// DeliveryPending is the status of deliveries waiting to be attempted
+	DeliveryPending = "pending"
+	// DeliverySucceeded is the status of deliveries acknowledged by the endpoint
+	DeliverySucceeded = "succeeded"
+	// DeliveryFailed is the status of deliveries that failed all attempts
+	DeliveryFailed = "failed"
+)
+
+// Webhook is an endpoint receiving events, global webhooks of admins receive events of all users
+// and organization webhooks receive events of their organization instead of their creator
+type Webhook struct {
+	ID             int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID         int       `json:"user_id" gorm:"index"`
+	OrganizationID int       `json:"organization_id" gorm:"index;default:0"`
+	URL            string    `json:"url"`
+	Secret         string    `json:"-"`
+	Events         []string  `json:"events" gorm:"serializer:json"`
+	Global         bool      `json:"global"`
+	Active         bool      `json:"active" gorm:"default:true"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Subscribed reports whether webhook receives event
+func (w Webhook) Subscribed(event string) bool {
+	for _, subscribed := range w.Events {
+		if subscribed == event || subscribed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery logs delivery of an event to a webhook
+type WebhookDelivery struct {
+	ID             int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	WebhookID      int        `json:"webhook_id" gorm:"index"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status" gorm:"index;default:pending"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index"`
+	ResponseStatus int        `json:"response_status"`
+	LatencyMS      int64      `json:"latency_ms"` // of the last attempt
+	LastError      string     `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}